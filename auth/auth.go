@@ -0,0 +1,513 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/lnurl"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"github.com/skip2/go-qrcode"
+)
+
+var (
+	log btclog.Logger
+)
+
+// SetLogger allows the main package to provide a shared logger.
+func SetLogger(l btclog.Logger) { log = l }
+
+const (
+	// SessionCookie is the name of the cookie that holds the session
+	// token of a logged in owner.
+	SessionCookie = "lnaddr_session"
+
+	// loginCookie binds a pending login to the browser that started it,
+	// so that nobody else who learns the k1 can pick up the session.
+	loginCookie = "lnaddr_login"
+
+	// challengeTTL is the time a wallet has to sign a login challenge.
+	challengeTTL = 5 * time.Minute
+
+	// defaultSessionTTL is the lifetime of a session if not configured.
+	defaultSessionTTL = 24 * time.Hour
+)
+
+// Config configures LUD-04 LNURL-auth logins for address owners.
+type Config struct {
+	// Owners maps hex encoded linking keys to the lightning addresses
+	// the key owns.
+	Owners map[string][]string `json:"Owners" toml:"Owners"`
+
+	// SessionTTLSec is the lifetime of a session. Defaults to 24 hours.
+	SessionTTLSec int `json:"SessionTTLSec" toml:"SessionTTLSec"`
+}
+
+// Session is a logged in address owner.
+type Session struct {
+	// LinkingKey is the hex encoded linking key the owner logged in with.
+	LinkingKey string
+
+	// Addresses are the addresses owned by the linking key.
+	Addresses []users.User
+
+	ExpiresAt time.Time
+}
+
+// Owns reports whether the session's owner owns the user with the given name.
+func (s *Session) Owns(name string) bool {
+	for _, u := range s.Addresses {
+		if u.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// challenge is a pending login.
+type challenge struct {
+	secret     string
+	createdAt  time.Time
+	linkingKey string
+}
+
+// Service issues LNURL-auth challenges, verifies the wallet signatures and
+// keeps track of the resulting sessions.
+type Service struct {
+	externalURL string
+	sessionTTL  time.Duration
+	owners      map[string][]users.User
+	secure      bool
+
+	mu         sync.Mutex
+	challenges map[string]*challenge
+	sessions   map[string]*Session
+}
+
+// NewService creates an auth service. All owned addresses are resolved
+// through the user store, so unknown addresses are reported at startup.
+func NewService(cfg Config, externalURL string,
+	store users.Store) (*Service, error) {
+
+	s := &Service{
+		externalURL: externalURL,
+		sessionTTL:  defaultSessionTTL,
+		owners:      make(map[string][]users.User),
+		secure:      strings.HasPrefix(externalURL, "https://"),
+		challenges:  make(map[string]*challenge),
+		sessions:    make(map[string]*Session),
+	}
+	if cfg.SessionTTLSec > 0 {
+		s.sessionTTL = time.Duration(cfg.SessionTTLSec) * time.Second
+	}
+
+	for key, addresses := range cfg.Owners {
+		key = strings.ToLower(key)
+		if _, err := parseLinkingKey(key); err != nil {
+			return nil, fmt.Errorf("invalid linking key %s: %w",
+				key, err)
+		}
+
+		for _, addr := range addresses {
+			user, err := store.Lookup(addr)
+			if err != nil {
+				return nil, fmt.Errorf("address %s of linking "+
+					"key %s: %w", addr, key, err)
+			}
+			s.owners[key] = append(s.owners[key], *user)
+		}
+	}
+
+	return s, nil
+}
+
+func parseLinkingKey(key string) (*btcec.PublicKey, error) {
+	raw, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return btcec.ParsePubKey(raw)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// expire removes outdated challenges and sessions. The caller must hold the
+// mutex.
+func (s *Service) expire(now time.Time) {
+	for k1, c := range s.challenges {
+		if now.Sub(c.createdAt) > challengeTTL {
+			delete(s.challenges, k1)
+		}
+	}
+	for token, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// newChallenge creates a new login challenge and returns its k1 and the
+// secret that binds it to the browser.
+func (s *Service) newChallenge() (string, string, error) {
+	k1, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(time.Now())
+	s.challenges[k1] = &challenge{
+		secret:    secret,
+		createdAt: time.Now(),
+	}
+
+	return k1, secret, nil
+}
+
+// verify checks the signature of a wallet over a pending challenge and marks
+// the challenge as solved by the linking key.
+func (s *Service) verify(k1Hex, sigHex, keyHex string) error {
+	k1, err := hex.DecodeString(k1Hex)
+	if err != nil || len(k1) != 32 {
+		return errors.New("invalid k1")
+	}
+	sigBytes, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	sig, err := ecdsa.ParseDERSignature(sigBytes)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	keyHex = strings.ToLower(keyHex)
+	key, err := parseLinkingKey(keyHex)
+	if err != nil {
+		return errors.New("invalid linking key")
+	}
+
+	if !sig.Verify(k1, key) {
+		return errors.New("invalid signature")
+	}
+
+	if _, ok := s.owners[keyHex]; !ok {
+		return fmt.Errorf("linking key %s doesn't own any address",
+			keyHex)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[k1Hex]
+	if !ok || time.Since(c.createdAt) > challengeTTL {
+		return errors.New("unknown or expired k1")
+	}
+	if c.linkingKey != "" {
+		return errors.New("k1 already used")
+	}
+	c.linkingKey = keyHex
+
+	return nil
+}
+
+// claim exchanges a solved challenge for a session. It returns nil if the
+// challenge hasn't been solved yet.
+func (s *Service) claim(k1, secret string) (string, *Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[k1]
+	if !ok || time.Since(c.createdAt) > challengeTTL {
+		return "", nil, errors.New("unknown or expired k1")
+	}
+	if subtle.ConstantTimeCompare([]byte(c.secret), []byte(secret)) != 1 {
+		return "", nil, errors.New("login was started elsewhere")
+	}
+	if c.linkingKey == "" {
+		return "", nil, nil
+	}
+	delete(s.challenges, k1)
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	session := &Session{
+		LinkingKey: c.linkingKey,
+		Addresses:  s.owners[c.linkingKey],
+		ExpiresAt:  time.Now().Add(s.sessionTTL),
+	}
+	s.sessions[token] = session
+
+	return token, session, nil
+}
+
+// Session returns the session of the request, if any.
+func (s *Service) Session(r *http.Request) *Session {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[cookie.Value]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil
+	}
+
+	return session
+}
+
+type sessionKey struct{}
+
+// SessionFromContext returns the session stored in the context by
+// RequireOwner.
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+// RequireOwner only passes requests of logged in owners on to the handler.
+// Everybody else is redirected to the login page.
+func (s *Service) RequireOwner(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := s.Session(r)
+		if session == nil {
+			next := url.Values{"next": {r.URL.RequestURI()}}
+			http.Redirect(
+				w, r, "/auth/login?"+next.Encode(),
+				http.StatusSeeOther,
+			)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), sessionKey{}, session)
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// localPath returns next if it is a path on this server and "/" otherwise.
+// Browsers treat backslashes like slashes, so /\evil.com leaves the server
+// just like //evil.com.
+func localPath(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(u.Path, "//") ||
+		strings.ContainsRune(next, '\\') ||
+		strings.ContainsRune(u.Path, '\\') {
+
+		return "/"
+	}
+
+	return next
+}
+
+// HandleLogin renders the login page with a fresh challenge.
+func (s *Service) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	k1, secret, err := s.newChallenge()
+	if err != nil {
+		log.Errorf("Unable to create login challenge: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	callback, err := url.JoinPath(s.externalURL, "auth", "lnurl")
+	if err != nil {
+		log.Errorf("Invalid external URL: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	callback += "?" + url.Values{
+		"tag":    {"login"},
+		"k1":     {k1},
+		"action": {"login"},
+	}.Encode()
+
+	encoded, err := lnurl.Encode(callback)
+	if err != nil {
+		log.Errorf("Unable to encode login URL: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	png, err := qrcode.Encode(encoded, qrcode.Medium, 256)
+	if err != nil {
+		log.Errorf("Unable to encode QR code: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only allow local redirects after the login.
+	next := localPath(r.URL.Query().Get("next"))
+
+	var buf bytes.Buffer
+	err = loginTemplate.Execute(&buf, map[string]interface{}{
+		"K1":    k1,
+		"LNURL": encoded,
+		"QRCode": template.URL(
+			"data:image/png;base64," +
+				base64.StdEncoding.EncodeToString(png),
+		),
+		"Next": next,
+	})
+	if err != nil {
+		log.Errorf("Unable to render login page: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    secret,
+		Path:     "/auth/",
+		MaxAge:   int(challengeTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// HandleLNURL is the LUD-04 callback the wallet calls with its signature.
+func (s *Service) HandleLNURL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := r.URL.Query()
+	if q.Get("tag") != "login" {
+		lnurl.WriteError(w, http.StatusBadRequest, "Invalid tag.")
+		return
+	}
+
+	err := s.verify(q.Get("k1"), q.Get("sig"), q.Get("key"))
+	if err != nil {
+		log.Infof("LNURL-auth login failed: %v", err)
+		lnurl.WriteError(
+			w, http.StatusBadRequest, "Login failed: "+err.Error(),
+		)
+		return
+	}
+
+	log.Infof("Linking key %s logged in", q.Get("key"))
+	lnurl.WriteOK(w)
+}
+
+// HandlePoll is polled by the login page until the wallet signed the
+// challenge. It then sets the session cookie.
+func (s *Service) HandlePoll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		lnurl.WriteError(w, http.StatusForbidden, "No login pending.")
+		return
+	}
+
+	token, session, err := s.claim(r.URL.Query().Get("k1"), cookie.Value)
+	if err != nil {
+		lnurl.WriteError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if session == nil {
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(lnurl.Response{
+			Status: "PENDING",
+		})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	lnurl.WriteOK(w)
+}
+
+// HandleLogout ends the session of the request.
+func (s *Service) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		s.mu.Lock()
+		delete(s.sessions, cookie.Value)
+		s.mu.Unlock()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Login</title>
+</head>
+<body>
+	<h1>Login with Lightning</h1>
+	<p>Scan the QR code with a wallet that supports LNURL-auth.</p>
+	<a href="lightning:{{.LNURL}}"><img src="{{.QRCode}}" alt="LNURL-auth QR code"/></a>
+	<p id="status">Waiting for your wallet...</p>
+	<script>
+	(function poll() {
+		fetch("/auth/poll?k1={{.K1}}", {credentials: "same-origin"})
+			.then(function(resp) {
+				if (resp.status === 200) {
+					window.location = {{.Next}};
+				} else if (resp.status === 202) {
+					setTimeout(poll, 2000);
+				} else {
+					document.getElementById("status").textContent =
+						"Login expired, please reload the page.";
+				}
+			})
+			.catch(function() { setTimeout(poll, 5000); });
+	})();
+	</script>
+</body>
+</html>
+`))
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+}
+
+var k1Regex = regexp.MustCompile(`k1=([0-9a-f]{64})`)
+
+type testServer struct {
+	*httptest.Server
+
+	svc    *Service
+	client *http.Client
+}
+
+func newTestServer(t *testing.T, owners map[string][]string) *testServer {
+	t.Helper()
+
+	store, err := users.NewConfigStore([]string{
+		"alice@example.com", "bob@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	svc, err := NewService(Config{Owners: owners}, "http://localhost", store)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/login", svc.HandleLogin)
+	mux.HandleFunc("GET /auth/lnurl", svc.HandleLNURL)
+	mux.HandleFunc("GET /auth/poll", svc.HandlePoll)
+	mux.HandleFunc("GET /private", svc.RequireOwner(
+		func(w http.ResponseWriter, r *http.Request) {
+			session := SessionFromContext(r.Context())
+			_, _ = io.WriteString(w, session.Addresses[0].Name)
+		},
+	))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &testServer{Server: ts, svc: svc, client: client}
+}
+
+// startLogin fetches the login page and returns the challenge.
+func (ts *testServer) startLogin(t *testing.T) string {
+	t.Helper()
+
+	resp, err := ts.client.Get(ts.URL + "/auth/login")
+	if err != nil {
+		t.Fatalf("GET login: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	m := k1Regex.FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("no k1 in login page")
+	}
+
+	return string(m[1])
+}
+
+func (ts *testServer) get(t *testing.T, c *http.Client, path string) (int,
+	string) {
+
+	t.Helper()
+
+	resp, err := c.Get(ts.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(body)
+}
+
+// sign returns the LUD-04 callback query for the challenge signed by key.
+func sign(key *btcec.PrivateKey, k1 string) string {
+	k1Bytes, _ := hex.DecodeString(k1)
+	sig := ecdsa.Sign(key, k1Bytes)
+
+	return "/auth/lnurl?" + url.Values{
+		"tag": {"login"},
+		"k1":  {k1},
+		"sig": {hex.EncodeToString(sig.Serialize())},
+		"key": {hex.EncodeToString(key.PubKey().SerializeCompressed())},
+	}.Encode()
+}
+
+func TestLogin(t *testing.T) {
+	key, _ := btcec.NewPrivateKey()
+	pub := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	ts := newTestServer(t, map[string][]string{pub: {"Alice"}})
+
+	// Without a session, owners are sent to the login page.
+	code, _ := ts.get(t, ts.client, "/private")
+	if code != http.StatusSeeOther {
+		t.Fatalf("expected redirect to login, got %d", code)
+	}
+
+	k1 := ts.startLogin(t)
+
+	code, _ = ts.get(t, ts.client, "/auth/poll?k1="+k1)
+	if code != http.StatusAccepted {
+		t.Fatalf("expected pending login, got %d", code)
+	}
+
+	// The wallet signs the challenge.
+	code, body := ts.get(t, http.DefaultClient, sign(key, k1))
+	if code != http.StatusOK {
+		t.Fatalf("expected successful login, got %d: %s", code, body)
+	}
+
+	// Somebody else who knows the k1 can't pick up the session.
+	jar, _ := cookiejar.New(nil)
+	other := &http.Client{Jar: jar}
+	code, _ = ts.get(t, other, "/auth/poll?k1="+k1)
+	if code != http.StatusForbidden {
+		t.Fatalf("expected foreign poll to fail, got %d", code)
+	}
+
+	code, _ = ts.get(t, ts.client, "/auth/poll?k1="+k1)
+	if code != http.StatusOK {
+		t.Fatalf("expected login to complete, got %d", code)
+	}
+
+	code, body = ts.get(t, ts.client, "/private")
+	if code != http.StatusOK || body != "alice" {
+		t.Fatalf("expected access as alice, got %d: %s", code, body)
+	}
+
+	// A challenge can only be used once.
+	code, _ = ts.get(t, http.DefaultClient, sign(key, k1))
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected reused k1 to fail, got %d", code)
+	}
+}
+
+func TestLogin_Rejected(t *testing.T) {
+	owner, _ := btcec.NewPrivateKey()
+	stranger, _ := btcec.NewPrivateKey()
+	pub := hex.EncodeToString(owner.PubKey().SerializeCompressed())
+	ts := newTestServer(t, map[string][]string{pub: {"bob"}})
+
+	k1 := ts.startLogin(t)
+
+	// A key that doesn't own any address can't log in.
+	code, _ := ts.get(t, http.DefaultClient, sign(stranger, k1))
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected unknown key to fail, got %d", code)
+	}
+
+	// Neither can a signature by another key than the given one.
+	q := sign(stranger, k1)
+	u, _ := url.Parse(q)
+	v := u.Query()
+	v.Set("key", pub)
+	code, _ = ts.get(t, http.DefaultClient, "/auth/lnurl?"+v.Encode())
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected invalid signature to fail, got %d", code)
+	}
+
+	// Nor a challenge we never issued.
+	k1Bytes := make([]byte, 32)
+	code, _ = ts.get(
+		t, http.DefaultClient, sign(owner, hex.EncodeToString(k1Bytes)),
+	)
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected unknown k1 to fail, got %d", code)
+	}
+
+	code, _ = ts.get(t, ts.client, "/auth/poll?k1="+k1)
+	if code != http.StatusAccepted {
+		t.Fatalf("expected login to be still pending, got %d", code)
+	}
+}
+
+func TestNewService_InvalidOwners(t *testing.T) {
+	store, _ := users.NewConfigStore([]string{"alice@example.com"})
+
+	_, err := NewService(Config{
+		Owners: map[string][]string{"nokey": {"alice"}},
+	}, "https://example.com", store)
+	if err == nil {
+		t.Fatalf("expected error for invalid linking key")
+	}
+
+	key, _ := btcec.NewPrivateKey()
+	pub := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	_, err = NewService(Config{
+		Owners: map[string][]string{pub: {"carol"}},
+	}, "https://example.com", store)
+	if err == nil {
+		t.Fatalf("expected error for unknown address")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for next, expected := range map[string]string{
+		"":                       "/",
+		"/":                      "/",
+		"/dashboard?days=7":      "/dashboard?days=7",
+		"dashboard":              "/",
+		"//evil.com":             "/",
+		"/\\evil.com":            "/",
+		"/%5Cevil.com":           "/",
+		"/%2F/evil.com":          "/",
+		"https://evil.com":       "/",
+		"https://evil.com/owner": "/",
+	} {
+		if path := localPath(next); path != expected {
+			t.Errorf("localPath(%q) = %q, expected %q", next, path,
+				expected)
+		}
+	}
+}
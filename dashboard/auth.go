@@ -0,0 +1,150 @@
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hieblmi/go-host-lnaddr/auth"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// AuthLNURL logs owners in with their wallet through LNURL-auth.
+	AuthLNURL = "lnurl-auth"
+
+	// AuthBasic logs owners in with HTTP basic auth.
+	AuthBasic = "basic"
+)
+
+// Owner is an address owner that logs in with HTTP basic auth.
+type Owner struct {
+	// PasswordHash is the bcrypt hash of the owner's password.
+	PasswordHash string `json:"PasswordHash" toml:"PasswordHash"`
+
+	// Addresses are the lightning addresses the owner may see.
+	Addresses []string `json:"Addresses" toml:"Addresses"`
+}
+
+// OwnerHandler handles a request of an authenticated owner.
+type OwnerHandler func(w http.ResponseWriter, r *http.Request,
+	addresses []users.User)
+
+// Authenticator identifies the owner of a request.
+type Authenticator interface {
+	// Wrap only passes requests of authenticated owners on to the handler,
+	// along with the addresses they own.
+	Wrap(h OwnerHandler) http.HandlerFunc
+}
+
+// lnurlAuth authenticates owners through LNURL-auth sessions.
+type lnurlAuth struct {
+	svc *auth.Service
+}
+
+// NewLNURLAuth returns an authenticator that uses the sessions of the
+// LNURL-auth service.
+func NewLNURLAuth(svc *auth.Service) (Authenticator, error) {
+	if svc == nil {
+		return nil, errors.New("LNURL-auth isn't configured")
+	}
+
+	return &lnurlAuth{svc: svc}, nil
+}
+
+// Wrap redirects owners that aren't logged in to the login page.
+func (a *lnurlAuth) Wrap(h OwnerHandler) http.HandlerFunc {
+	return a.svc.RequireOwner(func(w http.ResponseWriter, r *http.Request) {
+		session := auth.SessionFromContext(r.Context())
+		h(w, r, session.Addresses)
+	})
+}
+
+// basicAuth authenticates owners with HTTP basic auth.
+type basicAuth struct {
+	owners map[string]*basicOwner
+
+	// dummyHash is compared against for unknown usernames, so they take
+	// as long as wrong passwords.
+	dummyHash []byte
+}
+
+type basicOwner struct {
+	hash      []byte
+	addresses []users.User
+}
+
+// NewBasicAuth returns an authenticator that checks HTTP basic auth
+// credentials against the given owners. All owned addresses are resolved
+// through the user store.
+func NewBasicAuth(owners map[string]Owner,
+	store users.Store) (Authenticator, error) {
+
+	a := &basicAuth{owners: make(map[string]*basicOwner, len(owners))}
+	for name, owner := range owners {
+		_, err := bcrypt.Cost([]byte(owner.PasswordHash))
+		if err != nil {
+			return nil, fmt.Errorf("invalid password hash of "+
+				"owner %s: %w", name, err)
+		}
+
+		o := &basicOwner{hash: []byte(owner.PasswordHash)}
+		for _, address := range owner.Addresses {
+			user, err := store.Lookup(address)
+			if err != nil {
+				return nil, fmt.Errorf("address %s of owner "+
+					"%s: %w", address, name, err)
+			}
+			o.addresses = append(o.addresses, *user)
+		}
+		a.owners[name] = o
+	}
+
+	dummy, err := bcrypt.GenerateFromPassword(
+		[]byte("dummy"), bcrypt.DefaultCost,
+	)
+	if err != nil {
+		return nil, err
+	}
+	a.dummyHash = dummy
+
+	return a, nil
+}
+
+// Wrap asks for credentials if the request doesn't carry valid ones.
+func (a *basicAuth) Wrap(h OwnerHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := a.authenticate(r)
+		if owner == nil {
+			w.Header().Set(
+				"WWW-Authenticate",
+				`Basic realm="dashboard", charset="UTF-8"`,
+			)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r, owner.addresses)
+	}
+}
+
+func (a *basicAuth) authenticate(r *http.Request) *basicOwner {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	owner, known := a.owners[name]
+	hash := a.dummyHash
+	if known {
+		hash = owner.hash
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if !known || err != nil {
+		return nil
+	}
+
+	return owner
+}
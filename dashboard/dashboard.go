@@ -0,0 +1,280 @@
+package dashboard
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/invoice"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// defaultMaxPayments is the number of payments listed if not configured.
+const defaultMaxPayments = 100
+
+var (
+	log btclog.Logger
+)
+
+// SetLogger allows the main package to provide a shared logger.
+func SetLogger(l btclog.Logger) { log = l }
+
+// Config configures the owner dashboard.
+type Config struct {
+	// Auth selects how owners log in, either "lnurl-auth" or "basic".
+	Auth string `json:"Auth" toml:"Auth"`
+
+	// Owners are the owners that log in with basic auth, keyed by
+	// username.
+	Owners map[string]Owner `json:"Owners" toml:"Owners"`
+
+	// Timezone is the IANA time zone the daily and monthly totals are
+	// computed in. Defaults to the server's local time zone.
+	Timezone string `json:"Timezone" toml:"Timezone"`
+
+	// MaxPayments is the number of most recent payments listed.
+	// Defaults to 100.
+	MaxPayments int `json:"MaxPayments" toml:"MaxPayments"`
+}
+
+// HandlerConfig holds the dependencies of the dashboard.
+type HandlerConfig struct {
+	Config
+
+	Invoices *invoice.Store
+	Auth     Authenticator
+}
+
+// Handler renders the payments received by an owner's addresses.
+type Handler struct {
+	Cfg *HandlerConfig
+
+	location *time.Location
+}
+
+// NewHandler creates a dashboard handler.
+func NewHandler(cfg *HandlerConfig) (*Handler, error) {
+	location := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MaxPayments <= 0 {
+		cfg.MaxPayments = defaultMaxPayments
+	}
+
+	return &Handler{Cfg: cfg, location: location}, nil
+}
+
+// payment is a received payment as shown on the dashboard.
+type payment struct {
+	Time      time.Time
+	Address   string
+	Sats      int64
+	Comment   string
+	ZapSender string
+}
+
+// total sums up the payments of a day or month.
+type total struct {
+	Period string
+	Count  int
+	Msat   int64
+}
+
+// Sats returns the summed up amount in full satoshis. Amounts are summed in
+// msat, so the remainders of the payments aren't lost.
+func (t total) Sats() int64 {
+	return t.Msat / 1000
+}
+
+type view struct {
+	Addresses []users.User
+	Selected  string
+	Payments  []payment
+	Days      []total
+	Months    []total
+	Total     total
+}
+
+// ServeHTTP renders the dashboard of the authenticated owner.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Cfg.Auth.Wrap(h.render)(w, r)
+}
+
+func (h *Handler) render(w http.ResponseWriter, r *http.Request,
+	addresses []users.User) {
+
+	v, err := h.buildView(addresses, r.URL.Query().Get("address"))
+	if err != nil {
+		log.Errorf("Unable to load payments: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := dashboardTemplate.Execute(w, v); err != nil {
+		log.Errorf("Unable to render dashboard: %v", err)
+	}
+}
+
+// buildView collects the payments of the owned addresses. If selected names
+// one of them, only its payments are shown.
+func (h *Handler) buildView(addresses []users.User,
+	selected string) (*view, error) {
+
+	v := &view{Addresses: addresses}
+
+	var records []*invoice.Record
+	for _, u := range addresses {
+		if selected != "" && selected != u.Name {
+			continue
+		}
+		v.Selected = selected
+
+		settled, err := h.Cfg.Invoices.Settled(
+			u.Address, time.Time{}, 0,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, settled...)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SettledAt.After(records[j].SettledAt)
+	})
+
+	days := make(map[string]*total)
+	months := make(map[string]*total)
+	for _, rec := range records {
+		settledAt := rec.SettledAt.In(h.location)
+		msat := rec.AmountPaidMsat
+
+		add(days, settledAt.Format("2006-01-02"), msat)
+		add(months, settledAt.Format("2006-01"), msat)
+		v.Total.Count++
+		v.Total.Msat += msat
+
+		if len(v.Payments) >= h.Cfg.MaxPayments {
+			continue
+		}
+		v.Payments = append(v.Payments, payment{
+			Time:      settledAt,
+			Address:   rec.Address,
+			Sats:      msat / 1000,
+			Comment:   rec.Comment,
+			ZapSender: npub(rec.ZapSender),
+		})
+	}
+	v.Days = sorted(days)
+	v.Months = sorted(months)
+
+	return v, nil
+}
+
+func add(totals map[string]*total, period string, msat int64) {
+	t, ok := totals[period]
+	if !ok {
+		t = &total{Period: period}
+		totals[period] = t
+	}
+	t.Count++
+	t.Msat += msat
+}
+
+// sorted returns the totals, most recent period first.
+func sorted(totals map[string]*total) []total {
+	list := make([]total, 0, len(totals))
+	for _, t := range totals {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Period > list[j].Period
+	})
+
+	return list
+}
+
+// npub encodes a hex nostr pubkey as npub. Invalid keys are returned as is.
+func npub(pubkey string) string {
+	if pubkey == "" {
+		return ""
+	}
+
+	encoded, err := nip19.EncodePublicKey(pubkey)
+	if err != nil {
+		return pubkey
+	}
+
+	return encoded
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Received payments</title>
+	<style>
+		body { font-family: sans-serif; margin: 2em; }
+		table { border-collapse: collapse; margin-bottom: 2em; }
+		th, td { padding: 0.3em 0.8em; text-align: left;
+			border-bottom: 1px solid #ddd; }
+		td.num { text-align: right; }
+		td.comment { max-width: 30em; overflow-wrap: anywhere; }
+	</style>
+</head>
+<body>
+	<h1>Received payments</h1>
+	<p>
+		<a href="?">All addresses</a>
+		{{range .Addresses}}| <a href="?address={{.Name}}">{{.Address}}</a>
+		{{end}}
+	</p>
+	<p>{{.Total.Count}} payments, {{.Total.Sats}} sats in total.</p>
+
+	<h2>Payments</h2>
+	<table>
+		<tr><th>Time</th><th>Address</th><th>Sats</th><th>Comment</th>
+			<th>Zap sender</th></tr>
+		{{range .Payments}}
+		<tr>
+			<td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+			<td>{{.Address}}</td>
+			<td class="num">{{.Sats}}</td>
+			<td class="comment">{{.Comment}}</td>
+			<td>{{.ZapSender}}</td>
+		</tr>
+		{{else}}
+		<tr><td colspan="5">No payments yet.</td></tr>
+		{{end}}
+	</table>
+
+	<h2>Per month</h2>
+	<table>
+		<tr><th>Month</th><th>Payments</th><th>Sats</th></tr>
+		{{range .Months}}
+		<tr><td>{{.Period}}</td><td class="num">{{.Count}}</td>
+			<td class="num">{{.Sats}}</td></tr>
+		{{end}}
+	</table>
+
+	<h2>Per day</h2>
+	<table>
+		<tr><th>Day</th><th>Payments</th><th>Sats</th></tr>
+		{{range .Days}}
+		<tr><td>{{.Period}}</td><td class="num">{{.Count}}</td>
+			<td class="num">{{.Sats}}</td></tr>
+		{{end}}
+	</table>
+</body>
+</html>
+`))
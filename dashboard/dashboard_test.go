@@ -0,0 +1,194 @@
+package dashboard
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/invoice"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+}
+
+const zapSender = "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	db, err := bbolt.Open(
+		filepath.Join(t.TempDir(), "test.db"), 0600, nil,
+	)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	invoices, err := invoice.NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	utc := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []*invoice.Record{
+		{PaymentHash: "a1", Address: "alice@example.com",
+			Comment: "<b>thanks</b>", ZapSender: zapSender},
+		{PaymentHash: "a2", Address: "alice@example.com"},
+		{PaymentHash: "a3", Address: "alice@example.com"},
+		{PaymentHash: "b1", Address: "bob@example.com",
+			Comment: "for bob"},
+	}
+	settledAt := []time.Time{
+		utc, utc.Add(time.Hour), utc.AddDate(0, -1, 0), utc,
+	}
+	for i, rec := range records {
+		if err := invoices.Add(rec); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		err := invoices.Settle(rec.PaymentHash, 21_500, settledAt[i])
+		if err != nil {
+			t.Fatalf("Settle: %v", err)
+		}
+	}
+
+	// An unpaid invoice isn't listed.
+	err = invoices.Add(&invoice.Record{
+		PaymentHash: "a4", Address: "alice@example.com",
+		Comment: "unpaid",
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	store, err := users.NewConfigStore([]string{
+		"alice@example.com", "bob@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+	hash, _ := bcrypt.GenerateFromPassword(
+		[]byte("secret"), bcrypt.MinCost,
+	)
+	authenticator, err := NewBasicAuth(map[string]Owner{
+		"alice": {
+			PasswordHash: string(hash),
+			Addresses:    []string{"alice"},
+		},
+	}, store)
+	if err != nil {
+		t.Fatalf("NewBasicAuth: %v", err)
+	}
+
+	h, err := NewHandler(&HandlerConfig{
+		Config:   Config{Timezone: "UTC"},
+		Invoices: invoices,
+		Auth:     authenticator,
+	})
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func get(t *testing.T, url, user, password string) (int, string) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestDashboard(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, body := get(t, ts.URL, "alice", "secret")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	for _, want := range []string{
+		// Totals are summed in msat before they are rounded down.
+		"3 payments, 64 sats in total.",
+		"&lt;b&gt;thanks&lt;/b&gt;",
+		"npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6",
+		"<td>2024-03-01</td><td class=\"num\">2</td>\n\t\t\t" +
+			"<td class=\"num\">43</td>",
+		"<td>2024-03</td><td class=\"num\">2</td>\n\t\t\t" +
+			"<td class=\"num\">43</td>",
+		"<td>2024-02</td><td class=\"num\">1</td>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in dashboard:\n%s", want, body)
+		}
+	}
+
+	// Only alice's paid invoices are shown.
+	for _, unwanted := range []string{"for bob", "unpaid", "<b>"} {
+		if strings.Contains(body, unwanted) {
+			t.Fatalf("unexpected %q in dashboard", unwanted)
+		}
+	}
+
+	// Owners can't look at addresses they don't own.
+	_, body = get(t, ts.URL+"?address=bob", "alice", "secret")
+	if !strings.Contains(body, "0 payments") {
+		t.Fatalf("expected no payments for foreign address:\n%s", body)
+	}
+}
+
+func TestDashboard_Unauthorized(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, creds := range [][2]string{
+		{"", ""}, {"alice", "wrong"}, {"bob", "secret"},
+	} {
+		code, _ := get(t, ts.URL, creds[0], creds[1])
+		if code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for %v, got %d", creds, code)
+		}
+	}
+}
+
+func TestNewBasicAuth_Invalid(t *testing.T) {
+	store, _ := users.NewConfigStore([]string{"alice@example.com"})
+
+	_, err := NewBasicAuth(map[string]Owner{
+		"alice": {PasswordHash: "plain", Addresses: []string{"alice"}},
+	}, store)
+	if err == nil {
+		t.Fatalf("expected error for invalid password hash")
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	_, err = NewBasicAuth(map[string]Owner{
+		"alice": {
+			PasswordHash: string(hash),
+			Addresses:    []string{"carol"},
+		},
+	}, store)
+	if err == nil {
+		t.Fatalf("expected error for unknown address")
+	}
+}
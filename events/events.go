@@ -0,0 +1,300 @@
+// Package events streams the payments received by an address to overlays
+// and other live clients through Server-Sent Events and WebSockets.
+package events
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/hieblmi/go-host-lnaddr/invoice"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+const (
+	// defaultReplaySize is the number of events kept per address for
+	// replay if not configured.
+	defaultReplaySize = 50
+
+	// keepaliveInterval is the interval in which idle streams are pinged
+	// so that proxies don't close them.
+	keepaliveInterval = 15 * time.Second
+
+	// writeTimeout is the time a client has to accept a WebSocket message.
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	log btclog.Logger
+)
+
+// SetLogger allows the main package to provide a shared logger.
+func SetLogger(l btclog.Logger) { log = l }
+
+// Config configures the live event streams.
+type Config struct {
+	// Tokens maps lightning addresses to the token that grants read access
+	// to their events. Addresses without a token can't be subscribed to.
+	Tokens map[string]string `json:"Tokens" toml:"Tokens"`
+
+	// ReplaySize is the number of recent events per address that are
+	// replayed to reconnecting clients. Defaults to 50.
+	ReplaySize int `json:"ReplaySize" toml:"ReplaySize"`
+}
+
+// Service publishes settled invoices to the subscribers of their address.
+type Service struct {
+	hub    *Hub
+	store  users.Store
+	tokens map[string]string
+}
+
+// NewService creates the event service. All addresses with a token are
+// resolved through the user store, so unknown addresses are reported at
+// startup.
+func NewService(cfg Config, store users.Store) (*Service, error) {
+	replaySize := cfg.ReplaySize
+	if replaySize <= 0 {
+		replaySize = defaultReplaySize
+	}
+
+	s := &Service{
+		hub:    NewHub(replaySize),
+		store:  store,
+		tokens: make(map[string]string, len(cfg.Tokens)),
+	}
+	for address, token := range cfg.Tokens {
+		if token == "" {
+			return nil, fmt.Errorf("empty token for %s", address)
+		}
+
+		user, err := store.Lookup(address)
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %w", address, err)
+		}
+		s.tokens[user.Address] = token
+	}
+
+	return s, nil
+}
+
+// HandleSettlement publishes a settled invoice. It is meant to be registered
+// as listener with the settlement handler.
+func (s *Service) HandleSettlement(settlement *invoice.Settlement) {
+	if _, ok := s.tokens[settlement.Address]; !ok {
+		return
+	}
+
+	s.hub.Publish(Event{
+		Address:     settlement.Address,
+		PaymentHash: settlement.PaymentHash,
+		AmountMsat:  settlement.AmountMsat,
+		AmountSat:   settlement.AmountMsat / 1000,
+		Comment:     settlement.Comment,
+		ZapSender:   settlement.ZapSender,
+		SettledAt:   settlement.SettledAt,
+	})
+}
+
+// authorize resolves the address of the request and checks its read token,
+// which is either given as bearer token or as token query parameter for
+// clients that can't set headers.
+func (s *Service) authorize(w http.ResponseWriter, r *http.Request) (string,
+	bool) {
+
+	user, err := s.store.Lookup(r.PathValue("user"))
+	if err != nil && !errors.Is(err, users.ErrUnknownUser) &&
+		!errors.Is(err, users.ErrInvalidName) {
+
+		log.Errorf("Unable to look up user %s: %v",
+			r.PathValue("user"), err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return "", false
+	}
+
+	given := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(
+		r.Header.Get("Authorization"), "Bearer ",
+	); ok {
+		given = bearer
+	}
+
+	// Unknown users and addresses without a token are indistinguishable
+	// from a wrong token, so addresses can't be enumerated.
+	var token string
+	if user != nil {
+		token = s.tokens[user.Address]
+	}
+	if token == "" || subtle.ConstantTimeCompare(
+		[]byte(given), []byte(token),
+	) != 1 {
+
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	return user.Address, true
+}
+
+// lastEventID returns the ID of the last event the client saw. Browsers send
+// it in the Last-Event-ID header when an EventSource reconnects, other
+// clients may use the lastEventId query parameter.
+func lastEventID(r *http.Request) uint64 {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("lastEventId")
+	}
+
+	lastID, _ := strconv.ParseUint(id, 10, 64)
+
+	return lastID
+}
+
+// HandleSSE streams the events of an address as Server-Sent Events.
+func (s *Service) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	address, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported",
+			http.StatusInternalServerError)
+		return
+	}
+
+	replay, ch, cancel := s.hub.Subscribe(address, lastEventID(r))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, "retry: 3000\n\n")
+
+	for _, ev := range replay {
+		if err := writeSSE(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: payment\ndata: %s\n\n",
+		ev.ID, data)
+
+	return err
+}
+
+// HandleWebSocket streams the events of an address as JSON messages over a
+// WebSocket.
+func (s *Service) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	address, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	// Access is granted by the token alone, so any origin may connect.
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		log.Debugf("WebSocket handshake failed: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	replay, ch, cancel := s.hub.Subscribe(address, lastEventID(r))
+	defer cancel()
+
+	// We don't expect any messages, but have to read to handle control
+	// frames and notice when the client goes away.
+	ctx := conn.CloseRead(r.Context())
+
+	for _, ev := range replay {
+		if err := writeWS(ctx, conn, ev); err != nil {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				conn.Close(
+					websocket.StatusTryAgainLater,
+					"too slow",
+				)
+				return
+			}
+			if err := writeWS(ctx, conn, ev); err != nil {
+				return
+			}
+
+		case <-keepalive.C:
+			pingCtx, cancel := context.WithTimeout(
+				ctx, writeTimeout,
+			)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func writeWS(ctx context.Context, conn *websocket.Conn, ev Event) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	return wsjson.Write(ctx, conn, ev)
+}
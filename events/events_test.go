@@ -0,0 +1,234 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/hieblmi/go-host-lnaddr/invoice"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+}
+
+func newTestServer(t *testing.T) (*Service, *httptest.Server) {
+	t.Helper()
+
+	store, err := users.NewConfigStore([]string{
+		"alice@example.com", "bob@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	svc, err := NewService(Config{
+		Tokens:     map[string]string{"alice": "secret"},
+		ReplaySize: 2,
+	}, store)
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events/{user}", svc.HandleSSE)
+	mux.HandleFunc("GET /events/{user}/ws", svc.HandleWebSocket)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return svc, ts
+}
+
+func settle(svc *Service, address, comment string) {
+	svc.HandleSettlement(&invoice.Settlement{
+		Address:    address,
+		AmountMsat: 21_000,
+		Comment:    comment,
+		SettledAt:  time.Now(),
+	})
+}
+
+// sseClient reads events from an SSE stream.
+type sseClient struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+func openSSE(t *testing.T, url, lastID string) *sseClient {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	return &sseClient{resp: resp, scanner: bufio.NewScanner(resp.Body)}
+}
+
+// next returns the ID and payload of the next event.
+func (c *sseClient) next(t *testing.T) (string, Event) {
+	t.Helper()
+
+	var (
+		id string
+		ev Event
+	)
+	for c.scanner.Scan() {
+		line := c.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+		case line == "" && id != "":
+			return id, ev
+		}
+	}
+	t.Fatalf("stream ended: %v", c.scanner.Err())
+
+	return "", ev
+}
+
+func TestSSE(t *testing.T) {
+	svc, ts := newTestServer(t)
+
+	client := openSSE(t, ts.URL+"/events/alice", "")
+
+	// Wait for the subscription before publishing.
+	for client.scanner.Scan() && client.scanner.Text() != "" {
+	}
+	settle(svc, "bob@example.com", "not for alice")
+	settle(svc, "alice@example.com", "hello")
+
+	id, ev := client.next(t)
+	if ev.Comment != "hello" || ev.AmountSat != 21 ||
+		strconv.FormatUint(ev.ID, 10) != id {
+
+		t.Fatalf("unexpected event %s: %+v", id, ev)
+	}
+
+	// Events published while the client is away are replayed when it
+	// reconnects with the last ID it saw. Only the most recent ones are
+	// kept.
+	client.resp.Body.Close()
+	settle(svc, "alice@example.com", "one")
+	settle(svc, "alice@example.com", "two")
+	settle(svc, "alice@example.com", "three")
+
+	client = openSSE(t, ts.URL+"/events/alice", id)
+	for _, want := range []string{"two", "three"} {
+		_, ev := client.next(t)
+		if ev.Comment != want {
+			t.Fatalf("expected replay of %q, got %+v", want, ev)
+		}
+	}
+}
+
+func TestSSE_Unauthorized(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	for _, path := range []string{
+		"/events/alice", "/events/alice?token=wrong",
+		"/events/bob?token=secret", "/events/unknown?token=secret",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", path,
+				resp.StatusCode)
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	svc, ts := newTestServer(t)
+
+	settle(svc, "alice@example.com", "before")
+	first := svc.hub.recent["alice@example.com"][0].ID
+	settle(svc, "alice@example.com", "missed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") +
+		"/events/alice/ws?token=secret&lastEventId=" +
+		strconv.FormatUint(first, 10)
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var ev Event
+	if err := wsjson.Read(ctx, conn, &ev); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if ev.Comment != "missed" {
+		t.Fatalf("expected replayed event, got %+v", ev)
+	}
+
+	// Wait until the subscription is registered.
+	for {
+		svc.hub.mu.Lock()
+		n := len(svc.hub.subscribers["alice@example.com"])
+		svc.hub.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	settle(svc, "alice@example.com", "live")
+	if err := wsjson.Read(ctx, conn, &ev); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if ev.Comment != "live" {
+		t.Fatalf("expected live event, got %+v", ev)
+	}
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := NewHub(10)
+	_, ch, cancel := h.Subscribe("a", 0)
+	defer cancel()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.Publish(Event{Address: "a"})
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("expected %d buffered events before disconnect, got %d",
+			subscriberBuffer, n)
+	}
+}
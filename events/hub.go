@@ -0,0 +1,112 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events buffered for a subscriber. A
+// subscriber that falls further behind is disconnected and has to reconnect
+// with the last event ID it saw.
+const subscriberBuffer = 16
+
+// Event is a payment received by an address.
+type Event struct {
+	ID          uint64    `json:"id"`
+	Address     string    `json:"address"`
+	PaymentHash string    `json:"paymentHash"`
+	AmountMsat  int64     `json:"amountMsat"`
+	AmountSat   int64     `json:"amountSat"`
+	Comment     string    `json:"comment,omitempty"`
+	ZapSender   string    `json:"zapSender,omitempty"`
+	SettledAt   time.Time `json:"settledAt"`
+}
+
+// Hub fans out the events of an address to its subscribers and keeps the
+// most recent ones for replay.
+type Hub struct {
+	replaySize int
+
+	mu          sync.Mutex
+	lastID      uint64
+	recent      map[string][]Event
+	subscribers map[string]map[chan Event]struct{}
+}
+
+// NewHub creates a hub that keeps replaySize events per address.
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		replaySize: replaySize,
+
+		// Event IDs start at the current time, so IDs clients saw
+		// before a restart don't hide new events.
+		lastID:      uint64(time.Now().UnixNano()),
+		recent:      make(map[string][]Event),
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// Publish assigns the next ID to the event and sends it to all subscribers
+// of its address.
+func (h *Hub) Publish(ev Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	ev.ID = h.lastID
+
+	recent := append(h.recent[ev.Address], ev)
+	if len(recent) > h.replaySize {
+		recent = recent[len(recent)-h.replaySize:]
+	}
+	h.recent[ev.Address] = recent
+
+	for ch := range h.subscribers[ev.Address] {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subscribers[ev.Address], ch)
+			close(ch)
+		}
+	}
+
+	return ev
+}
+
+// Subscribe returns the recent events of the address after lastID and a
+// channel for new ones. If lastID is zero, nothing is replayed. The channel is
+// closed if the subscriber falls behind. The returned function ends the
+// subscription.
+func (h *Hub) Subscribe(address string, lastID uint64) ([]Event,
+	<-chan Event, func()) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []Event
+	if lastID != 0 {
+		for _, ev := range h.recent[address] {
+			if ev.ID > lastID {
+				replay = append(replay, ev)
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	if h.subscribers[address] == nil {
+		h.subscribers[address] = make(map[chan Event]struct{})
+	}
+	h.subscribers[address][ch] = struct{}{}
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subscribers[address][ch]; ok {
+			delete(h.subscribers[address], ch)
+			close(ch)
+		}
+	}
+
+	return replay, ch, cancel
+}
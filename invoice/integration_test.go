@@ -96,8 +96,11 @@ func makeZapRequest(t *testing.T, amountMsat int, recipientPub string) string {
 func TestInvoiceCreationWithZapRequest_FollowsSpecBasics(t *testing.T) {
 	// Setup: fake LND client and manager
 	fl := &mockLightningClient{}
-	sh := NewSettlementHandler(fl, "") // empty nsec to skip signing/publish path
-	mgr := NewInvoiceManager(&ManagerConfig{LndClient: fl, SettlementHandler: sh})
+	sh := NewSettlementHandler(fl, "", nil) // empty nsec to skip signing/publish path
+	mgr, err := NewInvoiceManager(&ManagerConfig{LndClient: fl, SettlementHandler: sh})
+	if err != nil {
+		t.Fatalf("NewInvoiceManager: %v", err)
+	}
 
 	// HTTP server with only the invoice handler
 	mux := http.NewServeMux()
@@ -164,11 +167,14 @@ func TestInvoiceCreationWithZapRequest_FollowsSpecBasics(t *testing.T) {
 
 func TestInvoiceCreationWithZapRequest_AmountMismatchIs400(t *testing.T) {
 	fl := &mockLightningClient{}
-	sh := NewSettlementHandler(fl, "")
-	mgr := NewInvoiceManager(&ManagerConfig{
+	sh := NewSettlementHandler(fl, "", nil)
+	mgr, err := NewInvoiceManager(&ManagerConfig{
 		LndClient:         fl,
 		SettlementHandler: sh},
 	)
+	if err != nil {
+		t.Fatalf("NewInvoiceManager: %v", err)
+	}
 
 	mux := http.NewServeMux()
 	cfg := Config{MinSendableMsat: 1, MaxSendableMsat: 100000000}
@@ -3,17 +3,31 @@ package invoice
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/lnurl"
+	"github.com/hieblmi/go-host-lnaddr/notifier"
+	"github.com/hieblmi/go-host-lnaddr/users"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/nbd-wtf/go-nostr"
 )
 
+const (
+	// defaultInvoiceExpiry is the expiry lnd uses for invoices that
+	// don't specify one.
+	defaultInvoiceExpiry = 24 * time.Hour
+
+	// maxPayerDataLength bounds the LUD-18 payer data we accept.
+	maxPayerDataLength = 1024
+)
+
 var (
 	log btclog.Logger
 )
@@ -27,6 +41,10 @@ type Config struct {
 	MaxSendableMsat  int
 	MaxCommentLength int
 	SuccessMessage   string
+
+	// InvoiceExpiry is the expiry of created invoices. If zero, lnd's
+	// default of 24 hours is used.
+	InvoiceExpiry time.Duration
 }
 
 // Invoice is the JSON response for a created invoice.
@@ -34,6 +52,10 @@ type Invoice struct {
 	Pr            string         `json:"pr"`
 	Routes        []string       `json:"routes"`
 	SuccessAction *SuccessAction `json:"successAction"`
+
+	// PaymentHash isn't part of LUD-06, it lets web pages poll the
+	// invoice status without decoding the invoice.
+	PaymentHash string `json:"paymentHash,omitempty"`
 }
 
 // SuccessAction optionally contains a success message.
@@ -42,42 +64,98 @@ type SuccessAction struct {
 	Message string `json:"message,omitempty"`
 }
 
+// writeError writes a LUD-06 error response with the given HTTP status code.
+func writeError(w http.ResponseWriter, code int, reason string,
+	args ...interface{}) {
+
+	lnurl.WriteError(w, code, fmt.Sprintf(reason, args...))
+}
+
 func badRequestError(w http.ResponseWriter, reason string,
 	args ...interface{}) {
 
-	w.WriteHeader(http.StatusBadRequest)
-	_ = json.NewEncoder(w).Encode(map[string]string{
-		"status": "Error",
-		"reason": fmt.Sprintf(reason, args...),
-	})
+	writeError(w, http.StatusBadRequest, reason, args...)
 }
 
 // Manager coordinates invoice creation and related concerns.
 type Manager struct {
 	Cfg *ManagerConfig
+
+	limiter *limiter
+
+	// waiters are the status requests waiting for settlements.
+	waiters *settlementWaiters
 }
 
 type ManagerConfig struct {
 	LndClient         lnrpc.LightningClient
 	SettlementHandler *SettlementHandler
+
+	// Users resolves the username of the invoice callback path. If nil,
+	// the username isn't checked.
+	Users users.Store
+
+	// Limits configures the rate limits and caps of the invoice
+	// callback.
+	Limits LimitConfig
+
+	// Store records the created invoices. It must be the store of the
+	// SettlementHandler, which records their settlement. If nil, invoices
+	// aren't recorded.
+	Store *Store
 }
 
 type Params struct {
 	Msat            int64
 	Description     string
 	DescriptionHash []byte
+	Expiry          time.Duration
 }
 
 type zapReceipt struct {
 	event       nostr.Event
 	description string
 	relays      []string
+
+	// sender is the pubkey of the zap request's author and eventID the
+	// zapped event, if any.
+	sender  string
+	eventID string
+}
+
+func NewInvoiceManager(cfg *ManagerConfig) (*Manager, error) {
+	l, err := newLimiter(cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		Cfg:     cfg,
+		limiter: l,
+		waiters: newSettlementWaiters(),
+	}
+	if cfg.Store != nil {
+		cfg.SettlementHandler.AddListener(m.waiters.settled)
+	}
+
+	return m, nil
 }
 
-func NewInvoiceManager(cfg *ManagerConfig) *Manager {
-	return &Manager{
-		Cfg: cfg,
+// resolveAddress returns the lightning address the invoice is requested for.
+// Callbacks without a username are accepted for backwards compatibility and
+// resolve to an empty address.
+func (m *Manager) resolveAddress(r *http.Request) (string, error) {
+	name := r.PathValue("user")
+	if name == "" || m.Cfg.Users == nil {
+		return name, nil
+	}
+
+	user, err := m.Cfg.Users.Lookup(name)
+	if err != nil {
+		return "", err
 	}
+
+	return user.Address, nil
 }
 
 func (m *Manager) processZapRequest(zapRequest []string,
@@ -168,6 +246,10 @@ func (m *Manager) processZapRequest(zapRequest []string,
 	if len(tP) > 0 {
 		receiptTags = append(receiptTags, nostr.Tag{"P", tP[0]})
 	}
+	var eventID string
+	if len(te) > 0 {
+		eventID = te[0]
+	}
 
 	return &zapReceipt{
 		event: nostr.Event{
@@ -176,6 +258,8 @@ func (m *Manager) processZapRequest(zapRequest []string,
 		},
 		relays:      relays,
 		description: string(description),
+		sender:      e.PubKey,
+		eventID:     eventID,
 	}
 }
 
@@ -184,6 +268,28 @@ func (m *Manager) HandleInvoiceCreation(config Config) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
+		address, err := m.resolveAddress(r)
+		switch {
+		case errors.Is(err, users.ErrUnknownUser),
+			errors.Is(err, users.ErrInvalidName):
+
+			writeError(w, http.StatusNotFound, "Unknown user.")
+			return
+
+		case err != nil:
+			log.Errorf("Unable to look up user: %v", err)
+			writeError(w, http.StatusInternalServerError,
+				"Unable to look up user.")
+			return
+		}
+
+		if limit, ok := m.limiter.allow(r, address); !ok {
+			limitsHit.WithLabelValues(limit).Inc()
+			writeError(w, http.StatusTooManyRequests, "Too many "+
+				"invoice requests, please try again later.")
+			return
+		}
+
 		keys, hasAmount := r.URL.Query()["amount"]
 		if !hasAmount || len(keys[0]) < 1 {
 			badRequestError(
@@ -219,6 +325,12 @@ func (m *Manager) HandleInvoiceCreation(config Config) http.HandlerFunc {
 			return
 		}
 
+		payerData, err := parsePayerData(r.URL.Query().Get("payerdata"))
+		if err != nil {
+			badRequestError(w, "Invalid payerdata: %v", err)
+			return
+		}
+
 		metadata := comment
 		zapRequest, hasNostr := r.URL.Query()["nostr"]
 		var zapReceipt *zapReceipt
@@ -235,41 +347,145 @@ func (m *Manager) HandleInvoiceCreation(config Config) http.HandlerFunc {
 		invoiceParams := Params{
 			Msat:        int64(mSat),
 			Description: metadata,
+			Expiry:      config.InvoiceExpiry,
 		}
 
 		h := sha256.Sum256([]byte(invoiceParams.Description))
 		invoiceParams.DescriptionHash = h[:]
 
-		bolt11, r_hash, err := m.MakeInvoice(invoiceParams)
-		if err != nil {
+		bolt11, r_hash, err := m.createInvoice(invoiceParams,
+			&pendingInvoice{
+				address:    address,
+				comment:    comment,
+				payerData:  payerData,
+				zapReceipt: zapReceipt,
+			},
+		)
+		switch {
+		case errors.Is(err, errTooManyInvoices):
+			writeError(w, http.StatusServiceUnavailable, "Too "+
+				"many open invoices, please try again later.")
+			return
+
+		case err != nil:
 			log.Infof("Cannot create invoice: %s", err)
 			badRequestError(w, "Invoice creation failed.")
 			return
 		}
 
-		if zapReceipt != nil {
-			zapReceipt.event.Tags = append(
-				zapReceipt.event.Tags,
-				nostr.Tag{"bolt11", bolt11},
-			)
-		}
-
 		invoice := Invoice{
-			Pr:     bolt11,
-			Routes: make([]string, 0),
+			Pr:          bolt11,
+			Routes:      make([]string, 0),
+			PaymentHash: hex.EncodeToString(r_hash),
 			SuccessAction: &SuccessAction{
 				Tag:     "message",
 				Message: config.SuccessMessage,
 			},
 		}
-		m.Cfg.SettlementHandler.subscribeInvoiceSettlements(
-			context.Background(), r_hash, comment, zapReceipt,
-		)
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(invoice)
 	}
 }
 
+// parsePayerData decodes the LUD-18 payer data a wallet may send along with
+// the invoice request.
+func parsePayerData(raw string) (*notifier.PayerData, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if len(raw) > maxPayerDataLength {
+		return nil, fmt.Errorf("longer than %d bytes",
+			maxPayerDataLength)
+	}
+
+	payerData := &notifier.PayerData{}
+	if err := json.Unmarshal([]byte(raw), payerData); err != nil {
+		return nil, err
+	}
+
+	return payerData, nil
+}
+
+// errTooManyInvoices is returned if MaxPendingInvoices invoices are open.
+var errTooManyInvoices = errors.New("too many open invoices")
+
+// CreateInvoice creates an invoice to the address the same way the invoices
+// of LNURL-pay requests are made: it is recorded in the store and settles
+// like a payment to the address. The description is recorded as comment.
+func (m *Manager) CreateInvoice(address string, params Params) (string,
+	[]byte, error) {
+
+	return m.createInvoice(params, &pendingInvoice{
+		address: address,
+		comment: params.Description,
+	})
+}
+
+// createInvoice creates the invoice of the pending invoice, records it and
+// subscribes to its settlement.
+func (m *Manager) createInvoice(params Params, inv *pendingInvoice) (string,
+	[]byte, error) {
+
+	sh := m.Cfg.SettlementHandler
+	if !sh.reserve(m.Cfg.Limits.MaxPendingInvoices) {
+		limitsHit.WithLabelValues(limitPending).Inc()
+		return "", nil, errTooManyInvoices
+	}
+
+	bolt11, rHash, err := m.MakeInvoice(params)
+	if err != nil {
+		sh.release()
+		return "", nil, err
+	}
+
+	if inv.zapReceipt != nil {
+		inv.zapReceipt.event.Tags = append(
+			inv.zapReceipt.event.Tags, nostr.Tag{"bolt11", bolt11},
+		)
+	}
+
+	expiry := params.Expiry
+	if expiry == 0 {
+		expiry = defaultInvoiceExpiry
+	}
+	now := time.Now()
+	inv.rHash = rHash
+	inv.expiresAt = now.Add(expiry)
+	m.recordInvoice(&Record{
+		PaymentHash: hex.EncodeToString(rHash),
+		Address:     inv.address,
+		AmountMsat:  params.Msat,
+		Comment:     inv.comment,
+		CreatedAt:   now,
+		ExpiresAt:   inv.expiresAt,
+	}, inv.zapReceipt)
+
+	if err := sh.subscribeInvoiceSettlements(inv); err != nil {
+		sh.release()
+		log.Warnf("Unable to subscribe to invoice %x: %v", rHash, err)
+	}
+
+	return bolt11, rHash, nil
+}
+
+// recordInvoice stores a created invoice if a store is configured. Invoices
+// of the legacy callback without a username aren't recorded as they can't be
+// attributed to an address.
+func (m *Manager) recordInvoice(rec *Record, zap *zapReceipt) {
+	if m.Cfg.Store == nil || rec.Address == "" {
+		return
+	}
+
+	if zap != nil {
+		rec.ZapSender = zap.sender
+		rec.ZapEventID = zap.eventID
+	}
+	if err := m.Cfg.Store.Add(rec); err != nil {
+		log.Errorf("Unable to record invoice %s: %v", rec.PaymentHash,
+			err)
+	}
+}
+
 func (m *Manager) MakeInvoice(params Params) (string, []byte,
 	error) {
 
@@ -277,6 +493,7 @@ func (m *Manager) MakeInvoice(params Params) (string, []byte,
 		ValueMsat:       params.Msat,
 		Memo:            params.Description,
 		DescriptionHash: params.DescriptionHash,
+		Expiry:          int64(params.Expiry.Seconds()),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -0,0 +1,220 @@
+package invoice
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterIdleTimeout is the time after which the token bucket of an
+	// inactive client is dropped.
+	limiterIdleTimeout = 10 * time.Minute
+
+	limitIP      = "ip"
+	limitAddress = "address"
+	limitPending = "pending"
+)
+
+// limitsHit counts the invoice requests that were rejected because a limit
+// tripped.
+var limitsHit = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "lnaddr",
+		Subsystem: "invoice",
+		Name:      "limited_total",
+		Help:      "Invoice requests rejected by a rate limit or cap.",
+	}, []string{"limit"},
+)
+
+func init() {
+	prometheus.MustRegister(limitsHit)
+}
+
+// LimitConfig protects the invoice callback against clients that try to make
+// our node create an unbounded number of invoices. A zero value disables the
+// respective limit.
+type LimitConfig struct {
+	// PerIPRate is the sustained number of invoices per second a single
+	// client IP may request.
+	PerIPRate float64 `json:"PerIPRate" toml:"PerIPRate"`
+
+	// PerIPBurst is the number of invoices a single client IP may
+	// request at once.
+	PerIPBurst int `json:"PerIPBurst" toml:"PerIPBurst"`
+
+	// PerAddressRate is the sustained number of invoices per second that
+	// may be requested for a single lightning address.
+	PerAddressRate float64 `json:"PerAddressRate" toml:"PerAddressRate"`
+
+	// PerAddressBurst is the number of invoices that may be requested at
+	// once for a single lightning address.
+	PerAddressBurst int `json:"PerAddressBurst" toml:"PerAddressBurst"`
+
+	// MaxPendingInvoices caps the number of created invoices that are
+	// neither settled nor expired yet.
+	MaxPendingInvoices int `json:"MaxPendingInvoices" toml:"MaxPendingInvoices"`
+
+	// TrustedProxies lists the IPs or CIDRs of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers are used to determine the
+	// client IP.
+	TrustedProxies []string `json:"TrustedProxies" toml:"TrustedProxies"`
+}
+
+// keyedLimiter holds one token bucket per key.
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(perSecond float64, burst int) *keyedLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &keyedLimiter{
+		limit:     rate.Limit(perSecond),
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether another request for the given key is allowed. A nil
+// limiter allows everything.
+func (k *keyedLimiter) allow(key string) bool {
+	if k == nil {
+		return true
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.lastSweep) > limiterIdleTimeout {
+		for key, b := range k.buckets {
+			if now.Sub(b.lastSeen) > limiterIdleTimeout {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastSweep = now
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(k.limit, k.burst)}
+		k.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	return b.limiter.AllowN(now, 1)
+}
+
+// limiter bundles all limits that apply to the invoice callback.
+type limiter struct {
+	perIP      *keyedLimiter
+	perAddress *keyedLimiter
+	trusted    []*net.IPNet
+}
+
+func newLimiter(cfg LimitConfig) (*limiter, error) {
+	l := &limiter{
+		perIP:      newKeyedLimiter(cfg.PerIPRate, cfg.PerIPBurst),
+		perAddress: newKeyedLimiter(cfg.PerAddressRate, cfg.PerAddressBurst),
+	}
+
+	for _, p := range cfg.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %w",
+				p, err)
+		}
+		l.trusted = append(l.trusted, ipNet)
+	}
+
+	return l, nil
+}
+
+func (l *limiter) isTrusted(ip net.IP) bool {
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP determines the IP of the client that sent the request. Proxy
+// headers are only taken into account if the request came from a trusted
+// proxy, otherwise clients could simply spoof them to evade the per-IP limit.
+func (l *limiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !l.isTrusted(remote) {
+		return host
+	}
+
+	// Walk X-Forwarded-For from right to left, the first address that
+	// isn't one of our proxies is the client.
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if !l.isTrusted(ip) {
+			return ip.String()
+		}
+	}
+
+	if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip.String()
+	}
+
+	return host
+}
+
+// allow checks the per-IP and per-address limits for the request and returns
+// the name of the limit that tripped, if any.
+func (l *limiter) allow(r *http.Request, address string) (string, bool) {
+	if !l.perIP.allow(l.clientIP(r)) {
+		return limitIP, false
+	}
+	if !l.perAddress.allow(address) {
+		return limitAddress, false
+	}
+
+	return "", true
+}
@@ -0,0 +1,186 @@
+package invoice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+func TestLimiter_ClientIP(t *testing.T) {
+	l, err := newLimiter(LimitConfig{
+		TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"},
+	})
+	if err != nil {
+		t.Fatalf("newLimiter: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		xrip   string
+		want   string
+	}{{
+		name:   "untrusted remote ignores headers",
+		remote: "1.2.3.4:5555",
+		xff:    "9.9.9.9",
+		want:   "1.2.3.4",
+	}, {
+		name:   "trusted proxy uses forwarded for",
+		remote: "10.0.0.1:5555",
+		xff:    "9.9.9.9",
+		want:   "9.9.9.9",
+	}, {
+		name:   "spoofed hops left of the client are ignored",
+		remote: "10.0.0.1:5555",
+		xff:    "6.6.6.6, 9.9.9.9, 192.168.1.1",
+		want:   "9.9.9.9",
+	}, {
+		name:   "trusted proxy falls back to real ip",
+		remote: "10.0.0.1:5555",
+		xrip:   "8.8.8.8",
+		want:   "8.8.8.8",
+	}}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/invoice/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.xrip != "" {
+			r.Header.Set("X-Real-IP", tc.xrip)
+		}
+
+		if got := l.clientIP(r); got != tc.want {
+			t.Errorf("%s: want %s got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestNewLimiter_InvalidProxy(t *testing.T) {
+	_, err := newLimiter(LimitConfig{TrustedProxies: []string{"nope"}})
+	if err == nil {
+		t.Fatalf("expected error for invalid trusted proxy")
+	}
+}
+
+func newLimitedServer(t *testing.T, limits LimitConfig) *httptest.Server {
+	t.Helper()
+
+	store, err := users.NewConfigStore([]string{
+		"alice@example.com", "bob@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	fl := &mockLightningClient{}
+	mgr, err := NewInvoiceManager(&ManagerConfig{
+		LndClient:         fl,
+		SettlementHandler: NewSettlementHandler(fl, "", nil),
+		Users:             store,
+		Limits:            limits,
+	})
+	if err != nil {
+		t.Fatalf("NewInvoiceManager: %v", err)
+	}
+
+	cfg := Config{MinSendableMsat: 1, MaxSendableMsat: 100000000}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invoice/{user}", mgr.HandleInvoiceCreation(cfg))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func getStatus(t *testing.T, url string) (int, map[string]string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+
+	return resp.StatusCode, body
+}
+
+func TestInvoiceCreation_PerIPLimit(t *testing.T) {
+	ts := newLimitedServer(t, LimitConfig{
+		PerIPRate:  0.001,
+		PerIPBurst: 2,
+	})
+
+	for i := 0; i < 2; i++ {
+		code, _ := getStatus(t, ts.URL+"/invoice/alice?amount=1000")
+		if code != http.StatusCreated {
+			t.Fatalf("request %d: expected 201, got %d", i, code)
+		}
+	}
+
+	code, body := getStatus(t, ts.URL+"/invoice/bob?amount=1000")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+	if body["status"] != "ERROR" {
+		t.Errorf("expected LUD-06 error, got %v", body)
+	}
+}
+
+func TestInvoiceCreation_PerAddressLimit(t *testing.T) {
+	ts := newLimitedServer(t, LimitConfig{
+		PerAddressRate:  0.001,
+		PerAddressBurst: 1,
+	})
+
+	code, _ := getStatus(t, ts.URL+"/invoice/alice?amount=1000")
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	code, _ = getStatus(t, ts.URL+"/invoice/ALICE?amount=1000")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+	code, _ = getStatus(t, ts.URL+"/invoice/bob?amount=1000")
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201 for other address, got %d", code)
+	}
+}
+
+func TestInvoiceCreation_MaxPendingInvoices(t *testing.T) {
+	ts := newLimitedServer(t, LimitConfig{MaxPendingInvoices: 1})
+
+	code, _ := getStatus(t, ts.URL+"/invoice/alice?amount=1000")
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+
+	// The mock never settles the first invoice, so it stays pending.
+	code, body := getStatus(t, ts.URL+"/invoice/bob?amount=1000")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+	if body["status"] != "ERROR" {
+		t.Errorf("expected LUD-06 error, got %v", body)
+	}
+}
+
+func TestInvoiceCreation_UnknownUser(t *testing.T) {
+	ts := newLimitedServer(t, LimitConfig{})
+
+	code, body := getStatus(t, ts.URL+"/invoice/carol?amount=1000")
+	if code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+	if body["status"] != "ERROR" {
+		t.Errorf("expected LUD-06 error, got %v", body)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/hex"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hieblmi/go-host-lnaddr/notifier"
@@ -17,17 +18,91 @@ import (
 type SettlementHandler struct {
 	lndClient lnrpc.LightningClient
 	nsec      string
+
+	// store records settled invoices, if set.
+	store *Store
+
+	listenersMu sync.RWMutex
+	listeners   []SettlementListener
+
+	// pending is the number of invoices we wait for to be settled.
+	pending atomic.Int64
+}
+
+// Settlement describes a settled invoice.
+type Settlement struct {
+	PaymentHash string
+	Address     string
+	AmountMsat  int64
+	Comment     string
+
+	// PayerData is set if the wallet sent LUD-18 payer data.
+	PayerData *notifier.PayerData
+
+	// ZapSender is the hex encoded nostr pubkey of the zap request's
+	// author and ZapEventID the zapped event, if the payment was a zap.
+	ZapSender  string
+	ZapEventID string
+
+	// Preimage is the hex encoded preimage of the payment.
+	Preimage string
+
+	SettledAt time.Time
+}
+
+// payment converts the settlement to the event passed to notifiers.
+func (s *Settlement) payment() *notifier.Payment {
+	return &notifier.Payment{
+		Address:     s.Address,
+		AmountMsat:  uint64(s.AmountMsat),
+		Comment:     s.Comment,
+		PayerData:   s.PayerData,
+		ZapSender:   s.ZapSender,
+		ZapEventID:  s.ZapEventID,
+		PaymentHash: s.PaymentHash,
+		Preimage:    s.Preimage,
+		SettledAt:   s.SettledAt,
+	}
+}
+
+// SettlementListener is called for every settled invoice. Listeners are
+// called sequentially, so they must not block.
+type SettlementListener func(*Settlement)
+
+// settlementGracePeriod is the time we keep waiting for a settlement after the
+// invoice expired, to account for payments that were in flight.
+const settlementGracePeriod = time.Minute
+
+// pendingInvoice is an invoice we created and wait for to be settled.
+type pendingInvoice struct {
+	rHash      []byte
+	address    string
+	comment    string
+	payerData  *notifier.PayerData
+	zapReceipt *zapReceipt
+	expiresAt  time.Time
 }
 
-func NewSettlementHandler(
-	lndClient lnrpc.LightningClient, nsec string) *SettlementHandler {
+// NewSettlementHandler creates a settlement handler. Settlements are
+// recorded in the store, which may be nil.
+func NewSettlementHandler(lndClient lnrpc.LightningClient, nsec string,
+	store *Store) *SettlementHandler {
 
 	return &SettlementHandler{
 		lndClient: lndClient,
 		nsec:      nsec,
+		store:     store,
 	}
 }
 
+// AddListener registers a listener for settled invoices.
+func (s *SettlementHandler) AddListener(l SettlementListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	s.listeners = append(s.listeners, l)
+}
+
 func publishZapReceipt(zapReceipt *zapReceipt) {
 	zapctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
@@ -61,55 +136,134 @@ func publishZapReceipt(zapReceipt *zapReceipt) {
 	wg.Wait()
 }
 
+// reserve accounts for a new pending invoice. It returns false if there are
+// already max pending invoices. A max of zero means unlimited.
+func (s *SettlementHandler) reserve(max int) bool {
+	for {
+		current := s.pending.Load()
+		if max > 0 && current >= int64(max) {
+			return false
+		}
+		if s.pending.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+// release removes a pending invoice that was accounted for by reserve.
+func (s *SettlementHandler) release() {
+	s.pending.Add(-1)
+}
+
 // subscribeInvoiceSettlements subscribes to invoice updates and triggers side
-// effects.
-func (s *SettlementHandler) subscribeInvoiceSettlements(ctx context.Context,
-	rHash []byte, comment string, zapReceipt *zapReceipt) error {
+// effects once the invoice is settled. The subscription ends when the invoice
+// is settled or expired, after which the pending invoice is released.
+func (s *SettlementHandler) subscribeInvoiceSettlements(
+	inv *pendingInvoice) error {
 
+	ctx, cancel := context.WithDeadline(
+		context.Background(), inv.expiresAt.Add(settlementGracePeriod),
+	)
 	stream, err := s.lndClient.SubscribeInvoices(
 		ctx, &lnrpc.InvoiceSubscription{},
 	)
 	if err != nil {
+		cancel()
 		return err
 	}
 
 	go func() {
+		defer s.release()
+		defer cancel()
+
 		for {
 			invoice, err := stream.Recv()
 			if err != nil {
-				log.Warnf("invoice stream error: %v", err)
+				if ctx.Err() != nil {
+					log.Debugf("Stopped waiting for "+
+						"invoice %x: %v", inv.rHash,
+						ctx.Err())
+				} else {
+					log.Warnf("invoice stream error: %v",
+						err)
+				}
 
 				return
 			}
 
-			if invoice.State != lnrpc.Invoice_SETTLED {
+			if invoice.State != lnrpc.Invoice_SETTLED ||
+				!bytes.Equal(invoice.RHash, inv.rHash) {
+
 				continue
 			}
 
-			if bytes.Equal(invoice.RHash, rHash) {
-				notifier.BroadcastNotification(
-					uint64(invoice.AmtPaidSat), comment,
-				)
-				if zapReceipt != nil && s.nsec != "" {
-					zapReceipt.event.CreatedAt = nostr.Timestamp(invoice.SettleDate)
-					zapReceipt.event.Tags = append(
-						zapReceipt.event.Tags,
-						nostr.Tag{"preimage",
-							hex.EncodeToString(invoice.RPreimage)})
-					err = zapReceipt.event.Sign(s.nsec)
-					if err != nil {
-						log.Warnf("Error signing zap "+
-							"receipt: %s", err)
-					} else {
-						log.Infof("Publishing zap "+
-							"receipt: %+v",
-							zapReceipt.event)
-						go publishZapReceipt(zapReceipt)
-					}
-				}
-			}
+			s.handleSettlement(inv, invoice)
+
+			return
 		}
 	}()
 
 	return nil
 }
+
+// handleSettlement triggers the side effects of a settled invoice.
+func (s *SettlementHandler) handleSettlement(inv *pendingInvoice,
+	invoice *lnrpc.Invoice) {
+
+	settledAt := time.Now()
+	if invoice.SettleDate != 0 {
+		settledAt = time.Unix(invoice.SettleDate, 0)
+	}
+	if s.store != nil && inv.address != "" {
+		err := s.store.Settle(
+			hex.EncodeToString(inv.rHash), invoice.AmtPaidMsat,
+			settledAt,
+		)
+		if err != nil {
+			log.Errorf("Unable to record settlement of invoice "+
+				"%x: %v", inv.rHash, err)
+		}
+	}
+
+	settlement := &Settlement{
+		PaymentHash: hex.EncodeToString(inv.rHash),
+		Address:     inv.address,
+		AmountMsat:  invoice.AmtPaidMsat,
+		Comment:     inv.comment,
+		PayerData:   inv.payerData,
+		Preimage:    hex.EncodeToString(invoice.RPreimage),
+		SettledAt:   settledAt,
+	}
+	if inv.zapReceipt != nil {
+		settlement.ZapSender = inv.zapReceipt.sender
+		settlement.ZapEventID = inv.zapReceipt.eventID
+	}
+	s.listenersMu.RLock()
+	for _, l := range s.listeners {
+		l(settlement)
+	}
+	s.listenersMu.RUnlock()
+
+	notifier.BroadcastNotification(
+		context.Background(), settlement.payment(),
+	)
+
+	zapReceipt := inv.zapReceipt
+	if zapReceipt == nil || s.nsec == "" {
+		return
+	}
+
+	zapReceipt.event.CreatedAt = nostr.Timestamp(invoice.SettleDate)
+	zapReceipt.event.Tags = append(
+		zapReceipt.event.Tags,
+		nostr.Tag{"preimage", hex.EncodeToString(invoice.RPreimage)},
+	)
+	err := zapReceipt.event.Sign(s.nsec)
+	if err != nil {
+		log.Warnf("Error signing zap receipt: %s", err)
+		return
+	}
+
+	log.Infof("Publishing zap receipt: %+v", zapReceipt.event)
+	go publishZapReceipt(zapReceipt)
+}
@@ -0,0 +1,196 @@
+package invoice
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// StatusPending is an invoice that can still be paid.
+	StatusPending = "pending"
+
+	// StatusSettled is a paid invoice.
+	StatusSettled = "settled"
+
+	// StatusExpired is an invoice that expired unpaid.
+	StatusExpired = "expired"
+
+	// maxStatusWait is the longest a status request may wait for the
+	// invoice to be settled.
+	maxStatusWait = 60 * time.Second
+)
+
+// InvoiceStatus is the JSON response of the invoice status endpoint.
+type InvoiceStatus struct {
+	PaymentHash string     `json:"paymentHash"`
+	Status      string     `json:"status"`
+	AmountMsat  int64      `json:"amountMsat"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	SettledAt   *time.Time `json:"settledAt,omitempty"`
+}
+
+// settlementWaiters wakes up status requests waiting for an invoice to be
+// settled.
+type settlementWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+func newSettlementWaiters() *settlementWaiters {
+	return &settlementWaiters{waiters: make(map[string][]chan struct{})}
+}
+
+// wait returns a channel that is closed once the invoice is settled and a
+// function to stop waiting.
+func (s *settlementWaiters) wait(paymentHash string) (<-chan struct{},
+	func()) {
+
+	ch := make(chan struct{})
+
+	s.mu.Lock()
+	s.waiters[paymentHash] = append(s.waiters[paymentHash], ch)
+	s.mu.Unlock()
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		waiters := s.waiters[paymentHash]
+		for i, w := range waiters {
+			if w == ch {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(s.waiters, paymentHash)
+		} else {
+			s.waiters[paymentHash] = waiters
+		}
+	}
+
+	return ch, cancel
+}
+
+// settled wakes up everybody waiting for the settled invoice.
+func (s *settlementWaiters) settled(settlement *Settlement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ch := range s.waiters[settlement.PaymentHash] {
+		close(ch)
+	}
+	delete(s.waiters, settlement.PaymentHash)
+}
+
+// invoiceStatus returns the status of a recorded invoice.
+func invoiceStatus(rec *Record, now time.Time) *InvoiceStatus {
+	status := &InvoiceStatus{
+		PaymentHash: rec.PaymentHash,
+		Status:      StatusPending,
+		AmountMsat:  rec.AmountMsat,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+
+	switch {
+	case rec.Settled:
+		status.Status = StatusSettled
+		status.AmountMsat = rec.AmountPaidMsat
+		settledAt := rec.SettledAt
+		status.SettledAt = &settledAt
+
+	case now.After(rec.ExpiresAt):
+		status.Status = StatusExpired
+	}
+
+	return status
+}
+
+// HandleInvoiceStatus reports whether an invoice created by the invoice
+// callback is pending, settled or expired. With the wait query parameter set
+// to a number of seconds, a request for a pending invoice is held open until
+// the invoice is settled, expires or the time is up.
+func (m *Manager) HandleInvoiceStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+
+	paymentHash := r.PathValue("hash")
+	if b, err := hex.DecodeString(paymentHash); err != nil || len(b) != 32 {
+		badRequestError(w, "Invalid payment hash.")
+		return
+	}
+
+	var wait time.Duration
+	if param := r.URL.Query().Get("wait"); param != "" {
+		sec, err := strconv.Atoi(param)
+		if err != nil || sec < 0 {
+			badRequestError(w, "Wait needs to be a number of "+
+				"seconds.")
+			return
+		}
+		wait = min(time.Duration(sec)*time.Second, maxStatusWait)
+	}
+
+	if m.Cfg.Store == nil {
+		writeError(w, http.StatusNotFound, "Unknown invoice.")
+		return
+	}
+
+	// We start waiting before looking at the invoice, so a settlement
+	// between both can't be missed.
+	settled, cancel := m.waiters.wait(paymentHash)
+	defer cancel()
+
+	status, ok := m.lookupStatus(w, paymentHash)
+	if !ok {
+		return
+	}
+
+	if status.Status == StatusPending && wait > 0 {
+		timeout := min(wait, time.Until(status.ExpiresAt))
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-settled:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+
+		status, ok = m.lookupStatus(w, paymentHash)
+		if !ok {
+			return
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// lookupStatus returns the current status of the invoice or writes an error
+// response.
+func (m *Manager) lookupStatus(w http.ResponseWriter,
+	paymentHash string) (*InvoiceStatus, bool) {
+
+	rec, err := m.Cfg.Store.Get(paymentHash)
+	switch {
+	case errors.Is(err, ErrUnknownInvoice):
+		writeError(w, http.StatusNotFound, "Unknown invoice.")
+		return nil, false
+
+	case err != nil:
+		log.Errorf("Unable to look up invoice %s: %v", paymentHash,
+			err)
+		writeError(w, http.StatusInternalServerError,
+			"Unable to look up invoice.")
+		return nil, false
+	}
+
+	return invoiceStatus(rec, time.Now()), true
+}
@@ -0,0 +1,193 @@
+package invoice
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+func newStatusServer(t *testing.T) (*Manager, *httptest.Server) {
+	t.Helper()
+
+	fl := &mockLightningClient{}
+	store := newTestStore(t)
+	mgr, err := NewInvoiceManager(&ManagerConfig{
+		LndClient:         fl,
+		SettlementHandler: NewSettlementHandler(fl, "", store),
+		Store:             store,
+	})
+	if err != nil {
+		t.Fatalf("NewInvoiceManager: %v", err)
+	}
+
+	// The status route has to coexist with the invoice callbacks.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invoice/", http.NotFound)
+	mux.HandleFunc("/invoice/{user}", http.NotFound)
+	mux.HandleFunc("GET /invoice/status/{hash}", mgr.HandleInvoiceStatus)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return mgr, ts
+}
+
+func getInvoiceStatus(t *testing.T, url string) (int, *InvoiceStatus) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	status := &InvoiceStatus{}
+	_ = json.NewDecoder(resp.Body).Decode(status)
+
+	return resp.StatusCode, status
+}
+
+func TestInvoiceStatus(t *testing.T) {
+	mgr, ts := newStatusServer(t)
+	now := time.Now()
+
+	pending := strings.Repeat("aa", 32)
+	expired := strings.Repeat("bb", 32)
+	for hash, expiresAt := range map[string]time.Time{
+		pending: now.Add(time.Hour),
+		expired: now.Add(-time.Second),
+	} {
+		err := mgr.Cfg.Store.Add(&Record{
+			PaymentHash: hash,
+			Address:     "alice@example.com",
+			AmountMsat:  1000,
+			CreatedAt:   now,
+			ExpiresAt:   expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	code, status := getInvoiceStatus(t, ts.URL+"/invoice/status/"+pending)
+	if code != http.StatusOK || status.Status != StatusPending {
+		t.Fatalf("expected pending invoice, got %d: %+v", code, status)
+	}
+
+	code, status = getInvoiceStatus(t, ts.URL+"/invoice/status/"+expired)
+	if code != http.StatusOK || status.Status != StatusExpired {
+		t.Fatalf("expected expired invoice, got %d: %+v", code, status)
+	}
+
+	code, _ = getInvoiceStatus(
+		t, ts.URL+"/invoice/status/"+strings.Repeat("cc", 32),
+	)
+	if code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown invoice, got %d", code)
+	}
+
+	code, _ = getInvoiceStatus(t, ts.URL+"/invoice/status/nothex")
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid hash, got %d", code)
+	}
+
+	// A long-polling request returns as soon as the invoice is settled.
+	rHash, _ := hex.DecodeString(pending)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// Give the request time to start waiting.
+		time.Sleep(100 * time.Millisecond)
+		mgr.Cfg.SettlementHandler.handleSettlement(&pendingInvoice{
+			rHash:   rHash,
+			address: "alice@example.com",
+		}, &lnrpc.Invoice{AmtPaidMsat: 1000})
+	}()
+
+	start := time.Now()
+	code, status = getInvoiceStatus(
+		t, ts.URL+"/invoice/status/"+pending+"?wait=30",
+	)
+	if code != http.StatusOK || status.Status != StatusSettled ||
+		status.SettledAt == nil {
+
+		t.Fatalf("expected settled invoice, got %d: %+v", code, status)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("long-poll didn't return on settlement")
+	}
+	<-done
+}
+
+func TestInvoiceStatus_Pruned(t *testing.T) {
+	mgr, ts := newStatusServer(t)
+	now := time.Now()
+
+	// Adding the second invoice prunes the first one.
+	pruned := strings.Repeat("aa", 32)
+	for _, rec := range []*Record{{
+		PaymentHash: pruned,
+		AmountMsat:  1000,
+		ExpiresAt:   now.Add(-settlementGracePeriod - time.Hour),
+	}, {
+		PaymentHash: strings.Repeat("bb", 32),
+		ExpiresAt:   now.Add(time.Hour),
+	}} {
+		if err := mgr.Cfg.Store.Add(rec); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	code, status := getInvoiceStatus(t, ts.URL+"/invoice/status/"+pruned)
+	if code != http.StatusOK || status.Status != StatusExpired ||
+		status.AmountMsat != 1000 {
+
+		t.Fatalf("expected expired invoice, got %d: %+v", code, status)
+	}
+}
+
+func TestInvoiceStatus_WaitTimeout(t *testing.T) {
+	mgr, ts := newStatusServer(t)
+
+	hash := strings.Repeat("aa", 32)
+	err := mgr.Cfg.Store.Add(&Record{
+		PaymentHash: hash,
+		Address:     "alice@example.com",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	start := time.Now()
+	code, status := getInvoiceStatus(
+		t, ts.URL+"/invoice/status/"+hash+"?wait=1",
+	)
+	if code != http.StatusOK || status.Status != StatusPending {
+		t.Fatalf("expected pending invoice, got %d: %+v", code, status)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatalf("expected request to wait")
+	}
+
+	// The waiter is removed once the handler returned.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mgr.waiters.mu.Lock()
+		n := len(mgr.waiters.waiters)
+		mgr.waiters.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected waiter to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,347 @@
+package invoice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrUnknownInvoice is returned if no invoice with the given payment
+	// hash was created by us, or if it expired unpaid longer than
+	// expiredRetention ago.
+	ErrUnknownInvoice = errors.New("unknown invoice")
+
+	// pendingBucket holds the unpaid invoices keyed by payment hash.
+	pendingBucket = []byte("pending-invoices")
+
+	// expiryBucket indexes the unpaid invoices by expiry. Its keys are
+	// the expiry followed by the payment hash, its values empty.
+	expiryBucket = []byte("pending-invoices-expiry")
+
+	// expiredBucket holds a tombstone of every pruned unpaid invoice,
+	// keyed by payment hash. A tombstone is a record with just the
+	// amount and expiry, so the invoice is still reported as expired.
+	expiredBucket = []byte("expired-invoices")
+
+	// expiredIndexBucket indexes the tombstones like expiryBucket.
+	expiredIndexBucket = []byte("expired-invoices-expiry")
+
+	// settledBucket holds one sub-bucket per lightning address with the
+	// paid invoices, keyed by settle time followed by payment hash.
+	settledBucket = []byte("settled-invoices")
+
+	// settledIndexBucket maps the payment hashes of paid invoices to
+	// their settle time followed by their address.
+	settledIndexBucket = []byte("settled-invoices-index")
+)
+
+// expiredRetention is how long the tombstones of unpaid invoices are kept
+// after they expired.
+const expiredRetention = 30 * 24 * time.Hour
+
+// Record is an invoice created through the invoice callback.
+type Record struct {
+	PaymentHash string `json:"paymentHash"`
+	Address     string `json:"address"`
+	AmountMsat  int64  `json:"amountMsat"`
+	Comment     string `json:"comment,omitempty"`
+
+	// ZapSender is the hex encoded nostr pubkey of the zap request's
+	// author if the invoice was requested for a zap.
+	ZapSender string `json:"zapSender,omitempty"`
+
+	// ZapEventID is the id of the zapped nostr event, if any.
+	ZapEventID string `json:"zapEventId,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// Settled is set once the invoice was paid.
+	Settled        bool      `json:"settled"`
+	AmountPaidMsat int64     `json:"amountPaidMsat,omitempty"`
+	SettledAt      time.Time `json:"settledAt,omitempty"`
+}
+
+// Store persists the invoices we created and whether they were paid. Unpaid
+// invoices are reduced to a tombstone once they expired and are forgotten
+// after expiredRetention, so the number of stored unpaid invoices is bounded
+// by the invoice rate limits.
+type Store struct {
+	db *bbolt.DB
+}
+
+// NewStore creates the buckets used by the invoice store.
+func NewStore(db *bbolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{
+			pendingBucket, expiryBucket, expiredBucket,
+			expiredIndexBucket, settledBucket, settledIndexBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create invoice buckets: %w",
+			err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// timeKey returns the key of a record ordered by t. Times before 1970,
+// including the zero time, sort first.
+func timeKey(t time.Time, paymentHash string) []byte {
+	var nanos uint64
+	if t.After(time.Unix(0, 0)) {
+		nanos = uint64(t.UnixNano())
+	}
+	key := binary.BigEndian.AppendUint64(
+		make([]byte, 0, 8+len(paymentHash)), nanos,
+	)
+
+	return append(key, paymentHash...)
+}
+
+// Add stores a newly created invoice. Unpaid invoices that expired before the
+// settlement grace period are pruned on the way.
+func (s *Store) Add(rec *Record) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := prunePending(tx, time.Now()); err != nil {
+			return err
+		}
+
+		err := tx.Bucket(pendingBucket).Put([]byte(rec.PaymentHash), raw)
+		if err != nil || rec.ExpiresAt.IsZero() {
+			return err
+		}
+
+		return tx.Bucket(expiryBucket).Put(
+			timeKey(rec.ExpiresAt, rec.PaymentHash), nil,
+		)
+	})
+}
+
+// prunePending replaces the unpaid invoices that expired before the
+// settlement grace period by tombstones and deletes the tombstones older than
+// expiredRetention.
+func prunePending(tx *bbolt.Tx, now time.Time) error {
+	expiry := tx.Bucket(expiryBucket)
+	pending := tx.Bucket(pendingBucket)
+	expired := tx.Bucket(expiredBucket)
+	expiredIndex := tx.Bucket(expiredIndexBucket)
+
+	cutoff := now.Add(-settlementGracePeriod)
+	for _, k := range keysBefore(expiry, cutoff) {
+		hash := k[8:]
+		if err := expiry.Delete(k); err != nil {
+			return err
+		}
+
+		raw := pending.Get(hash)
+		if raw == nil {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(raw, rec); err != nil {
+			return err
+		}
+		tombstone, err := json.Marshal(&Record{
+			PaymentHash: rec.PaymentHash,
+			AmountMsat:  rec.AmountMsat,
+			ExpiresAt:   rec.ExpiresAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := pending.Delete(hash); err != nil {
+			return err
+		}
+		if err := expired.Put(hash, tombstone); err != nil {
+			return err
+		}
+		if err := expiredIndex.Put(k, nil); err != nil {
+			return err
+		}
+	}
+
+	cutoff = now.Add(-expiredRetention)
+	for _, k := range keysBefore(expiredIndex, cutoff) {
+		if err := expiredIndex.Delete(k); err != nil {
+			return err
+		}
+		if err := expired.Delete(k[8:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keysBefore returns copies of the keys of a bucket keyed by timeKey that are
+// before the cutoff. Keys can't be deleted while iterating, the cursor would
+// skip the following key.
+func keysBefore(b *bbolt.Bucket, cutoff time.Time) [][]byte {
+	limit := timeKey(cutoff, "")
+
+	var keys [][]byte
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if bytes.Compare(k, limit) >= 0 {
+			break
+		}
+		keys = append(keys, bytes.Clone(k))
+	}
+
+	return keys
+}
+
+// Settle marks an invoice as paid and moves it to the settled invoices of its
+// address. Settling an invoice again is a no-op.
+func (s *Store) Settle(paymentHash string, amountPaidMsat int64,
+	settledAt time.Time) error {
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(settledIndexBucket)
+		if index.Get([]byte(paymentHash)) != nil {
+			return nil
+		}
+
+		pending := tx.Bucket(pendingBucket)
+		raw := pending.Get([]byte(paymentHash))
+		if raw == nil {
+			return ErrUnknownInvoice
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(raw, rec); err != nil {
+			return err
+		}
+
+		rec.Settled = true
+		rec.AmountPaidMsat = amountPaidMsat
+		rec.SettledAt = settledAt
+
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+
+		if err := pending.Delete([]byte(paymentHash)); err != nil {
+			return err
+		}
+		if !rec.ExpiresAt.IsZero() {
+			err := tx.Bucket(expiryBucket).Delete(
+				timeKey(rec.ExpiresAt, paymentHash),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		b, err := tx.Bucket(settledBucket).CreateBucketIfNotExists(
+			[]byte(rec.Address),
+		)
+		if err != nil {
+			return err
+		}
+		key := timeKey(settledAt, paymentHash)
+		if err := b.Put(key, raw); err != nil {
+			return err
+		}
+
+		entry := append(timeKey(settledAt, ""), rec.Address...)
+
+		return index.Put([]byte(paymentHash), entry)
+	})
+}
+
+// Get returns the invoice with the given hex encoded payment hash.
+func (s *Store) Get(paymentHash string) (*Record, error) {
+	rec := &Record{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(pendingBucket).Get([]byte(paymentHash))
+		if raw == nil {
+			raw = getSettled(tx, paymentHash)
+		}
+		if raw == nil {
+			raw = tx.Bucket(expiredBucket).Get([]byte(paymentHash))
+		}
+		if raw == nil {
+			return ErrUnknownInvoice
+		}
+
+		return json.Unmarshal(raw, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// getSettled returns the raw record of a paid invoice or nil.
+func getSettled(tx *bbolt.Tx, paymentHash string) []byte {
+	entry := tx.Bucket(settledIndexBucket).Get([]byte(paymentHash))
+	if len(entry) < 8 {
+		return nil
+	}
+
+	b := tx.Bucket(settledBucket).Bucket(entry[8:])
+	if b == nil {
+		return nil
+	}
+
+	key := append(bytes.Clone(entry[:8]), paymentHash...)
+
+	return b.Get(key)
+}
+
+// Settled returns the paid invoices of the given address that were settled at
+// or after since, most recent first. A limit greater than zero returns at most
+// that many invoices.
+func (s *Store) Settled(address string, since time.Time,
+	limit int) ([]*Record, error) {
+
+	var records []*Record
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(settledBucket).Bucket([]byte(address))
+		if b == nil {
+			return nil
+		}
+
+		first := timeKey(since, "")
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if bytes.Compare(k, first) < 0 ||
+				limit > 0 && len(records) >= limit {
+
+				break
+			}
+
+			rec := &Record{}
+			if err := json.Unmarshal(v, rec); err != nil {
+				return err
+			}
+			records = append(records, rec)
+		}
+
+		return nil
+	})
+
+	return records, err
+}
@@ -0,0 +1,229 @@
+package invoice
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/notifier"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"go.etcd.io/bbolt"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+	notifier.SetupNotifiers(nil, nil, btclog.Disabled)
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := bbolt.Open(
+		filepath.Join(t.TempDir(), "test.db"), 0600, nil,
+	)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	s, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	return s
+}
+
+func TestStore_Settle(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	for i, hash := range []string{"h1", "h2", "h3"} {
+		err := s.Add(&Record{
+			PaymentHash: hash,
+			Address:     "alice@example.com",
+			AmountMsat:  1000,
+			CreatedAt:   now.Add(time.Duration(i) * time.Second),
+			ExpiresAt:   now.Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	if err := s.Settle("h1", 1000, now); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+	if err := s.Settle("h3", 2000, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+	err := s.Settle("unknown", 1000, now)
+	if !errors.Is(err, ErrUnknownInvoice) {
+		t.Fatalf("expected ErrUnknownInvoice, got %v", err)
+	}
+
+	// Settling again doesn't change the record.
+	if err := s.Settle("h1", 5000, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+
+	settled, err := s.Settled("alice@example.com", time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("Settled: %v", err)
+	}
+	if len(settled) != 2 || settled[0].PaymentHash != "h3" ||
+		settled[0].AmountPaidMsat != 2000 ||
+		settled[1].PaymentHash != "h1" ||
+		settled[1].AmountPaidMsat != 1000 {
+
+		t.Fatalf("unexpected settled invoices: %+v", settled)
+	}
+
+	settled, err = s.Settled("alice@example.com", now.Add(time.Second), 0)
+	if err != nil || len(settled) != 1 || settled[0].PaymentHash != "h3" {
+		t.Fatalf("unexpected invoices since now: %+v, %v", settled, err)
+	}
+	settled, err = s.Settled("alice@example.com", time.Time{}, 1)
+	if err != nil || len(settled) != 1 || settled[0].PaymentHash != "h3" {
+		t.Fatalf("unexpected last invoice: %+v, %v", settled, err)
+	}
+
+	for hash, paid := range map[string]bool{"h1": true, "h2": false} {
+		rec, err := s.Get(hash)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if rec.Settled != paid {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+	}
+
+	settled, err = s.Settled("bob@example.com", time.Time{}, 0)
+	if err != nil || len(settled) != 0 {
+		t.Fatalf("expected no invoices for bob, got %v: %v", settled,
+			err)
+	}
+}
+
+func TestStore_PrunesExpired(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	add := func(hash string, expiresAt time.Time) {
+		t.Helper()
+
+		err := s.Add(&Record{
+			PaymentHash: hash,
+			Address:     "alice@example.com",
+			ExpiresAt:   expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	// Invoices may still be paid within the grace period after they
+	// expired. Older ones are reduced to a tombstone, which is deleted
+	// after expiredRetention.
+	add("ancient", now.Add(-expiredRetention-time.Minute))
+	add("expired", now.Add(-settlementGracePeriod-time.Minute))
+	add("grace", now.Add(-settlementGracePeriod/2))
+	add("paid", now.Add(-settlementGracePeriod-time.Minute))
+	if err := s.Settle("paid", 1000, now); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+
+	add("new", now.Add(time.Hour))
+
+	if _, err := s.Get("ancient"); !errors.Is(err, ErrUnknownInvoice) {
+		t.Fatalf("expected ancient invoice to be pruned, got %v", err)
+	}
+	rec, err := s.Get("expired")
+	if err != nil || rec.Address != "" || rec.ExpiresAt.IsZero() {
+		t.Fatalf("expected tombstone of expired invoice, got %+v: %v",
+			rec, err)
+	}
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(pendingBucket).Get([]byte("expired")) != nil {
+			return errors.New("expired invoice still pending")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"grace", "paid", "new"} {
+		if _, err := s.Get(hash); err != nil {
+			t.Fatalf("Get %s: %v", hash, err)
+		}
+	}
+}
+
+func TestInvoiceCreation_Recorded(t *testing.T) {
+	store := newTestStore(t)
+	userStore, err := users.NewConfigStore([]string{"alice@example.com"})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	fl := &mockLightningClient{}
+	sh := NewSettlementHandler(fl, "", store)
+	mgr, err := NewInvoiceManager(&ManagerConfig{
+		LndClient:         fl,
+		SettlementHandler: sh,
+		Users:             userStore,
+		Store:             store,
+	})
+	if err != nil {
+		t.Fatalf("NewInvoiceManager: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invoice/{user}", mgr.HandleInvoiceCreation(Config{
+		MinSendableMsat:  1000,
+		MaxSendableMsat:  100000,
+		MaxCommentLength: 100,
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/invoice/alice?amount=2000&comment=hi")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	rec, err := store.Get("01020304")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if rec.Address != "alice@example.com" || rec.AmountMsat != 2000 ||
+		rec.Comment != "hi" || rec.Settled {
+
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	sh.handleSettlement(&pendingInvoice{
+		rHash:   []byte{1, 2, 3, 4},
+		address: rec.Address,
+	}, &lnrpc.Invoice{AmtPaidMsat: 2000, SettleDate: 1700000000})
+
+	rec, err = store.Get("01020304")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !rec.Settled || rec.AmountPaidMsat != 2000 ||
+		rec.SettledAt.Unix() != 1700000000 {
+
+		t.Fatalf("unexpected settled record: %+v", rec)
+	}
+}
@@ -0,0 +1,50 @@
+package lnurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+// Encode returns the bech32 encoded LNURL of the given URL as specified by
+// LUD-01. The result is upper case, so it can be encoded in the more compact
+// alphanumeric mode of QR codes.
+func Encode(url string) (string, error) {
+	converted, err := bech32.ConvertBits([]byte(url), 8, 5, true)
+	if err != nil {
+		return "", fmt.Errorf("unable to convert url: %w", err)
+	}
+
+	encoded, err := bech32.Encode("lnurl", converted)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode url: %w", err)
+	}
+
+	return strings.ToUpper(encoded), nil
+}
+
+// Response is the generic LUD-06 status response.
+type Response struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// WriteError writes a LUD-06 error response with the given HTTP status code.
+func WriteError(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(Response{
+		Status: "ERROR",
+		Reason: reason,
+	})
+}
+
+// WriteOK writes a LUD-06 success response.
+func WriteOK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(Response{Status: "OK"})
+}
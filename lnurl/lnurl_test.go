@@ -0,0 +1,20 @@
+package lnurl
+
+import "testing"
+
+// TestEncode checks the encoding against the example of LUD-01.
+func TestEncode(t *testing.T) {
+	url := "https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d" +
+		"96dcd340693afabe04be7b0ccd178df"
+	want := "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C" +
+		"35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NX" +
+		"ZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNS"
+
+	got, err := Encode(url)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if got != want {
+		t.Fatalf("unexpected LNURL.\nwant %s\ngot  %s", want, got)
+	}
+}
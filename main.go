@@ -1,28 +1,41 @@
 package main
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"html/template"
 	baselog "log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/MadAppGang/httplog"
 	"github.com/btcsuite/btclog"
-	"github.com/btcsuite/btcutil/bech32"
+	"github.com/hieblmi/go-host-lnaddr/auth"
+	"github.com/hieblmi/go-host-lnaddr/dashboard"
+	"github.com/hieblmi/go-host-lnaddr/events"
+	"github.com/hieblmi/go-host-lnaddr/lnurl"
 	"github.com/hieblmi/go-host-lnaddr/notifier"
+	"github.com/hieblmi/go-host-lnaddr/tgbot"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"github.com/hieblmi/go-host-lnaddr/widget"
+	"github.com/hieblmi/go-host-lnaddr/withdraw"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
-	"github.com/skip2/go-qrcode"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.etcd.io/bbolt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"gopkg.in/macaroon.v2"
@@ -31,28 +44,39 @@ import (
 )
 
 type ServerConfig struct {
-	RPCHost             string            `json:"RPCHost" toml:"RPCHost"`
-	InvoiceMacaroonPath string            `json:"InvoiceMacaroonPath" toml:"InvoiceMacaroonPath"`
-	TLSCertPath         string            `json:"TLSCertPath" toml:"TLSCertPath"`
-	WorkingDir          string            `json:"WorkingDir" toml:"WorkingDir"`
-	ExternalURL         string            `json:"ExternalURL" toml:"ExternalURL"`
-	ListAllURLs         bool              `json:"ListAllURLs" toml:"ListAllURLs"`
-	LightningAddresses  []string          `json:"LightningAddresses" toml:"LightningAddresses"`
-	MinSendableMsat     int               `json:"MinSendableMsat" toml:"MinSendableMsat"`
-	MaxSendableMsat     int               `json:"MaxSendableMsat" toml:"MaxSendableMsat"`
-	MaxCommentLength    int               `json:"MaxCommentLength" toml:"MaxCommentLength"`
-	Tag                 string            `json:"Tag" toml:"Tag"`
-	Metadata            [][]string        `json:"Metadata" toml:"Metadata"`
-	Thumbnail           string            `json:"Thumbnail" toml:"Thumbnail"`
-	SuccessMessage      string            `json:"SuccessMessage" toml:"SuccessMessage"`
-	InvoiceCallback     string            `json:"InvoiceCallback" toml:"InvoiceCallback"`
-	AddressServerPort   int               `json:"AddressServerPort" toml:"AddressServerPort"`
-	Nostr               *NostrConfig      `json:"Nostr" toml:"Nostr"`
-	Notifiers           []notifier.Config `json:"Notifiers" toml:"Notifiers"`
+	RPCHost             string              `json:"RPCHost" toml:"RPCHost"`
+	InvoiceMacaroonPath string              `json:"InvoiceMacaroonPath" toml:"InvoiceMacaroonPath"`
+	TLSCertPath         string              `json:"TLSCertPath" toml:"TLSCertPath"`
+	WorkingDir          string              `json:"WorkingDir" toml:"WorkingDir"`
+	ExternalURL         string              `json:"ExternalURL" toml:"ExternalURL"`
+	ListAllURLs         bool                `json:"ListAllURLs" toml:"ListAllURLs"`
+	LightningAddresses  []string            `json:"LightningAddresses" toml:"LightningAddresses"`
+	MinSendableMsat     int                 `json:"MinSendableMsat" toml:"MinSendableMsat"`
+	MaxSendableMsat     int                 `json:"MaxSendableMsat" toml:"MaxSendableMsat"`
+	MaxCommentLength    int                 `json:"MaxCommentLength" toml:"MaxCommentLength"`
+	Tag                 string              `json:"Tag" toml:"Tag"`
+	Metadata            [][]string          `json:"Metadata" toml:"Metadata"`
+	Thumbnail           string              `json:"Thumbnail" toml:"Thumbnail"`
+	SuccessMessage      string              `json:"SuccessMessage" toml:"SuccessMessage"`
+	InvoiceCallback     string              `json:"InvoiceCallback" toml:"InvoiceCallback"`
+	AddressServerPort   int                 `json:"AddressServerPort" toml:"AddressServerPort"`
+	InvoiceExpirySec    int                 `json:"InvoiceExpirySec" toml:"InvoiceExpirySec"`
+	RateLimit           invoice.LimitConfig `json:"RateLimit" toml:"RateLimit"`
+	MetricsAddress      string              `json:"MetricsAddress" toml:"MetricsAddress"`
+	Tor                 *TorConfig          `json:"Tor" toml:"Tor"`
+	AdminToken          string              `json:"AdminToken" toml:"AdminToken"`
+	Withdraw            *withdraw.Config    `json:"Withdraw" toml:"Withdraw"`
+	Auth                *auth.Config        `json:"Auth" toml:"Auth"`
+	Dashboard           *dashboard.Config   `json:"Dashboard" toml:"Dashboard"`
+	Events              *events.Config      `json:"Events" toml:"Events"`
+	Nostr               *NostrConfig        `json:"Nostr" toml:"Nostr"`
+	Notifiers           []notifier.Config   `json:"Notifiers" toml:"Notifiers"`
 	// Notificators is the old name for Notifiers, left here for
 	// backwards compatibility.
-	Notificators []notifier.Config `json:"Notificators" toml:"Notificators"`
-	Zaps         *ZapsConfig       `json:"Zaps" toml:"Zaps"`
+	Notificators []notifier.Config     `json:"Notificators" toml:"Notificators"`
+	Outbox       notifier.OutboxConfig `json:"Outbox" toml:"Outbox"`
+	TelegramBot  *tgbot.Config         `json:"TelegramBot" toml:"TelegramBot"`
+	Zaps         *ZapsConfig           `json:"Zaps" toml:"Zaps"`
 }
 
 type LNUrlPay struct {
@@ -123,6 +147,12 @@ func main() {
 		baselog.Fatalf("cannot get logger %v", err)
 	}
 	invoice.SetLogger(log)
+	withdraw.SetLogger(log)
+	auth.SetLogger(log)
+	dashboard.SetLogger(log)
+	events.SetLogger(log)
+	widget.SetLogger(log)
+	tgbot.SetLogger(log)
 
 	if err := prepareZaps(config.Zaps); err != nil {
 		baselog.Fatalf("zaps configuration error: %v", err)
@@ -133,6 +163,7 @@ func main() {
 
 	clientConn, err := getClientConn(
 		config.RPCHost, config.TLSCertPath, config.InvoiceMacaroonPath,
+		config.Tor,
 	)
 	if err != nil {
 		log.Errorf("unable to get a lnd client connection: %v", err)
@@ -140,18 +171,69 @@ func main() {
 	}
 
 	lndClient := lnrpc.NewLightningClient(clientConn)
+
+	userStore, err := users.NewConfigStore(config.LightningAddresses)
+	if err != nil {
+		log.Errorf("invalid lightning address configuration: %v", err)
+		return
+	}
+
+	db, err := openDB(workingDir)
+	if err != nil {
+		log.Errorf("unable to open database: %v", err)
+		return
+	}
+	defer db.Close()
+
+	invoiceStore, err := invoice.NewStore(db)
+	if err != nil {
+		log.Errorf("unable to open invoice store: %v", err)
+		return
+	}
 	settlementHandler := invoice.NewSettlementHandler(
-		lndClient, config.Zaps.Nsec,
+		lndClient, config.Zaps.Nsec, invoiceStore,
 	)
 
-	invoiceManager := invoice.NewInvoiceManager(
+	invoiceManager, err := invoice.NewInvoiceManager(
 		&invoice.ManagerConfig{
 			LndClient:         lndClient,
 			SettlementHandler: settlementHandler,
+			Users:             userStore,
+			Limits:            config.RateLimit,
+			Store:             invoiceStore,
 		},
 	)
+	if err != nil {
+		log.Errorf("invalid rate limit configuration: %v", err)
+		return
+	}
+
+	setupMetrics(config.MetricsAddress)
+
+	if err := setupWithdrawHandlers(config, db); err != nil {
+		log.Errorf("unable to set up withdraw vouchers: %v", err)
+		return
+	}
+
+	authService, err := setupAuthHandlers(config, userStore)
+	if err != nil {
+		log.Errorf("unable to set up LNURL-auth: %v", err)
+		return
+	}
+
+	err = setupEventHandlers(config, userStore, settlementHandler)
+	if err != nil {
+		log.Errorf("unable to set up event streams: %v", err)
+		return
+	}
+
+	err = setupDashboard(config, authService, userStore, invoiceStore)
+	if err != nil {
+		log.Errorf("unable to set up dashboard: %v", err)
+		return
+	}
 
-	setupHandlerPerAddress(config)
+	setupLNUrlpHandler(config, userStore)
 	setupNostrHandlers(config.Nostr)
 	if config.Notificators != nil && config.Notifiers == nil {
 		config.Notifiers = config.Notificators
@@ -159,8 +241,25 @@ func main() {
 		    "Notifiers, please update your config as the old " +
 		    "name will be deprecated soon")
 	}
-	notifier.SetupNotifiers(config.Notifiers, log)
-	setupIndexHandler(config)
+	setNostrNotifierDefaults(config)
+	err = notifier.SetupNotifiers(config.Notifiers, userStore, log)
+	if err != nil {
+		log.Errorf("unable to set up notifiers: %v", err)
+		return
+	}
+	if err := setupOutbox(config, db); err != nil {
+		log.Errorf("unable to set up notification outbox: %v", err)
+		return
+	}
+	err = setupTelegramBot(config, userStore, invoiceStore, invoiceManager)
+	if err != nil {
+		log.Errorf("unable to set up Telegram bot: %v", err)
+		return
+	}
+	setupIndexHandler(config, userStore)
+	setupUserPages(config, userStore)
+	setupQRHandler(config, userStore)
+	setupWidgetHandlers(userStore)
 
 	// Precompute base metadata string once.
 	if err != nil {
@@ -171,10 +270,19 @@ func main() {
 		MaxSendableMsat:  config.MaxSendableMsat,
 		MaxCommentLength: config.MaxCommentLength,
 		SuccessMessage:   config.SuccessMessage,
+		InvoiceExpiry: time.Duration(config.InvoiceExpirySec) *
+			time.Second,
 	}
-	http.HandleFunc("/invoice/", useLogger(
+	invoiceHandler := useLogger(
 		invoiceManager.HandleInvoiceCreation(payCfg),
-	))
+	)
+	http.HandleFunc("/invoice/", invoiceHandler)
+	http.HandleFunc("/invoice/{user}", invoiceHandler)
+
+	// Status requests aren't logged, as checkout pages poll them.
+	http.HandleFunc(
+		"GET /invoice/status/{hash}", invoiceManager.HandleInvoiceStatus,
+	)
 	err = http.ListenAndServe(
 		fmt.Sprintf(":%d", config.AddressServerPort), nil,
 	)
@@ -183,6 +291,283 @@ func main() {
 	}
 }
 
+// setupMetrics serves the Prometheus metrics on a separate listener, so they
+// aren't exposed on the public address server.
+func setupMetrics(address string) {
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Infof("Serving metrics on %s", address)
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Errorf("unable to start metrics server: %v", err)
+		}
+	}()
+}
+
+// requireAdmin only passes requests on to the handler that carry the
+// configured admin token as bearer token. If no admin token is configured,
+// all requests are rejected.
+func requireAdmin(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(
+			r.Header.Get("Authorization"), "Bearer ",
+		)
+		if token == "" || !ok || subtle.ConstantTimeCompare(
+			[]byte(given), []byte(token),
+		) != 1 {
+
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// setupWithdrawHandlers serves LNURL-withdraw vouchers if configured. Paying
+// out vouchers requires a separate connection to lnd with a macaroon that may
+// send payments.
+func setupWithdrawHandlers(config ServerConfig, db *bbolt.DB) error {
+	if config.Withdraw == nil || config.Withdraw.MacaroonPath == "" {
+		return nil
+	}
+	if config.ExternalURL == "" {
+		return errors.New("ExternalURL is required for vouchers")
+	}
+	if config.AdminToken == "" {
+		log.Warnf("No AdminToken configured, vouchers can't be " +
+			"created through the admin API")
+	}
+
+	conn, err := getClientConn(
+		config.RPCHost, config.TLSCertPath,
+		config.Withdraw.MacaroonPath, config.Tor,
+	)
+	if err != nil {
+		return err
+	}
+
+	store, err := withdraw.NewStore(db)
+	if err != nil {
+		return err
+	}
+
+	mgr := withdraw.NewManager(&withdraw.ManagerConfig{
+		Config:       *config.Withdraw,
+		Store:        store,
+		LndClient:    lnrpc.NewLightningClient(conn),
+		RouterClient: routerrpc.NewRouterClient(conn),
+		ExternalURL:  config.ExternalURL,
+	})
+	if err := mgr.ResumePayments(); err != nil {
+		return err
+	}
+
+	http.HandleFunc(
+		"GET /withdraw/callback", useLogger(mgr.HandleCallback),
+	)
+	http.HandleFunc(
+		"GET /withdraw/{k1}", useLogger(mgr.HandleWithdrawRequest),
+	)
+	admin := requireAdmin(config.AdminToken, mgr.HandleAdmin)
+	http.HandleFunc("/admin/withdraw", useLogger(admin))
+	http.HandleFunc("/admin/withdraw/{k1}", useLogger(admin))
+
+	return nil
+}
+
+// setupOutbox delivers notifications through the persistent outbox and serves
+// its admin API.
+func setupOutbox(config ServerConfig, db *bbolt.DB) error {
+	outbox, err := notifier.SetupOutbox(db, config.Outbox)
+	if err != nil {
+		return err
+	}
+
+	admin := useLogger(requireAdmin(config.AdminToken, outbox.HandleAdmin))
+	http.HandleFunc("/admin/notifications", admin)
+	http.HandleFunc("/admin/notifications/{id}", admin)
+	http.HandleFunc("/admin/notifications/{id}/{action}", admin)
+
+	return nil
+}
+
+// setupTelegramBot starts the interactive Telegram bot if configured. Token
+// and chats default to the ones of the telegram notifiers.
+func setupTelegramBot(config ServerConfig, store users.Store,
+	payments *invoice.Store, invoices tgbot.InvoiceMaker) error {
+
+	if config.TelegramBot == nil {
+		return nil
+	}
+
+	cfg := *config.TelegramBot
+	for _, n := range config.Notifiers {
+		if n.Type != "telegram" {
+			continue
+		}
+		if cfg.Token == "" {
+			cfg.Token = n.Params["Token"]
+		}
+		if n.Params["Token"] != cfg.Token ||
+			len(config.TelegramBot.ChatIDs) > 0 {
+
+			continue
+		}
+
+		chatID, err := strconv.ParseInt(n.Params["ChatId"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ChatId %q: %w",
+				n.Params["ChatId"], err)
+		}
+		if !slices.Contains(cfg.ChatIDs, chatID) {
+			cfg.ChatIDs = append(cfg.ChatIDs, chatID)
+		}
+	}
+
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+	addresses := make([]string, 0, len(list))
+	for _, u := range list {
+		addresses = append(addresses, u.Address)
+	}
+
+	bot, err := tgbot.NewBot(&tgbot.BotConfig{
+		Config:    cfg,
+		Payments:  payments,
+		Invoices:  invoices,
+		Addresses: addresses,
+		Mute: func(until time.Time) {
+			notifier.MuteTelegram(cfg.Token, until)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	bot.Start()
+
+	return nil
+}
+
+// setupAuthHandlers serves LNURL-auth logins for address owners if
+// configured. The returned service is nil if logins are disabled.
+func setupAuthHandlers(config ServerConfig,
+	store users.Store) (*auth.Service, error) {
+
+	if config.Auth == nil {
+		return nil, nil
+	}
+	if config.ExternalURL == "" {
+		return nil, errors.New("ExternalURL is required for " +
+			"LNURL-auth")
+	}
+
+	svc, err := auth.NewService(*config.Auth, config.ExternalURL, store)
+	if err != nil {
+		return nil, err
+	}
+
+	http.HandleFunc("GET /auth/login", useLogger(svc.HandleLogin))
+	http.HandleFunc("GET /auth/lnurl", useLogger(svc.HandleLNURL))
+	http.HandleFunc("GET /auth/poll", useLogger(svc.HandlePoll))
+	http.HandleFunc("POST /auth/logout", useLogger(svc.HandleLogout))
+	http.HandleFunc("GET /auth/me", useLogger(svc.RequireOwner(
+		func(w http.ResponseWriter, r *http.Request) {
+			session := auth.SessionFromContext(r.Context())
+
+			addresses := make([]string, 0, len(session.Addresses))
+			for _, u := range session.Addresses {
+				addresses = append(addresses, u.Address)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"linkingKey": session.LinkingKey,
+				"addresses":  addresses,
+				"expiresAt":  session.ExpiresAt,
+			})
+		},
+	)))
+
+	return svc, nil
+}
+
+// setupEventHandlers streams settled invoices to the subscribers of their
+// address if configured.
+func setupEventHandlers(config ServerConfig, store users.Store,
+	settlementHandler *invoice.SettlementHandler) error {
+
+	if config.Events == nil {
+		return nil
+	}
+
+	svc, err := events.NewService(*config.Events, store)
+	if err != nil {
+		return err
+	}
+	settlementHandler.AddListener(svc.HandleSettlement)
+
+	// The streams aren't logged as the request log would hold them open
+	// and record the read tokens.
+	http.HandleFunc("GET /events/{user}", svc.HandleSSE)
+	http.HandleFunc("GET /events/{user}/ws", svc.HandleWebSocket)
+
+	return nil
+}
+
+// setupDashboard serves the owner dashboard if configured. Owners either log
+// in through LNURL-auth, which must be configured as well, or with basic auth.
+func setupDashboard(config ServerConfig, authService *auth.Service,
+	store users.Store, invoices *invoice.Store) error {
+
+	if config.Dashboard == nil {
+		return nil
+	}
+
+	var (
+		authenticator dashboard.Authenticator
+		err           error
+	)
+	switch config.Dashboard.Auth {
+	case dashboard.AuthLNURL:
+		authenticator, err = dashboard.NewLNURLAuth(authService)
+
+	case dashboard.AuthBasic:
+		authenticator, err = dashboard.NewBasicAuth(
+			config.Dashboard.Owners, store,
+		)
+
+	default:
+		err = fmt.Errorf("unknown dashboard auth %q, expected %q or "+
+			"%q", config.Dashboard.Auth, dashboard.AuthLNURL,
+			dashboard.AuthBasic)
+	}
+	if err != nil {
+		return err
+	}
+
+	h, err := dashboard.NewHandler(&dashboard.HandlerConfig{
+		Config:   *config.Dashboard,
+		Invoices: invoices,
+		Auth:     authenticator,
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Handle("GET /dashboard", h)
+
+	return nil
+}
+
 func useLogger(h http.HandlerFunc) http.HandlerFunc {
 	logger := httplog.LoggerWithConfig(httplog.LoggerConfig{
 		Formatter: httplog.ChainLogFormatter(
@@ -197,37 +582,66 @@ func useLogger(h http.HandlerFunc) http.HandlerFunc {
 	return logger(h).ServeHTTP
 }
 
-func setupHandlerPerAddress(config ServerConfig) {
+// setupLNUrlpHandler registers a single LNURLp endpoint that resolves the
+// requested username through the given user store.
+func setupLNUrlpHandler(config ServerConfig, store users.Store) {
 	metadata, err := metadataToString(config)
 	if err != nil {
 		log.Warnf("unable to build metadata: %v", err)
 	}
-	for _, addr := range config.LightningAddresses {
-		addr := strings.Split(addr, "@")[0]
-		endpoint := fmt.Sprintf("/.well-known/lnurlp/%s", addr)
-		http.HandleFunc(
-			endpoint, useLogger(handleLNUrlp(config, metadata)),
-		)
-	}
+	http.HandleFunc(
+		"/.well-known/lnurlp/{user}",
+		useLogger(handleLNUrlp(config, store, metadata)),
+	)
 }
 
-func handleLNUrlp(config ServerConfig, metadata string) http.HandlerFunc {
+func handleLNUrlp(config ServerConfig, store users.Store,
+	metadata string) http.HandlerFunc {
+
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		user, err := store.Lookup(r.PathValue("user"))
+		switch {
+		case errors.Is(err, users.ErrUnknownUser),
+			errors.Is(err, users.ErrInvalidName):
+
+			lnurl.WriteError(w, http.StatusNotFound, "Unknown user.")
+			return
+
+		case err != nil:
+			log.Errorf("Unable to look up user %s: %v",
+				r.PathValue("user"), err)
+			lnurl.WriteError(w, http.StatusInternalServerError,
+				"Unable to look up user.")
+			return
+		}
+
+		// The username is part of the callback so that the invoice
+		// handler knows which address is being paid.
+		callback, err := url.JoinPath(config.InvoiceCallback, user.Name)
+		if err != nil {
+			log.Errorf("Invalid invoice callback %s: %v",
+				config.InvoiceCallback, err)
+			lnurl.WriteError(w, http.StatusInternalServerError,
+				"Invalid invoice callback.")
+			return
+		}
+
 		resp := LNUrlPay{
 			MinSendable:    config.MinSendableMsat,
 			MaxSendable:    config.MaxSendableMsat,
 			CommentAllowed: config.MaxCommentLength,
 			Tag:            config.Tag,
 			Metadata:       metadata,
-			Callback:       config.InvoiceCallback,
+			Callback:       callback,
 		}
 
 		if isZapsConfigured(config) {
 			resp.AllowsNostr = true
 			resp.NostrPubkey = config.Zaps.Npub
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(resp)
 	}
@@ -250,90 +664,6 @@ func setupNostrHandlers(nostr *NostrConfig) {
 	)
 }
 
-func setupIndexHandler(config ServerConfig) {
-	if !config.ListAllURLs || len(config.LightningAddresses) == 0 ||
-	    config.ExternalURL == "" {
-		return
-	}
-
-	type user struct {
-		User    string
-		Encoded string
-		QRCode  string
-	}
-
-	var users []user
-	for _, addr := range config.LightningAddresses {
-		userName := strings.Split(addr, "@")[0]
-		url := fmt.Sprintf("%s/.well-known/lnurlp/%s",
-			config.ExternalURL, userName)
-
-		converted, err := bech32.ConvertBits([]byte(url), 8, 5, true)
-		if err != nil {
-			log.Errorf("Unable to convert url: %v", err)
-		}
-
-		lnurl, err := bech32.Encode("lnurl", converted)
-		if err != nil {
-			log.Errorf("Unable to encode url: %v", err)
-			continue
-		}
-
-		png, err := qrcode.Encode(lnurl, qrcode.Highest, 256)
-		if err != nil {
-			log.Errorf("Unable to encode QR code: %v", err)
-			continue
-		}
-
-		users = append(users, user{
-			User:    userName,
-			Encoded: lnurl,
-			QRCode:  base64.StdEncoding.EncodeToString(png),
-		})
-
-	}
-	htmlTemplate := `<!DOCTYPE html>
-<html>
-<head>
-	<title>LNURLs</title>
-</head>
-<body>
-	<h1>LNURLs</h1>
-	<ul>
-		{{range .}}
-		<li>
-			<h2>User: {{.User}}</h2>
-			<img src="data::image/png;base64, {{.QRCode}}" style="margin-left:-18px"/><br/>
-			<pre>{{.Encoded}}</pre>
-		</li>
-		{{end}}
-	</ul>
-</body>
-</html>
-`
-
-	bodyTemlate, err := template.New("html").Parse(htmlTemplate)
-	if err != nil {
-		log.Errorf("Error building URL template: %w", err)
-		return
-	}
-
-	var buf bytes.Buffer
-	err = bodyTemlate.Execute(&buf, users)
-	if err != nil {
-		log.Errorf("Error executing URL template: %w", err)
-		return
-	}
-
-	http.HandleFunc(
-		"/", useLogger(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(buf.Bytes())
-		}),
-	)
-}
-
 func metadataToString(config ServerConfig) (string, error) {
 	if config.Thumbnail != "" {
 		thumbnailMetadata, err := thumbnailToMetadata(config.Thumbnail)
@@ -380,8 +710,8 @@ var (
 	maxMsgRecvSize = grpc.MaxCallRecvMsgSize(1 * 1024 * 1024 * 200)
 )
 
-func getClientConn(address, tlsCertPath, macaroonPath string) (*grpc.ClientConn,
-    error) {
+func getClientConn(address, tlsCertPath, macaroonPath string,
+	torCfg *TorConfig) (*grpc.ClientConn, error) {
 
 	// We always need to send a macaroon.
 	macOption, err := readMacaroon(macaroonPath)
@@ -389,12 +719,19 @@ func getClientConn(address, tlsCertPath, macaroonPath string) (*grpc.ClientConn,
 		return nil, err
 	}
 
-	// TODO (hieblmi) Support Tor dialing
 	opts := []grpc.DialOption{
 		grpc.WithDefaultCallOptions(maxMsgRecvSize),
 		macOption,
 	}
 
+	// If a SOCKS proxy is configured, we dial lnd through Tor which also
+	// allows RPCHost to be an onion address.
+	if torCfg != nil && torCfg.SOCKS != "" {
+		log.Infof("Connecting to lnd at %s through Tor proxy %s",
+			address, torCfg.SOCKS)
+		opts = append(opts, grpc.WithContextDialer(torDialer(torCfg)))
+	}
+
 	// TLS cannot be disabled; we'll always have a cert file to read.
 	creds, err := credentials.NewClientTLSFromFile(tlsCertPath, "")
 	if err != nil {
@@ -412,6 +749,20 @@ func getClientConn(address, tlsCertPath, macaroonPath string) (*grpc.ClientConn,
 	return conn, nil
 }
 
+// openDB opens the bbolt database in the working directory that holds all
+// state which must survive a restart.
+func openDB(workingDir string) (*bbolt.DB, error) {
+	path := filepath.Join(workingDir, "lnaddr.db")
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
 // readMacaroon tries to read the macaroon file at the specified path and create
 // gRPC dial options from it.
 func readMacaroon(macPath string) (grpc.DialOption, error) {
@@ -505,3 +856,36 @@ func prepareZaps(z *ZapsConfig) error {
 
 	return nil
 }
+
+// setNostrNotifierDefaults lets nostr notifiers without Nsec send from the
+// zaps key and those without Relays publish to the relays of the Nostr
+// section.
+func setNostrNotifierDefaults(config ServerConfig) {
+	var relays []string
+	if config.Nostr != nil {
+		for _, list := range config.Nostr.Relays {
+			for _, r := range list {
+				if !slices.Contains(relays, r) {
+					relays = append(relays, r)
+				}
+			}
+		}
+	}
+	slices.Sort(relays)
+
+	for i, n := range config.Notifiers {
+		if n.Type != "nostr" {
+			continue
+		}
+		if n.Params == nil {
+			n.Params = make(map[string]string)
+			config.Notifiers[i].Params = n.Params
+		}
+		if n.Params["Nsec"] == "" && config.Zaps != nil {
+			n.Params["Nsec"] = config.Zaps.Nsec
+		}
+		if n.Params["Relays"] == "" {
+			n.Params["Relays"] = strings.Join(relays, ",")
+		}
+	}
+}
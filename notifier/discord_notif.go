@@ -0,0 +1,178 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTitleTemplate is the title of rich messages without
+	// TitleTemplate.
+	defaultTitleTemplate = "⚡ {{.Amount}} sats received"
+
+	// defaultDiscordColor is the embed color without Color, bitcoin
+	// orange.
+	defaultDiscordColor = 0xf7931a
+)
+
+// DiscordNotifier posts an embed to a Discord webhook.
+type DiscordNotifier struct {
+	Cfg Config
+
+	// URL is the webhook URL.
+	URL string
+
+	// Username and AvatarURL override the defaults of the webhook.
+	Username  string
+	AvatarURL string
+
+	// Color is the color of the embed's left border.
+	Color int
+
+	title  messageTemplate
+	client *http.Client
+}
+
+var _ Notifier = (*DiscordNotifier)(nil)
+
+type discordMessage struct {
+	Username        string                 `json:"username,omitempty"`
+	AvatarURL       string                 `json:"avatar_url,omitempty"`
+	Embeds          []discordEmbed         `json:"embeds"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+type discordEmbed struct {
+	Title     string         `json:"title"`
+	Color     int            `json:"color"`
+	Fields    []discordField `json:"fields"`
+	Timestamp string         `json:"timestamp,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordAllowedMentions with an empty Parse list keeps comments like
+// "@everyone" from pinging anybody.
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// NewDiscordNotifier checks the webhook URL and color and compiles the title
+// template of the notifier.
+func NewDiscordNotifier(cfg Config) (*DiscordNotifier, error) {
+	d := &DiscordNotifier{
+		Cfg:       cfg,
+		URL:       cfg.Params["Target"],
+		Username:  cfg.Params["Username"],
+		AvatarURL: cfg.Params["AvatarURL"],
+		Color:     defaultDiscordColor,
+		client:    &http.Client{Timeout: cfg.timeout()},
+	}
+
+	if err := checkWebhookURL(d.URL); err != nil {
+		return nil, err
+	}
+
+	if c := cfg.Params["Color"]; c != "" {
+		color, err := strconv.ParseUint(strings.TrimPrefix(c, "#"), 16, 24)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Color %q, expected a hex "+
+				"color like #f7931a", c)
+		}
+		d.Color = int(color)
+	}
+
+	var err error
+	d.title, err = parseTemplate(
+		"title", paramOr(cfg, "TitleTemplate", defaultTitleTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid title template: %w", err)
+	}
+
+	return d, nil
+}
+
+// checkWebhookURL checks that the URL is an absolute http(s) URL.
+func checkWebhookURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") ||
+		u.Host == "" {
+
+		return fmt.Errorf("invalid webhook URL %q", target)
+	}
+
+	return nil
+}
+
+func (d *DiscordNotifier) Notify(ctx context.Context, payment *Payment) error {
+	msg, err := d.message(payment)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, d.client, d.URL, nil, msg)
+}
+
+// message builds the webhook message for the payment. Embed limits are
+// respected by truncating long values.
+func (d *DiscordNotifier) message(payment *Payment) (*discordMessage,
+	error) {
+
+	title, err := render(d.title, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing title template: %w",
+			err)
+	}
+
+	embed := discordEmbed{
+		Title: truncate(title, 256),
+		Color: d.Color,
+	}
+	for _, f := range paymentFields(payment) {
+		embed.Fields = append(embed.Fields, discordField{
+			Name:   f.Name,
+			Value:  truncate(escapeDiscord(f.Value), 1024),
+			Inline: f.Short,
+		})
+	}
+	if !payment.SettledAt.IsZero() {
+		embed.Timestamp = payment.SettledAt.UTC().Format(time.RFC3339)
+	}
+
+	return &discordMessage{
+		Username:        d.Username,
+		AvatarURL:       d.AvatarURL,
+		Embeds:          []discordEmbed{embed},
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	}, nil
+}
+
+// discordReplacer escapes Discord's markdown.
+var discordReplacer = func() *strings.Replacer {
+	var pairs []string
+	for _, c := range "\\*_~`|>[]()#-" {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+
+	return strings.NewReplacer(pairs...)
+}()
+
+// escapeDiscord escapes text for Discord's markdown.
+func escapeDiscord(s string) string {
+	return discordReplacer.Replace(s)
+}
+
+func (d *DiscordNotifier) Target() string {
+	return "Discord webhook"
+}
@@ -0,0 +1,178 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubWebhook records the JSON bodies posted to it and answers with the
+// queued responses first.
+type stubWebhook struct {
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	bodies    []json.RawMessage
+	responses []func(w http.ResponseWriter)
+}
+
+func newStubWebhook(t *testing.T,
+	responses ...func(w http.ResponseWriter)) *stubWebhook {
+
+	t.Helper()
+
+	s := &stubWebhook{responses: responses}
+	s.srv = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var body json.RawMessage
+			if r.Method != http.MethodPost ||
+				r.Header.Get("Content-Type") != "application/json" ||
+				json.NewDecoder(r.Body).Decode(&body) != nil {
+
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.bodies = append(s.bodies, body)
+			if len(s.responses) > 0 {
+				s.responses[0](w)
+				s.responses = s.responses[1:]
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		},
+	))
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+func (s *stubWebhook) received() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]json.RawMessage{}, s.bodies...)
+}
+
+// rateLimited answers with 429 and the given Retry-After header or Discord
+// body.
+func rateLimited(header, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if header != "" {
+			w.Header().Set("Retry-After", header)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// zapPayment is a zap with all details shown in rich messages.
+var zapPayment = &Payment{
+	Address:    "tips@example.com",
+	AmountMsat: 21_000,
+	Comment:    "@everyone *great* stream",
+	PayerData:  &PayerData{Name: "satoshi"},
+	ZapSender: "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9" +
+		"aaaefa459d",
+	SettledAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+}
+
+func TestDiscordNotifier_Notify(t *testing.T) {
+	hook := newStubWebhook(t)
+	n, err := NewDiscordNotifier(Config{
+		Type: "discord",
+		Params: map[string]string{
+			"Target":   hook.srv.URL,
+			"Username": "lnaddr",
+			"Color":    "#00ff00",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDiscordNotifier returned error: %v", err)
+	}
+
+	if err := n.Notify(context.Background(), zapPayment); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	bodies := hook.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected one request, got %d", len(bodies))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal(bodies[0], &msg); err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if msg.Username != "lnaddr" || len(msg.Embeds) != 1 ||
+		msg.AllowedMentions.Parse == nil {
+
+		t.Fatalf("unexpected message %s", bodies[0])
+	}
+
+	embed := msg.Embeds[0]
+	if embed.Title != "⚡ 21 sats received" || embed.Color != 0x00ff00 ||
+		embed.Timestamp != "2024-03-01T10:00:00Z" {
+
+		t.Errorf("unexpected embed %+v", embed)
+	}
+
+	expected := []discordField{
+		{"Amount", "21 sats", true},
+		{"Recipient", "tips@example.com", true},
+		{"Payer", "satoshi", true},
+		{"Zap from", "npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64t" +
+			"h6gkwsyjh6w6", false},
+		{"Comment", `@everyone \*great\* stream`, false},
+	}
+	if len(embed.Fields) != len(expected) {
+		t.Fatalf("unexpected fields %+v", embed.Fields)
+	}
+	for i, f := range expected {
+		if embed.Fields[i] != f {
+			t.Errorf("unexpected field %d. want %+v got %+v", i, f,
+				embed.Fields[i])
+		}
+	}
+}
+
+func TestDiscordNotifier_RateLimit(t *testing.T) {
+	// Short rate limits are waited out.
+	hook := newStubWebhook(t, rateLimited("", `{"retry_after": 0.01}`))
+	n, err := NewDiscordNotifier(Config{
+		Type:   "discord",
+		Params: map[string]string{"Target": hook.srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("NewDiscordNotifier returned error: %v", err)
+	}
+
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	if len(hook.received()) != 2 {
+		t.Fatalf("expected a retry, got %d requests",
+			len(hook.received()))
+	}
+
+	// Long ones are left to the outbox.
+	hook = newStubWebhook(t, rateLimited("", `{"retry_after": 60}`))
+	n.URL = hook.srv.URL
+
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	var rateLimit *RateLimitError
+	if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != time.Minute {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if len(hook.received()) != 1 {
+		t.Fatalf("unexpected retry")
+	}
+}
@@ -0,0 +1,89 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultTimeout bounds a single notification if the notifier has no
+	// TimeoutSec configured.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultWorkers is the number of notifications sent concurrently.
+	DefaultWorkers = 4
+)
+
+var (
+	notifyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "lnaddr",
+			Subsystem: "notifier",
+			Name:      "duration_seconds",
+			Help:      "Time taken to send a notification.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"notifier"},
+	)
+
+	notifyFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "lnaddr",
+			Subsystem: "notifier",
+			Name:      "failures_total",
+			Help:      "Notifications that failed or timed out.",
+		}, []string{"notifier"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(notifyDuration, notifyFailures)
+}
+
+// timeout returns the configured timeout of a notifier.
+func (c *Config) timeout() time.Duration {
+	if c.TimeoutSec <= 0 {
+		return DefaultTimeout
+	}
+
+	return time.Duration(c.TimeoutSec) * time.Second
+}
+
+// notifyTimeout sends the notification with a context that ends after the
+// timeout. Notifiers return once the context is done, so an abandoned
+// notification can't be delivered after it was given up. The duration and
+// failures are recorded per notifier name.
+func notifyTimeout(ctx context.Context, name string, n Notifier,
+	timeout time.Duration, payment *Payment) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := n.Notify(ctx, payment)
+	// Notifiers may wrap the context's error in their own, e.g. the
+	// error of an aborted request.
+	ctxErr := ctx.Err()
+	if err != nil && ctxErr != nil && !errors.Is(err, ctxErr) {
+		err = fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	notifyDuration.WithLabelValues(name).Observe(
+		time.Since(start).Seconds(),
+	)
+
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return err
+
+	case errors.Is(err, context.DeadlineExceeded):
+		notifyFailures.WithLabelValues(name).Inc()
+		return fmt.Errorf("timed out after %v: %w", timeout, err)
+
+	default:
+		notifyFailures.WithLabelValues(name).Inc()
+		return err
+	}
+}
@@ -0,0 +1,175 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execWaitDelay is how long a killed command may keep its output open before
+// Notify returns anyway, e.g. if it started children that inherited it.
+const execWaitDelay = time.Second
+
+// execOutputLimit is how many bytes of the end of a command's output are kept.
+const execOutputLimit = 4096
+
+// ExecNotifier runs a local command for every payment. The payment is passed
+// as LNADDR_* environment variables and as JSON on stdin.
+type ExecNotifier struct {
+	Cfg Config
+
+	// Command is the executable, Args its arguments.
+	Command string
+	Args    []string
+
+	// Dir is the working directory of the command, the server's if empty.
+	Dir string
+
+	timeout time.Duration
+}
+
+var _ Notifier = (*ExecNotifier)(nil)
+
+// NewExecNotifier splits the command line of the notifier into executable
+// and arguments and checks that the executable exists.
+func NewExecNotifier(cfg Config) (*ExecNotifier, error) {
+	args := strings.Fields(cfg.Params["Command"])
+	if len(args) == 0 {
+		return nil, errors.New("Command required")
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Command: %w", err)
+	}
+
+	return &ExecNotifier{
+		Cfg:     cfg,
+		Command: path,
+		Args:    args[1:],
+		Dir:     cfg.Params["Dir"],
+		timeout: cfg.timeout(),
+	}, nil
+}
+
+// Notify runs the command and waits for it to exit. A non-zero exit status is
+// returned as error together with the end of the command's output. The
+// command is killed once the notifier's timeout passed.
+func (e *ExecNotifier) Notify(ctx context.Context, payment *Payment) error {
+	stdin, err := json.Marshal(&jsonPayment{
+		Payment:   payment,
+		AmountSat: payment.AmountSat(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	output := &tailBuffer{limit: execOutputLimit}
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Dir = e.Dir
+	cmd.Env = append(os.Environ(), paymentEnv(ctx, payment)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = execWaitDelay
+
+	err = cmd.Run()
+	out := strings.TrimSpace(string(output.buf))
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("command killed: %w", ctx.Err())
+
+	case err != nil && out != "":
+		return fmt.Errorf("command failed: %w: %s", err,
+			truncate(lastLine(out), 200))
+
+	case err != nil:
+		return fmt.Errorf("command failed: %w", err)
+	}
+
+	if out != "" {
+		log.Debugf("Command %s: %s", e.Command, truncate(out, 200))
+	}
+
+	return nil
+}
+
+// tailBuffer is a writer that keeps the last limit bytes written to it.
+type tailBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+
+	return len(p), nil
+}
+
+// lastLine returns the last line of s, which usually holds the error message
+// of a failed command.
+func lastLine(s string) string {
+	return s[strings.LastIndexByte(s, '\n')+1:]
+}
+
+// paymentEnv returns the payment as LNADDR_* environment variables. Empty
+// fields are left out.
+func paymentEnv(ctx context.Context, p *Payment) []string {
+	vars := [][2]string{
+		{"ADDRESS", p.Address},
+		{"AMOUNT_SAT", strconv.FormatUint(p.AmountSat(), 10)},
+		{"AMOUNT_MSAT", strconv.FormatUint(p.AmountMsat, 10)},
+		{"COMMENT", p.Comment},
+		{"PAYMENT_HASH", p.PaymentHash},
+		{"PREIMAGE", p.Preimage},
+		{"ZAP_SENDER", p.ZapSender},
+		{"ZAP_EVENT_ID", p.ZapEventID},
+	}
+	if !p.SettledAt.IsZero() {
+		vars = append(vars, [2]string{
+			"SETTLED_AT", p.SettledAt.UTC().Format(time.RFC3339),
+		})
+	}
+	if p.PayerData != nil {
+		vars = append(vars,
+			[2]string{"PAYER_NAME", p.PayerData.Name},
+			[2]string{"PAYER_IDENTIFIER", p.PayerData.Identifier},
+			[2]string{"PAYER_EMAIL", p.PayerData.Email},
+			[2]string{"PAYER_PUBKEY", p.PayerData.Pubkey},
+		)
+	}
+	if id, ok := DeliveryID(ctx); ok {
+		vars = append(vars, [2]string{"DELIVERY_ID", id})
+	}
+
+	env := make([]string, 0, len(vars))
+	for _, v := range vars {
+		if v[1] == "" {
+			continue
+		}
+
+		// Environment variables can't hold NUL bytes, the command
+		// wouldn't start.
+		value := strings.ReplaceAll(v[1], "\x00", "")
+		env = append(env, "LNADDR_"+v[0]+"="+value)
+	}
+
+	return env
+}
+
+func (e *ExecNotifier) Target() string {
+	return e.Command
+}
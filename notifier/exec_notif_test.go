@@ -0,0 +1,151 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript writes a shell script to a temporary directory and returns its
+// path.
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700)
+	if err != nil {
+		t.Fatalf("unable to write script: %v", err)
+	}
+
+	return path
+}
+
+func TestExecNotifier_Notify(t *testing.T) {
+	dir := t.TempDir()
+	script := writeScript(t, `env | grep ^LNADDR_ | sort > "$1/env"
+cat > "$1/stdin"
+`)
+	n := newTestNotifier(t, NewExecNotifier, map[string]string{
+		"Command": script + " " + dir,
+	})
+
+	ctx := context.WithValue(
+		context.Background(), deliveryIDKey{}, "aa:exec",
+	)
+	err := n.Notify(ctx, &Payment{
+		Address:     "tips@example.com",
+		AmountMsat:  21_500,
+		Comment:     "thanks",
+		PaymentHash: "aa",
+		PayerData:   &PayerData{Name: "satoshi"},
+		SettledAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatalf("unable to read env: %v", err)
+	}
+	expected := []string{
+		"LNADDR_ADDRESS=tips@example.com",
+		"LNADDR_AMOUNT_MSAT=21500",
+		"LNADDR_AMOUNT_SAT=21",
+		"LNADDR_COMMENT=thanks",
+		"LNADDR_DELIVERY_ID=aa:exec",
+		"LNADDR_PAYER_NAME=satoshi",
+		"LNADDR_PAYMENT_HASH=aa",
+		"LNADDR_SETTLED_AT=2024-01-02T03:04:05Z",
+	}
+	lines := strings.Split(strings.TrimSpace(string(env)), "\n")
+	if !slices.Equal(lines, expected) {
+		t.Fatalf("unexpected environment %q", lines)
+	}
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatalf("unable to read stdin: %v", err)
+	}
+	var payment struct {
+		Address   string `json:"address"`
+		AmountSat uint64 `json:"amountSat"`
+	}
+	if err := json.Unmarshal(stdin, &payment); err != nil {
+		t.Fatalf("unable to decode stdin %s: %v", stdin, err)
+	}
+	if payment.Address != "tips@example.com" || payment.AmountSat != 21 {
+		t.Fatalf("unexpected stdin %s", stdin)
+	}
+}
+
+func TestExecNotifier_Failure(t *testing.T) {
+	script := writeScript(t, `echo "printing receipt"
+echo "printer offline" >&2
+exit 3
+`)
+	n := newTestNotifier(t, NewExecNotifier, map[string]string{
+		"Command": script,
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") ||
+		!strings.HasSuffix(err.Error(), ": printer offline") {
+
+		t.Fatalf("expected exit status 3 error, got %v", err)
+	}
+}
+
+func TestExecNotifier_Timeout(t *testing.T) {
+	n, err := NewExecNotifier(Config{
+		TimeoutSec: 1,
+		Params:     map[string]string{"Command": "sleep 10"},
+	})
+	if err != nil {
+		t.Fatalf("NewExecNotifier returned error: %v", err)
+	}
+
+	start := time.Now()
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Fatalf("expected killed command, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("command wasn't killed after the timeout, took %v",
+			elapsed)
+	}
+}
+
+func TestExecNotifier_LongOutput(t *testing.T) {
+	script := writeScript(t, `yes printing | head -c 1000000
+echo
+echo "printer offline"
+exit 1
+`)
+	n := newTestNotifier(t, NewExecNotifier, map[string]string{
+		"Command": script,
+	})
+
+	// Only the end of the output is kept, which has the error message.
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.HasSuffix(err.Error(), ": printer offline") {
+		t.Fatalf("expected printer offline error, got %v", err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 4}
+	for _, s := range []string{"ab", "cdef", "g"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+	}
+	if string(b.buf) != "defg" {
+		t.Fatalf("unexpected tail %q", b.buf)
+	}
+}
@@ -0,0 +1,214 @@
+package notifier
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+const (
+	// ZapsOnly only passes zaps.
+	ZapsOnly = "only"
+
+	// ZapsExclude drops zaps.
+	ZapsExclude = "exclude"
+)
+
+// QuietHours is a daily period without notifications. The period may span
+// midnight, e.g. from 22:00 to 07:00.
+type QuietHours struct {
+	Start    string `json:"Start" toml:"Start"`
+	End      string `json:"End" toml:"End"`
+	Timezone string `json:"Timezone" toml:"Timezone"`
+}
+
+// Filter selects the payments a notifier is called for. The zero value
+// passes all payments.
+type Filter struct {
+	// MinAmount and MaxAmount bound the paid amount in sats. Zero means
+	// no bound.
+	MinAmount uint64 `json:"MinAmount" toml:"MinAmount"`
+	MaxAmount uint64 `json:"MaxAmount" toml:"MaxAmount"`
+
+	// Addresses restricts the notifier to payments to these lightning
+	// addresses. Entries are matched like lookups, case-insensitively
+	// and with or without the domain, and must be configured addresses.
+	Addresses []string `json:"Addresses" toml:"Addresses"`
+
+	// Zaps is ZapsOnly or ZapsExclude, empty passes all payments.
+	Zaps string `json:"Zaps" toml:"Zaps"`
+
+	// CommentRequired drops payments without comment. CommentRegex drops
+	// payments whose comment doesn't match it.
+	CommentRequired bool   `json:"CommentRequired" toml:"CommentRequired"`
+	CommentRegex    string `json:"CommentRegex" toml:"CommentRegex"`
+
+	QuietHours *QuietHours `json:"QuietHours" toml:"QuietHours"`
+}
+
+// filter is the compiled form of a Filter.
+type filter struct {
+	Filter
+
+	// names are the normalized usernames of Addresses.
+	names        map[string]bool
+	commentRegex *regexp.Regexp
+
+	// quietStart and quietEnd are minutes since midnight in quietLoc.
+	quietStart, quietEnd int
+	quietLoc             *time.Location
+}
+
+// newFilter compiles the filter of a notifier. The MinAmount of the config
+// is used if the filter has none. The Addresses are resolved to the users of
+// the store.
+func newFilter(cfg Config, userStore users.Store) (*filter, error) {
+	f := &filter{Filter: cfg.Filter}
+	if f.MinAmount == 0 {
+		f.MinAmount = cfg.MinAmount
+	}
+	if f.MaxAmount != 0 && f.MaxAmount < f.MinAmount {
+		return nil, fmt.Errorf("MaxAmount %d below MinAmount %d",
+			f.MaxAmount, f.MinAmount)
+	}
+
+	if len(f.Addresses) > 0 {
+		f.names = make(map[string]bool, len(f.Addresses))
+		for _, a := range f.Addresses {
+			name, err := filterUser(userStore, a)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w",
+					a, err)
+			}
+			f.names[name] = true
+		}
+	}
+
+	switch f.Zaps {
+	case "", ZapsOnly, ZapsExclude:
+	default:
+		return nil, fmt.Errorf("invalid Zaps filter %q, expected %q or "+
+			"%q", f.Zaps, ZapsOnly, ZapsExclude)
+	}
+
+	if f.CommentRegex != "" {
+		var err error
+		f.commentRegex, err = regexp.Compile(f.CommentRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CommentRegex: %w", err)
+		}
+	}
+
+	if q := f.QuietHours; q != nil {
+		var err error
+		if f.quietStart, err = parseClock(q.Start); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours start: %w",
+				err)
+		}
+		if f.quietEnd, err = parseClock(q.End); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours end: %w", err)
+		}
+		if f.quietLoc, err = time.LoadLocation(q.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours timezone: "+
+				"%w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// filterUser returns the username of a filter address. The address must be
+// configured, a domain given with it must match the configured one.
+func filterUser(userStore users.Store, address string) (string, error) {
+	if userStore == nil {
+		return "", users.ErrUnknownUser
+	}
+
+	user, err := userStore.Lookup(address)
+	if err != nil {
+		return "", err
+	}
+
+	address = strings.TrimSpace(address)
+	if strings.Contains(address, "@") &&
+		!strings.EqualFold(address, user.Address) {
+
+		return "", fmt.Errorf("not a configured address, did you mean "+
+			"%s", user.Address)
+	}
+
+	return user.Name, nil
+}
+
+// parseClock returns the minutes since midnight of a time like "22:30".
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// skip returns why the payment is filtered out, or an empty string if the
+// notifier should be called.
+func (f *filter) skip(p *Payment) string {
+	amount := p.AmountSat()
+	switch {
+	case amount < f.MinAmount:
+		return fmt.Sprintf("amount %d below %d sats", amount,
+			f.MinAmount)
+
+	case f.MaxAmount != 0 && amount > f.MaxAmount:
+		return fmt.Sprintf("amount %d above %d sats", amount,
+			f.MaxAmount)
+
+	case f.names != nil && !f.selected(p.Address):
+		return fmt.Sprintf("address %q not selected", p.Address)
+
+	case f.Zaps == ZapsOnly && !p.IsZap():
+		return "not a zap"
+
+	case f.Zaps == ZapsExclude && p.IsZap():
+		return "zap"
+
+	case f.CommentRequired && p.Comment == "":
+		return "no comment"
+
+	case f.commentRegex != nil && !f.commentRegex.MatchString(p.Comment):
+		return "comment doesn't match"
+
+	case f.quiet(p.SettledAt):
+		return "quiet hours"
+	}
+
+	return ""
+}
+
+// selected returns whether the payment address is one of the filter's.
+func (f *filter) selected(address string) bool {
+	name, err := users.Normalize(address)
+
+	return err == nil && f.names[name]
+}
+
+// quiet returns whether the time falls into the quiet hours.
+func (f *filter) quiet(t time.Time) bool {
+	if f.quietLoc == nil {
+		return false
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	t = t.In(f.quietLoc)
+	minute := t.Hour()*60 + t.Minute()
+	if f.quietStart <= f.quietEnd {
+		return minute >= f.quietStart && minute < f.quietEnd
+	}
+
+	return minute >= f.quietStart || minute < f.quietEnd
+}
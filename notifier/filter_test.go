@@ -0,0 +1,137 @@
+package notifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+func newTestUserStore(t *testing.T) users.Store {
+	t.Helper()
+
+	store, err := users.NewConfigStore([]string{
+		"tips@example.com", "other@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	return store
+}
+
+func TestFilter(t *testing.T) {
+	f, err := newFilter(Config{Filter: Filter{
+		MinAmount:    10,
+		MaxAmount:    1000,
+		Addresses:    []string{"Tips@example.com"},
+		Zaps:         ZapsExclude,
+		CommentRegex: `(?i)coffee`,
+		QuietHours: &QuietHours{
+			Start:    "22:00",
+			End:      "07:00",
+			Timezone: "Europe/Vienna",
+		},
+	}}, newTestUserStore(t))
+	if err != nil {
+		t.Fatalf("newFilter: %v", err)
+	}
+
+	// 12:00 in Vienna.
+	noon := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	pass := Payment{
+		Address:    "tips@example.com",
+		AmountMsat: 21_000,
+		Comment:    "for a Coffee",
+		SettledAt:  noon,
+	}
+	if reason := f.skip(&pass); reason != "" {
+		t.Fatalf("payment skipped: %s", reason)
+	}
+
+	for name, modify := range map[string]func(p *Payment){
+		"below min":   func(p *Payment) { p.AmountMsat = 9_999 },
+		"above max":   func(p *Payment) { p.AmountMsat = 1_001_000 },
+		"address":     func(p *Payment) { p.Address = "other@example.com" },
+		"zap":         func(p *Payment) { p.ZapSender = "abcd" },
+		"comment":     func(p *Payment) { p.Comment = "thanks" },
+		"no comment":  func(p *Payment) { p.Comment = "" },
+		"quiet night": func(p *Payment) { p.SettledAt = noon.Add(11 * time.Hour) },
+		"quiet early": func(p *Payment) { p.SettledAt = noon.Add(-6 * time.Hour) },
+	} {
+		p := pass
+		modify(&p)
+		if f.skip(&p) == "" {
+			t.Errorf("%s: payment not skipped", name)
+		}
+	}
+
+	// Quiet hours end at 07:00 Vienna time.
+	p := pass
+	p.SettledAt = noon.Add(-5 * time.Hour)
+	if reason := f.skip(&p); reason != "" {
+		t.Errorf("payment at end of quiet hours skipped: %s", reason)
+	}
+}
+
+func TestFilterZapsOnlyAndComment(t *testing.T) {
+	f, err := newFilter(Config{Filter: Filter{
+		Zaps:            ZapsOnly,
+		CommentRequired: true,
+	}}, nil)
+	if err != nil {
+		t.Fatalf("newFilter: %v", err)
+	}
+
+	if f.skip(&Payment{Comment: "gm"}) == "" {
+		t.Errorf("non-zap not skipped")
+	}
+	if f.skip(&Payment{ZapSender: "abcd"}) == "" {
+		t.Errorf("zap without comment not skipped")
+	}
+	if reason := f.skip(&Payment{ZapSender: "abcd", Comment: "gm"}); reason != "" {
+		t.Errorf("zap skipped: %s", reason)
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Filter: Filter{MinAmount: 10, MaxAmount: 5}},
+		{Filter: Filter{Zaps: "maybe"}},
+		{Filter: Filter{CommentRegex: "("}},
+		{Filter: Filter{QuietHours: &QuietHours{
+			Start: "25:00", End: "07:00", Timezone: "UTC",
+		}}},
+		{Filter: Filter{QuietHours: &QuietHours{
+			Start: "22:00", End: "07:00", Timezone: "Mars/Olympus",
+		}}},
+		{Filter: Filter{Addresses: []string{"unknown@example.com"}}},
+		{Filter: Filter{Addresses: []string{"tips@example.org"}}},
+		{Filter: Filter{Addresses: []string{"not valid"}}},
+	} {
+		_, err := newFilter(cfg, newTestUserStore(t))
+		if err == nil {
+			t.Errorf("expected error for %+v", cfg.Filter)
+		}
+	}
+}
+
+func TestFilterAddressesNormalized(t *testing.T) {
+	f, err := newFilter(Config{Filter: Filter{
+		Addresses: []string{" Tips"},
+	}}, newTestUserStore(t))
+	if err != nil {
+		t.Fatalf("newFilter: %v", err)
+	}
+
+	for _, address := range []string{"tips@example.com", "TIPS@Example.com"} {
+		if reason := f.skip(&Payment{Address: address}); reason != "" {
+			t.Errorf("payment to %s skipped: %s", address, reason)
+		}
+	}
+	for _, address := range []string{"other@example.com", ""} {
+		if f.skip(&Payment{Address: address}) == "" {
+			t.Errorf("payment to %q not skipped", address)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GotifyNotifier pushes messages to a Gotify server.
+type GotifyNotifier struct {
+	Cfg Config
+
+	// Server is the base URL of the Gotify server.
+	Server string
+
+	// Token is the token of the Gotify application the messages are sent
+	// as.
+	Token string
+
+	// Priority is 0 to 10, the application's default if nil.
+	Priority *int
+
+	// Tags are appended to the message as hashtags, Gotify has no tags of
+	// its own.
+	Tags []string
+
+	templates *pushTemplates
+	client    *http.Client
+}
+
+var _ Notifier = (*GotifyNotifier)(nil)
+
+// gotifyMessage is the message of Gotify's create message API.
+type gotifyMessage struct {
+	Title    string         `json:"title,omitempty"`
+	Message  string         `json:"message"`
+	Priority *int           `json:"priority,omitempty"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+// NewGotifyNotifier checks the server URL, token and priority and compiles
+// the templates of the notifier.
+func NewGotifyNotifier(cfg Config) (*GotifyNotifier, error) {
+	g := &GotifyNotifier{
+		Cfg:    cfg,
+		Server: strings.TrimSuffix(cfg.Params["Server"], "/"),
+		Token:  cfg.Params["Token"],
+		Tags:   splitList(cfg.Params["Tags"]),
+		client: &http.Client{Timeout: cfg.timeout()},
+	}
+
+	if err := checkWebhookURL(g.Server); err != nil {
+		return nil, fmt.Errorf("invalid Server: %w", err)
+	}
+	if g.Token == "" {
+		return nil, errors.New("Token required")
+	}
+
+	if p := cfg.Params["Priority"]; p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil || priority < 0 || priority > 10 {
+			return nil, fmt.Errorf("invalid Priority %q, expected 0 "+
+				"to 10", p)
+		}
+		g.Priority = &priority
+	}
+
+	var err error
+	g.templates, err = newPushTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Notify creates the message with the application token. The click URL is
+// passed in the client::notification extras the Android app opens.
+func (g *GotifyNotifier) Notify(ctx context.Context, payment *Payment) error {
+	msg, err := g.templates.render(payment)
+	if err != nil {
+		return err
+	}
+
+	body := msg.Body
+	if len(g.Tags) > 0 {
+		body += "\n\n#" + strings.Join(g.Tags, " #")
+	}
+
+	gotifyMsg := &gotifyMessage{
+		Title:    msg.Title,
+		Message:  body,
+		Priority: g.Priority,
+	}
+	if msg.Click != "" {
+		gotifyMsg.Extras = map[string]any{
+			"client::notification": map[string]any{
+				"click": map[string]string{"url": msg.Click},
+			},
+		}
+	}
+
+	header := http.Header{"X-Gotify-Key": {g.Token}}
+
+	return postJSON(ctx, g.client, g.Server+"/message", header, gotifyMsg)
+}
+
+func (g *GotifyNotifier) Target() string {
+	return g.Server
+}
@@ -0,0 +1,92 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGotifyNotifier_Notify(t *testing.T) {
+	srv := newStubPushServer(t, "X-Gotify-Key", "app-token")
+	n := newTestNotifier(t, NewGotifyNotifier, map[string]string{
+		"Server":        srv.srv.URL + "/",
+		"Token":         "app-token",
+		"Priority":      "8",
+		"Tags":          "lightning,tips",
+		"TitleTemplate": "{{.Amount}} sats",
+		"BodyTemplate":  "{{.Comment}}",
+		"ClickTemplate": "https://mempool.space/lightning",
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		AmountMsat: 21_000,
+		Comment:    "thanks",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	requests := srv.received()
+	if len(requests) != 1 || requests[0].Path != "/message" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	var msg struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+		Extras   struct {
+			Notification struct {
+				Click struct {
+					URL string `json:"url"`
+				} `json:"click"`
+			} `json:"client::notification"`
+		} `json:"extras"`
+	}
+	if err := json.Unmarshal(requests[0].Body, &msg); err != nil {
+		t.Fatalf("unable to decode message: %v", err)
+	}
+	if msg.Title != "21 sats" ||
+		msg.Message != "thanks\n\n#lightning #tips" ||
+		msg.Priority != 8 ||
+		msg.Extras.Notification.Click.URL !=
+			"https://mempool.space/lightning" {
+
+		t.Fatalf("unexpected message %s", requests[0].Body)
+	}
+}
+
+func TestGotifyNotifier_DefaultPriority(t *testing.T) {
+	srv := newStubPushServer(t, "X-Gotify-Key", "app-token")
+	n := newTestNotifier(t, NewGotifyNotifier, map[string]string{
+		"Server": srv.srv.URL,
+		"Token":  "app-token",
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	// Without Priority and ClickTemplate the application's defaults apply.
+	requests := srv.received()
+	if len(requests) != 1 ||
+		strings.Contains(string(requests[0].Body), "priority") ||
+		strings.Contains(string(requests[0].Body), "extras") {
+
+		t.Fatalf("unexpected requests %v", requests)
+	}
+}
+
+func TestGotifyNotifier_Unauthorized(t *testing.T) {
+	srv := newStubPushServer(t, "X-Gotify-Key", "app-token")
+	n := newTestNotifier(t, NewGotifyNotifier, map[string]string{
+		"Server": srv.srv.URL,
+		"Token":  "wrong",
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected status 401 error, got %v", err)
+	}
+}
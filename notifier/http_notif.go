@@ -2,10 +2,20 @@ package notifier
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 )
 
 type encoding string
@@ -32,71 +42,198 @@ const (
 	EncodingJson encoding = "application/json"
 )
 
+const (
+	// SignatureHeader carries the HMAC signature of a request if a
+	// SigningSecret is configured.
+	SignatureHeader = "X-Lnaddr-Signature"
+
+	// headerParamPrefix marks the params that are sent as request headers,
+	// e.g. "Header.Authorization".
+	headerParamPrefix = "Header."
+)
+
 type HttpNotifier struct {
-	Cfg          Config
-	URL          string
-	Method       string
-	Encoding     encoding
-	BodyTemplate string
+	Cfg      Config
+	URL      string
+	Method   string
+	Encoding encoding
+
+	// Headers are added to every request.
+	Headers http.Header
+
+	// SigningSecret signs every request if set.
+	SigningSecret string
+
+	urlTemplate  *template.Template
+	bodyTemplate *template.Template
+	client       *http.Client
+
+	// now is replaced in tests.
+	now func() time.Time
 }
 
 var _ Notifier = (*HttpNotifier)(nil)
 
-func NewHttpNotifier(cfg Config) *HttpNotifier {
-	return &HttpNotifier{
-		Cfg:          cfg,
-		URL:          cfg.Params["Target"],
-		Method:       cfg.Params["Method"],
-		Encoding:     encoding(cfg.Params["Encoding"]),
-		BodyTemplate: cfg.Params["BodyTemplate"],
+// NewHttpNotifier compiles the URL and body templates of the notifier and
+// checks that they can be executed.
+func NewHttpNotifier(cfg Config) (*HttpNotifier, error) {
+	h := &HttpNotifier{
+		Cfg:           cfg,
+		URL:           cfg.Params["Target"],
+		Method:        strings.ToUpper(cfg.Params["Method"]),
+		Encoding:      encoding(cfg.Params["Encoding"]),
+		Headers:       make(http.Header),
+		SigningSecret: cfg.Params["SigningSecret"],
+		client:        &http.Client{Timeout: cfg.timeout()},
+		now:           time.Now,
+	}
+	if h.Method == "" {
+		h.Method = http.MethodGet
 	}
-}
 
-func (h *HttpNotifier) Notify(amount uint64, comment string) error {
-	bodyData := &struct {
-		Amount  uint64
-		Message string
-	}{
-		Amount:  amount,
-		Message: h.Encoding.EscapeValue(comment),
+	for k, v := range cfg.Params {
+		if name, ok := strings.CutPrefix(k, headerParamPrefix); ok {
+			h.Headers.Set(name, v)
+		}
+	}
+	if token := cfg.Params["BearerToken"]; token != "" {
+		h.Headers.Set("Authorization", "Bearer "+token)
+	}
+	if user := cfg.Params["Username"]; user != "" {
+		auth := user + ":" + cfg.Params["Password"]
+		h.Headers.Set("Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(auth)))
 	}
 
-	urlTemplate, err := template.New("url").Parse(h.URL)
+	funcs := template.FuncMap{"escape": h.Encoding.EscapeValue}
+
+	var err error
+	h.urlTemplate, err = template.New("url").Funcs(templateFuncs).Funcs(
+		funcs,
+	).Parse(h.URL)
 	if err != nil {
-		return fmt.Errorf("error building URL template: %w", err)
+		return nil, fmt.Errorf("error building URL template: %w", err)
 	}
 
-	bodyTemplate, err := template.New("body").Parse(h.BodyTemplate)
-	if err != nil {
-		return fmt.Errorf("error building body template: %w", err)
+	if body := cfg.Params["BodyTemplate"]; body != "" {
+		h.bodyTemplate, err = template.New("body").Funcs(
+			templateFuncs,
+		).Funcs(funcs).Parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("error building body template: "+
+				"%w", err)
+		}
+	}
+
+	if _, _, err := h.render(
+		&Payment{PayerData: &PayerData{}},
+	); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// templateData is passed to the URL and body templates. It exposes all
+// payment details and keeps the fields of the original templates.
+type templateData struct {
+	*Payment
+
+	// Amount is the paid amount in sats.
+	Amount uint64
+
+	// Message is the comment, escaped for the encoding.
+	Message string
+}
+
+// jsonPayment is the default body of JSON encoded requests without body
+// template.
+type jsonPayment struct {
+	*Payment
+
+	AmountSat uint64 `json:"amountSat"`
+}
+
+// hasBody returns whether the requests of the notifier carry a body.
+func (h *HttpNotifier) hasBody() bool {
+	return h.Method != http.MethodGet && h.Method != http.MethodHead
+}
+
+// render returns the URL and body of the request for the payment.
+func (h *HttpNotifier) render(payment *Payment) (string, []byte, error) {
+	data := &templateData{
+		Payment: payment,
+		Amount:  payment.AmountSat(),
+		Message: h.Encoding.EscapeValue(payment.Comment),
 	}
 
 	var buf bytes.Buffer
-	err = urlTemplate.Execute(&buf, bodyData)
-	if err != nil {
-		return fmt.Errorf("error executing URL template: %w", err)
+	if err := h.urlTemplate.Execute(&buf, data); err != nil {
+		return "", nil, fmt.Errorf("error executing URL template: %w",
+			err)
 	}
 	url := buf.String()
 
-	buf.Reset()
-	err = bodyTemplate.Execute(&buf, bodyData)
+	if !h.hasBody() {
+		return url, nil, nil
+	}
+
+	switch {
+	case h.bodyTemplate != nil:
+		buf.Reset()
+		if err := h.bodyTemplate.Execute(&buf, data); err != nil {
+			return "", nil, fmt.Errorf("error executing body "+
+				"template: %w", err)
+		}
+		return url, buf.Bytes(), nil
+
+	case h.Encoding == EncodingJson:
+		body, err := json.Marshal(&jsonPayment{
+			Payment:   payment,
+			AmountSat: payment.AmountSat(),
+		})
+		return url, body, err
+
+	default:
+		return url, nil, nil
+	}
+}
+
+func (h *HttpNotifier) Notify(ctx context.Context, payment *Payment) error {
+	url, body, err := h.render(payment)
 	if err != nil {
-		return fmt.Errorf("error executing body template: %w", err)
+		return err
 	}
 
 	var bodyReader io.Reader
-	if h.Method == http.MethodPost {
-		bodyReader = &buf
+	if h.hasBody() {
+		bodyReader = bytes.NewReader(body)
 	}
 
-	req, err := http.NewRequest(h.Method, url, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, h.Method, url, bodyReader)
 	if err != nil {
 		return fmt.Errorf("error creating request: %w", err)
 	}
 
-	h.Encoding.AddHeader(req.Header)
+	for k, v := range h.Headers {
+		req.Header[k] = v
+	}
+	if h.Encoding != "" {
+		h.Encoding.AddHeader(req.Header)
+	}
+	if h.SigningSecret != "" {
+		req.Header.Set(SignatureHeader, Sign(
+			h.SigningSecret, h.now(), body,
+		))
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// Deliveries may be retried, so receivers get the chance to drop
+	// duplicates.
+	if id, ok := DeliveryID(ctx); ok {
+		req.Header.Set("Idempotency-Key", id)
+	}
+
+	resp, err := h.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("error sending request: %w", err)
 	}
@@ -105,14 +242,14 @@ func (h *HttpNotifier) Notify(amount uint64, comment string) error {
 		_ = resp.Body.Close()
 	}()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
 	if err != nil {
 		return fmt.Errorf("error reading response body: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return fmt.Errorf("unexpected status code: %d (%s)",
-			resp.StatusCode, body)
+			resp.StatusCode, respBody)
 	}
 
 	return nil
@@ -121,3 +258,51 @@ func (h *HttpNotifier) Notify(amount uint64, comment string) error {
 func (h *HttpNotifier) Target() string {
 	return h.URL
 }
+
+// Sign returns the signature header of a request body sent at the given time.
+// The header has the form "t=<unix timestamp>,v1=<signature>", where the
+// signature is the hex encoded HMAC-SHA256 of "<unix timestamp>.<body>".
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+
+	return fmt.Sprintf("t=%s,v1=%s", t, signature(secret, t, body))
+}
+
+func signature(secret, t string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature header of a received request body.
+// Signatures older than the tolerance are rejected to prevent replays.
+func VerifySignature(secret, header string, body []byte,
+	tolerance time.Duration) error {
+
+	var t, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("malformed signature header")
+	}
+	if time.Since(time.Unix(unix, 0)).Abs() > tolerance {
+		return errors.New("signature timestamp out of tolerance")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, t, body))) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
@@ -1,11 +1,14 @@
 package notifier
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHttpNotifier_Notify_GET_JSONEncoding(t *testing.T) {
@@ -31,10 +34,12 @@ func TestHttpNotifier_Notify_GET_JSONEncoding(t *testing.T) {
 		},
 	}
 
-	n := NewHttpNotifier(cfg)
+	n := mustHttpNotifier(t, cfg)
 
 	comment := `quote: "hello world"`
-	if err := n.Notify(12345, comment); err != nil {
+	if err := n.Notify(
+		context.Background(), &Payment{AmountMsat: 12_345_000, Comment: comment},
+	); err != nil {
 		t.Fatalf("Notify returned error: %v", err)
 	}
 
@@ -81,10 +86,12 @@ func TestHttpNotifier_Notify_POST_FormEncoding(t *testing.T) {
 		},
 	}
 
-	n := NewHttpNotifier(cfg)
+	n := mustHttpNotifier(t, cfg)
 
 	comment := "a b&c"
-	if err := n.Notify(42, comment); err != nil {
+	if err := n.Notify(
+		context.Background(), &Payment{AmountMsat: 42_000, Comment: comment},
+	); err != nil {
 		t.Fatalf("Notify returned error: %v", err)
 	}
 
@@ -119,9 +126,9 @@ func TestHttpNotifier_Notify_Non200(t *testing.T) {
 		},
 	}
 
-	n := NewHttpNotifier(cfg)
+	n := mustHttpNotifier(t, cfg)
 
-	err := n.Notify(1, "")
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
 	if err == nil {
 		t.Fatalf("expected error on non-200 response, got nil")
 	}
@@ -129,3 +136,187 @@ func TestHttpNotifier_Notify_Non200(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestHttpNotifier_Notify_PaymentFields(t *testing.T) {
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer srv.Close()
+
+	n := mustHttpNotifier(t, Config{
+		Type: "http",
+		Params: map[string]string{
+			"Target":   srv.URL,
+			"Method":   http.MethodPost,
+			"Encoding": string(EncodingJson),
+			"BodyTemplate": `{"sats":{{.Amount}},"msat":{{.AmountMsat}},` +
+				`"to":"{{.Address}}","hash":"{{.PaymentHash}}",` +
+				`"zap":{{.IsZap}},"msg":"{{.Message}}"}`,
+		},
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		Address:     "tips@example.com",
+		AmountMsat:  21_500,
+		Comment:     `"hi"`,
+		ZapSender:   "abcd",
+		PaymentHash: "0011",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	expected := `{"sats":21,"msat":21500,"to":"tips@example.com",` +
+		`"hash":"0011","zap":true,"msg":"\"hi\""}`
+	if gotBody != expected {
+		t.Errorf("unexpected body. want %q got %q", expected, gotBody)
+	}
+}
+
+func TestHttpNotifier_Notify_IdempotencyKey(t *testing.T) {
+	var gotKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("Idempotency-Key")
+	}))
+	defer srv.Close()
+
+	n := mustHttpNotifier(t, Config{
+		Type:   "http",
+		Params: map[string]string{"Target": srv.URL, "Method": "GET"},
+	})
+
+	ctx := context.WithValue(context.Background(), deliveryIDKey{}, "aa:web")
+	if err := n.Notify(ctx, &Payment{AmountMsat: 1000}); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	if gotKey != "aa:web" {
+		t.Errorf("unexpected idempotency key %q", gotKey)
+	}
+}
+
+func mustHttpNotifier(t *testing.T, cfg Config) *HttpNotifier {
+	t.Helper()
+
+	n, err := NewHttpNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewHttpNotifier returned error: %v", err)
+	}
+
+	return n
+}
+
+func TestNewHttpNotifier_InvalidTemplates(t *testing.T) {
+	for _, params := range []map[string]string{
+		{"Target": "https://example.com/{{.Amount"},
+		{"Target": "https://example.com/{{.Unknown}}"},
+		{
+			"Target":       "https://example.com",
+			"Method":       http.MethodPost,
+			"BodyTemplate": "{{.PayerData.Nickname}}",
+		},
+	} {
+		_, err := NewHttpNotifier(Config{Type: "http", Params: params})
+		if err == nil {
+			t.Errorf("expected error for %v", params)
+		}
+	}
+}
+
+func TestHttpNotifier_Notify_HeadersAndSignature(t *testing.T) {
+	var (
+		gotHeader http.Header
+		gotBody   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	n := mustHttpNotifier(t, Config{
+		Type: "http",
+		Params: map[string]string{
+			"Target":          srv.URL,
+			"Method":          http.MethodPost,
+			"Encoding":        string(EncodingJson),
+			"BearerToken":     "secrettoken",
+			"Header.X-Source": "lnaddr",
+			"SigningSecret":   "whsec",
+		},
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		Address:     "tips@example.com",
+		AmountMsat:  21_000,
+		Comment:     "gm",
+		PaymentHash: "0011",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	if got := gotHeader.Get("Authorization"); got != "Bearer secrettoken" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+	if got := gotHeader.Get("X-Source"); got != "lnaddr" {
+		t.Errorf("unexpected X-Source header %q", got)
+	}
+
+	var payment jsonPayment
+	if err := json.Unmarshal(gotBody, &payment); err != nil {
+		t.Fatalf("body is no JSON: %v", err)
+	}
+	if payment.AmountSat != 21 || payment.Address != "tips@example.com" ||
+		payment.Comment != "gm" {
+
+		t.Errorf("unexpected body %s", gotBody)
+	}
+
+	sig := gotHeader.Get(SignatureHeader)
+	if err := VerifySignature("whsec", sig, gotBody, time.Minute); err != nil {
+		t.Errorf("signature not valid: %v", err)
+	}
+	if err := VerifySignature("other", sig, gotBody, time.Minute); err == nil {
+		t.Errorf("signature valid for another secret")
+	}
+	err = VerifySignature("whsec", sig, append(gotBody, ' '), time.Minute)
+	if err == nil {
+		t.Errorf("signature valid for another body")
+	}
+
+	old := Sign("whsec", time.Now().Add(-time.Hour), gotBody)
+	if err := VerifySignature("whsec", old, gotBody, time.Minute); err == nil {
+		t.Errorf("outdated signature accepted")
+	}
+}
+
+func TestHttpNotifier_Notify_JSONTemplateFunc(t *testing.T) {
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	n := mustHttpNotifier(t, Config{
+		Type: "http",
+		Params: map[string]string{
+			"Target":       srv.URL,
+			"Method":       http.MethodPost,
+			"Encoding":     string(EncodingJson),
+			"BodyTemplate": `{"text":{{json .Comment}}}`,
+		},
+	})
+
+	err := n.Notify(context.Background(), &Payment{Comment: "a \"b\"\n"})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	if expected := `{"text":"a \"b\"\n"}`; gotBody != expected {
+		t.Errorf("unexpected body. want %q got %q", expected, gotBody)
+	}
+}
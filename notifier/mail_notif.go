@@ -1,62 +1,346 @@
 package notifier
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"strings"
 	"time"
 )
 
+const (
+	// SecurityStartTLS upgrades the connection with STARTTLS and fails if
+	// the server doesn't support it.
+	SecurityStartTLS = "starttls"
+
+	// SecurityTLS connects with implicit TLS, usually on port 465.
+	SecurityTLS = "tls"
+
+	// SecurityNone sends the mail unencrypted, e.g. to a local MTA.
+	SecurityNone = "none"
+)
+
 type MailNotifier struct {
-	Cfg       Config
-	To        string
-	From      string
-	Server    string
-	Login     string
-	Password  string
-	MinAmount uint64
+	Cfg      Config
+	To       string
+	From     string
+	Server   string
+	Login    string
+	Password string
+
+	// Security is SecurityStartTLS, SecurityTLS or SecurityNone.
+	Security string
+
+	from       *mail.Address
+	recipients []*mail.Address
+	host       string
+
+	subject messageTemplate
+	body    messageTemplate
+
+	// html is nil if no HtmlTemplate is configured, the mail is plain
+	// text then.
+	html messageTemplate
+
+	// rootCAs overrides the system roots in tests.
+	rootCAs *x509.CertPool
 }
 
 var _ Notifier = (*MailNotifier)(nil)
 
-func NewMailNotifier(cfg Config) *MailNotifier {
-	return &MailNotifier{
-		Cfg:       cfg,
-		To:        cfg.Params["Target"],
-		From:      cfg.Params["From"],
-		Server:    cfg.Params["SmtpServer"],
-		Login:     cfg.Params["Login"],
-		Password:  cfg.Params["Password"],
-		MinAmount: cfg.MinAmount,
+// NewMailNotifier parses the addresses of the notifier and compiles its
+// subject, body and HTML templates. Target may list several recipients
+// separated by commas.
+func NewMailNotifier(cfg Config) (*MailNotifier, error) {
+	m := &MailNotifier{
+		Cfg:      cfg,
+		To:       cfg.Params["Target"],
+		From:     cfg.Params["From"],
+		Server:   cfg.Params["SmtpServer"],
+		Login:    cfg.Params["Login"],
+		Password: cfg.Params["Password"],
+		Security: strings.ToLower(cfg.Params["Security"]),
 	}
+
+	var (
+		port string
+		err  error
+	)
+	m.host, port, err = net.SplitHostPort(m.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SmtpServer: %w", err)
+	}
+
+	switch m.Security {
+	case "":
+		m.Security = SecurityStartTLS
+		if port == "465" {
+			m.Security = SecurityTLS
+		}
+
+	case SecurityStartTLS, SecurityTLS, SecurityNone:
+
+	default:
+		return nil, fmt.Errorf("invalid Security %q, expected %q, %q "+
+			"or %q", m.Security, SecurityStartTLS, SecurityTLS,
+			SecurityNone)
+	}
+
+	m.from, err = mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid From address: %w", err)
+	}
+	m.recipients, err = mail.ParseAddressList(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Target address: %w", err)
+	}
+
+	m.subject, err = parseTemplate(
+		"subject", paramOr(cfg, "SubjectTemplate", defaultSubjectTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+
+	m.body, err = parseTemplate(
+		"body", paramOr(cfg, "BodyTemplate", defaultBodyTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	if html := cfg.Params["HtmlTemplate"]; html != "" {
+		m.html, err = parseTemplate("html", html, true, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTML template: %w", err)
+		}
+	}
+
+	return m, nil
 }
 
-func (m *MailNotifier) Notify(amount uint64, comment string) (err error) {
-	if amount < m.MinAmount {
-		return fmt.Errorf("amount is too small, required %d got %d",
-			m.MinAmount, amount)
+// paramOr returns the param of the config, or the default if it isn't set.
+func paramOr(cfg Config, key, def string) string {
+	if v := cfg.Params[key]; v != "" {
+		return v
 	}
 
-	var host string
-	host, _, err = net.SplitHostPort(m.Server)
+	return def
+}
+
+func (m *MailNotifier) Notify(ctx context.Context, payment *Payment) error {
+	msg, err := m.message(ctx, payment, time.Now())
 	if err != nil {
-		return
+		return err
 	}
 
-	auth := smtp.PlainAuth("", m.Login, m.Password, host)
-	if comment != "" {
-		comment = fmt.Sprintf("Sender said: \"%s\"", comment)
+	return m.send(ctx, msg)
+}
+
+// send delivers the message to all recipients in one SMTP session.
+func (m *MailNotifier) send(ctx context.Context, msg []byte) error {
+	tlsConfig := &tls.Config{
+		ServerName: m.host,
+		RootCAs:    m.rootCAs,
+		MinVersion: tls.VersionTLS12,
 	}
-	body := fmt.Sprintf("Date: %s\nFrom: %s\nSubject: %s\n\nYou've "+
-		"received %d sats to your lightning address. %s",
-		time.Now().Format(time.RFC1123Z), m.From, "lnaddress payment",
-		amount, comment)
 
-	err = smtp.SendMail(
-		m.Server, auth, m.From, []string{m.To}, []byte(body),
+	var (
+		conn net.Conn
+		err  error
 	)
+	dialer := &net.Dialer{}
+	if m.Security == SecurityTLS {
+		conn, err = (&tls.Dialer{
+			NetDialer: dialer,
+			Config:    tlsConfig,
+		}).DialContext(ctx, "tcp", m.Server)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", m.Server)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %w", m.Server, err)
+	}
+
+	// The SMTP client doesn't take a context, so the connection is closed
+	// once the context is done.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	if m.Security == SecurityStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	// Without login the server is used as unauthenticated relay.
+	if m.Login != "" {
+		auth := smtp.PlainAuth("", m.Login, m.Password, m.host)
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	for _, r := range m.recipients {
+		if err := c.Rcpt(r.Address); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", r.Address,
+				err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// message renders the mail for the payment. Mails with HTML template are sent
+// as multipart/alternative with a plain text and an HTML part. All parts are
+// UTF-8 and quoted-printable encoded.
+func (m *MailNotifier) message(ctx context.Context, payment *Payment,
+	date time.Time) ([]byte, error) {
+
+	subject, err := render(m.subject, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing subject template: %w",
+			err)
+	}
+	text, err := render(m.body, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing body template: %w", err)
+	}
+
+	to := make([]string, 0, len(m.recipients))
+	for _, r := range m.recipients {
+		to = append(to, r.String())
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("From", m.from.String())
+	writeHeader("To", strings.Join(to, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode(
+		"utf-8", strings.TrimSpace(subject),
+	))
+	writeHeader("Message-ID", m.messageID(ctx))
+	writeHeader("MIME-Version", "1.0")
+
+	if m.html == nil {
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(&buf, text); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	html, err := render(m.html, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing HTML template: %w", err)
+	}
+
+	var parts bytes.Buffer
+	w := multipart.NewWriter(&parts)
+	writeHeader("Content-Type", "multipart/alternative; boundary="+
+		w.Boundary())
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	buf.Write(parts.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// messageID returns a Message-ID in the domain of the sender. It is derived
+// from the delivery ID, so receivers can drop the mails of retried outbox
+// deliveries they already got. Mails sent outside the outbox get a random one.
+func (m *MailNotifier) messageID(ctx context.Context) string {
+	var id [16]byte
+	if deliveryID, ok := DeliveryID(ctx); ok {
+		sum := sha256.Sum256([]byte(deliveryID))
+		copy(id[:], sum[:])
+	} else {
+		_, _ = rand.Read(id[:])
+	}
+
+	domain := m.host
+	if _, d, ok := strings.Cut(m.from.Address, "@"); ok {
+		domain = d
+	}
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id[:]), domain)
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
 
-	return
+	return qp.Close()
 }
 
 func (m *MailNotifier) Target() string {
@@ -1,9 +1,17 @@
 package notifier
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMailNotifier_MinAmount(t *testing.T) {
@@ -19,14 +27,18 @@ func TestMailNotifier_MinAmount(t *testing.T) {
 		},
 	}
 
-	n := NewMailNotifier(cfg)
+	// MinAmount is applied by the filter before the notifier is called.
+	f, err := newFilter(cfg, nil)
+	if err != nil {
+		t.Fatalf("newFilter returned error: %v", err)
+	}
 
-	err := n.Notify(50, "hello")
-	if err == nil {
-		t.Fatalf("expected error for amount below MinAmount, got nil")
+	reason := f.skip(&Payment{AmountMsat: 50_000, Comment: "hello"})
+	if !strings.Contains(reason, "below 100 sats") {
+		t.Errorf("unexpected skip reason: %q", reason)
 	}
-	if !strings.Contains(err.Error(), "amount is too small") {
-		t.Errorf("unexpected error: %v", err)
+	if reason := f.skip(&Payment{AmountMsat: 100_000}); reason != "" {
+		t.Errorf("payment at MinAmount skipped: %q", reason)
 	}
 }
 
@@ -42,7 +54,10 @@ func TestMailNotifier_Target(t *testing.T) {
 		},
 	}
 
-	n := NewMailNotifier(cfg)
+	n, err := NewMailNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewMailNotifier returned error: %v", err)
+	}
 
 	target := n.Target()
 	expected := "sender@example.com => recipient@example.com"
@@ -58,3 +73,179 @@ func TestMailNotifier_ImplementsInterface(t *testing.T) {
 		t.Fatal(errors.New("unreachable"))
 	}
 }
+
+func TestMailNotifier_Templates(t *testing.T) {
+	n, err := NewMailNotifier(Config{
+		Type: "mail",
+		Params: map[string]string{
+			"Target":          "to@example.com",
+			"From":            "from@example.com",
+			"SmtpServer":      "localhost:2525",
+			"SubjectTemplate": "⚡ {{.Amount}} sats to {{.Address}}",
+			"BodyTemplate":    "{{.Comment}} ({{.AmountMsat}} msat)",
+			"HtmlTemplate":    "<p>{{.Comment}}</p>",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMailNotifier returned error: %v", err)
+	}
+
+	msg, err := n.message(context.Background(), &Payment{
+		Address:    "tips@example.com",
+		AmountMsat: 21_000,
+		Comment:    "<b>gm</b>",
+	}, time.Unix(1_700_000_000, 0))
+	if err != nil {
+		t.Fatalf("message returned error: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(
+		parsed.Header.Get("Subject"),
+	)
+	if err != nil || subject != "⚡ 21 sats to tips@example.com" {
+		t.Errorf("unexpected subject %q: %v", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(
+		parsed.Header.Get("Content-Type"),
+	)
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q: %v", mediaType, err)
+	}
+
+	var parts []string
+	r := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid part: %v", err)
+		}
+		b, _ := io.ReadAll(p)
+		parts = append(parts, string(b))
+	}
+
+	// Only the HTML part escapes the comment.
+	expected := []string{
+		"<b>gm</b> (21000 msat)", "<p>&lt;b&gt;gm&lt;/b&gt;</p>",
+	}
+	if len(parts) != 2 || parts[0] != expected[0] ||
+		parts[1] != expected[1] {
+
+		t.Errorf("unexpected parts %q", parts)
+	}
+}
+
+func TestMailNotifier_DefaultTemplate(t *testing.T) {
+	n, err := NewMailNotifier(Config{
+		Type: "mail",
+		Params: map[string]string{
+			"Target":     "to@example.com",
+			"From":       "from@example.com",
+			"SmtpServer": "localhost:25",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMailNotifier returned error: %v", err)
+	}
+
+	msg, err := n.message(
+		context.Background(), &Payment{AmountMsat: 21_000, Comment: "gm"},
+		time.Now(),
+	)
+	if err != nil {
+		t.Fatalf("message returned error: %v", err)
+	}
+	if body := mailBody(t, msg); body != "You've received 21 sats to "+
+		"your lightning address. Sender said: \"gm\"" {
+
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestMailNotifier_InvalidTemplate(t *testing.T) {
+	_, err := NewMailNotifier(Config{
+		Type: "mail",
+		Params: map[string]string{
+			"Target":          "to@example.com",
+			"From":            "from@example.com",
+			"SmtpServer":      "localhost:25",
+			"SubjectTemplate": "{{.Nope}}",
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected error for unknown field")
+	}
+}
+
+// mailBody returns the decoded body of a plain text mail.
+func mailBody(t *testing.T, msg []byte) string {
+	t.Helper()
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if enc := parsed.Header.Get("Content-Transfer-Encoding"); enc !=
+		"quoted-printable" {
+
+		t.Fatalf("unexpected transfer encoding %q", enc)
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+
+	return string(body)
+}
+
+func TestMailNotifier_MessageID(t *testing.T) {
+	n, err := NewMailNotifier(Config{
+		Type: "mail",
+		Params: map[string]string{
+			"Target":     "to@example.com",
+			"From":       "from@example.com",
+			"SmtpServer": "localhost:25",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMailNotifier returned error: %v", err)
+	}
+
+	messageID := func(deliveryID string) string {
+		t.Helper()
+
+		ctx := context.Background()
+		if deliveryID != "" {
+			ctx = context.WithValue(ctx, deliveryIDKey{}, deliveryID)
+		}
+		msg, err := n.message(ctx, &Payment{AmountMsat: 1000}, time.Now())
+		if err != nil {
+			t.Fatalf("message returned error: %v", err)
+		}
+		parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatalf("invalid message: %v", err)
+		}
+
+		return parsed.Header.Get("Message-Id")
+	}
+
+	// Retries of a delivery have the same Message-ID.
+	retried := messageID("aa:mail")
+	if !strings.HasSuffix(retried, "@example.com>") ||
+		messageID("aa:mail") != retried {
+
+		t.Fatalf("unexpected Message-ID %q", retried)
+	}
+	if messageID("bb:mail") == retried || messageID("") == messageID("") {
+		t.Fatalf("Message-ID not unique")
+	}
+}
@@ -0,0 +1,196 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// matrixHtmlFormat is the format of HTML message bodies.
+const matrixHtmlFormat = "org.matrix.custom.html"
+
+// MatrixNotifier posts messages to a Matrix room through the client-server
+// API.
+type MatrixNotifier struct {
+	Cfg Config
+
+	// Homeserver is the base URL of the homeserver, e.g.
+	// https://matrix.example.com.
+	Homeserver string
+
+	// RoomId is the ID of the room the messages are posted to, e.g.
+	// !abc:example.com.
+	RoomId string
+
+	AccessToken string
+
+	body messageTemplate
+
+	// html is nil if no HtmlTemplate is configured, the message is plain
+	// text then.
+	html messageTemplate
+
+	client *http.Client
+}
+
+var _ Notifier = (*MatrixNotifier)(nil)
+
+// matrixMessage is the content of an m.room.message event.
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// matrixError is the error response of the client-server API.
+type matrixError struct {
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+// NewMatrixNotifier checks the homeserver URL and compiles the body and HTML
+// templates of the notifier.
+func NewMatrixNotifier(cfg Config) (*MatrixNotifier, error) {
+	m := &MatrixNotifier{
+		Cfg: cfg,
+		Homeserver: strings.TrimSuffix(
+			cfg.Params["Homeserver"], "/",
+		),
+		RoomId:      cfg.Params["RoomId"],
+		AccessToken: cfg.Params["AccessToken"],
+		client:      &http.Client{Timeout: cfg.timeout()},
+	}
+
+	u, err := url.Parse(m.Homeserver)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") ||
+		u.Host == "" {
+
+		return nil, fmt.Errorf("invalid Homeserver %q", m.Homeserver)
+	}
+	if !strings.HasPrefix(m.RoomId, "!") {
+		return nil, fmt.Errorf("invalid RoomId %q, expected a room ID "+
+			"like !abc:example.com", m.RoomId)
+	}
+	if m.AccessToken == "" {
+		return nil, errors.New("AccessToken required")
+	}
+
+	m.body, err = parseTemplate(
+		"body", paramOr(cfg, "BodyTemplate", defaultBodyTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	if html := cfg.Params["HtmlTemplate"]; html != "" {
+		m.html, err = parseTemplate("html", html, true, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTML template: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Notify sends the message as m.room.message event. The transaction ID is
+// derived from the delivery, so the homeserver drops retried deliveries it
+// already accepted.
+func (m *MatrixNotifier) Notify(ctx context.Context, payment *Payment) error {
+	msg, err := m.message(payment)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/"+
+		"m.room.message/%s", m.Homeserver, url.PathEscape(m.RoomId),
+		url.PathEscape(txnID(ctx, payment)))
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPut, endpoint, bytes.NewReader(body),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+m.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e matrixError
+		if json.Unmarshal(respBody, &e) == nil && e.ErrCode != "" {
+			return fmt.Errorf("unexpected status code: %d (%s: %s)",
+				resp.StatusCode, e.ErrCode, e.Error)
+		}
+
+		return fmt.Errorf("unexpected status code: %d (%s)",
+			resp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// message renders the event content for the payment.
+func (m *MatrixNotifier) message(payment *Payment) (*matrixMessage, error) {
+	text, err := render(m.body, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing body template: %w", err)
+	}
+	msg := &matrixMessage{MsgType: "m.text", Body: text}
+
+	if m.html != nil {
+		msg.FormattedBody, err = render(m.html, payment)
+		if err != nil {
+			return nil, fmt.Errorf("error executing HTML template: %w",
+				err)
+		}
+		msg.Format = matrixHtmlFormat
+	}
+
+	return msg, nil
+}
+
+// txnID returns the transaction ID of a notification. Deliveries of the
+// outbox use their ID, other notifications the payment hash or a random ID.
+func txnID(ctx context.Context, payment *Payment) string {
+	if id, ok := DeliveryID(ctx); ok {
+		return id
+	}
+	if payment.PaymentHash != "" {
+		return payment.PaymentHash
+	}
+
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+
+	return hex.EncodeToString(id[:])
+}
+
+func (m *MatrixNotifier) Target() string {
+	return m.RoomId
+}
@@ -0,0 +1,178 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testRoomId      = "!room:example.com"
+	testAccessToken = "syt_secret"
+)
+
+// stubHomeserver is a local stand-in for a Matrix homeserver. Like a real
+// homeserver it stores every transaction only once.
+type stubHomeserver struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	events map[string]*matrixMessage
+	order  []string
+
+	// fail is returned as error response if set.
+	fail *matrixError
+}
+
+func newStubHomeserver(t *testing.T) *stubHomeserver {
+	t.Helper()
+
+	h := &stubHomeserver{events: make(map[string]*matrixMessage)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"PUT /_matrix/client/v3/rooms/{room}/send/{type}/{txn}", h.send,
+	)
+	h.srv = httptest.NewServer(mux)
+	t.Cleanup(h.srv.Close)
+
+	return h
+}
+
+func (h *stubHomeserver) send(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeError := func(status int, e matrixError) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(e)
+	}
+	switch {
+	case r.Header.Get("Authorization") != "Bearer "+testAccessToken:
+		writeError(http.StatusUnauthorized, matrixError{
+			"M_UNKNOWN_TOKEN", "Invalid access token",
+		})
+		return
+
+	case h.fail != nil:
+		writeError(http.StatusForbidden, *h.fail)
+		return
+
+	case r.PathValue("room") != testRoomId ||
+		r.PathValue("type") != "m.room.message":
+
+		writeError(http.StatusNotFound, matrixError{
+			"M_NOT_FOUND", "Unknown room",
+		})
+		return
+	}
+
+	txn := r.PathValue("txn")
+	if _, ok := h.events[txn]; !ok {
+		var msg matrixMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			writeError(http.StatusBadRequest, matrixError{
+				"M_NOT_JSON", err.Error(),
+			})
+			return
+		}
+		h.events[txn] = &msg
+		h.order = append(h.order, txn)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"event_id": fmt.Sprintf("$%s", txn),
+	})
+}
+
+// received returns the stored messages by transaction ID and the
+// transaction IDs in order.
+func (h *stubHomeserver) received() (map[string]*matrixMessage, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.events, append([]string{}, h.order...)
+}
+
+// params returns the params of a matrix notifier using the homeserver.
+func (h *stubHomeserver) params() map[string]string {
+	return map[string]string{
+		"Homeserver":  h.srv.URL,
+		"RoomId":      testRoomId,
+		"AccessToken": testAccessToken,
+	}
+}
+
+func TestMatrixNotifier_Notify(t *testing.T) {
+	hs := newStubHomeserver(t)
+	n := newTestNotifier(t, NewMatrixNotifier, hs.params(), map[string]string{
+		"Homeserver":   hs.srv.URL + "/",
+		"BodyTemplate": "{{.Amount}} sats: {{.Comment}}",
+		"HtmlTemplate": "<b>{{.Amount}} sats</b>: {{.Comment}}",
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		PaymentHash: "aa",
+		AmountMsat:  21_000,
+		Comment:     "<3",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	events, order := hs.received()
+	if len(order) != 1 || order[0] != "aa" {
+		t.Fatalf("unexpected transactions %v", order)
+	}
+	expected := matrixMessage{
+		MsgType:       "m.text",
+		Body:          "21 sats: <3",
+		Format:        matrixHtmlFormat,
+		FormattedBody: "<b>21 sats</b>: &lt;3",
+	}
+	if *events["aa"] != expected {
+		t.Fatalf("unexpected message %+v", events["aa"])
+	}
+}
+
+func TestMatrixNotifier_Idempotent(t *testing.T) {
+	hs := newStubHomeserver(t)
+	n := newTestNotifier(t, NewMatrixNotifier, hs.params())
+
+	// A retried delivery reuses its transaction ID.
+	ctx := context.WithValue(
+		context.Background(), deliveryIDKey{}, "aa:matrix",
+	)
+	payment := &Payment{PaymentHash: "aa", AmountMsat: 1000}
+	for i := 0; i < 2; i++ {
+		if err := n.Notify(ctx, payment); err != nil {
+			t.Fatalf("Notify returned error: %v", err)
+		}
+	}
+
+	events, order := hs.received()
+	if len(order) != 1 || order[0] != "aa:matrix" {
+		t.Fatalf("unexpected transactions %v", order)
+	}
+	msg := events["aa:matrix"]
+	if msg.Format != "" ||
+		!strings.HasPrefix(msg.Body, "You've received 1 sats") {
+
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
+
+func TestMatrixNotifier_Error(t *testing.T) {
+	hs := newStubHomeserver(t)
+	hs.fail = &matrixError{"M_FORBIDDEN", "Not in room"}
+	n := newTestNotifier(t, NewMatrixNotifier, hs.params())
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.Contains(err.Error(), "M_FORBIDDEN") {
+		t.Fatalf("expected M_FORBIDDEN error, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package notifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MqttNotifier publishes payment events to an MQTT broker.
+type MqttNotifier struct {
+	Cfg Config
+
+	// Broker is the URL of the broker, e.g. tcp://localhost:1883 or
+	// ssl://broker.example.com:8883.
+	Broker string
+
+	// QoS is the MQTT quality of service of the published messages.
+	QoS byte
+
+	// Retain asks the broker to keep the last message of every topic
+	// for new subscribers.
+	Retain bool
+
+	topic   messageTemplate
+	payload messageTemplate
+	options *mqtt.ClientOptions
+
+	// mu guards the client, which is connected on first use.
+	mu     sync.Mutex
+	client mqtt.Client
+}
+
+var _ Notifier = (*MqttNotifier)(nil)
+
+// NewMqttNotifier parses the broker URL, QoS, retain flag and TLS files of the
+// notifier and compiles its topic and payload templates. It doesn't connect
+// to the broker yet.
+func NewMqttNotifier(cfg Config) (*MqttNotifier, error) {
+	m := &MqttNotifier{
+		Cfg:    cfg,
+		Broker: cfg.Params["Broker"],
+		QoS:    1,
+	}
+
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("invalid Broker URL")
+	}
+
+	var secure bool
+	switch u.Scheme {
+	case "tcp", "mqtt", "ws":
+
+	case "ssl", "tls", "mqtts", "wss":
+		secure = true
+
+	default:
+		return nil, fmt.Errorf("invalid Broker scheme %q, expected "+
+			"tcp, ssl, ws or wss", u.Scheme)
+	}
+
+	if q := cfg.Params["QoS"]; q != "" {
+		qos, err := strconv.ParseUint(q, 10, 8)
+		if err != nil || qos > 2 {
+			return nil, fmt.Errorf("invalid QoS %q, expected 0, 1 "+
+				"or 2", q)
+		}
+		m.QoS = byte(qos)
+	}
+
+	if r := cfg.Params["Retain"]; r != "" {
+		m.Retain, err = strconv.ParseBool(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Retain %q", r)
+		}
+	}
+
+	if cfg.Params["Topic"] == "" {
+		return nil, errors.New("Topic required")
+	}
+	m.topic, err = parseTemplate("topic", cfg.Params["Topic"], false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic template: %w", err)
+	}
+
+	if payload := cfg.Params["PayloadTemplate"]; payload != "" {
+		m.payload, err = parseTemplate("payload", payload, false, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload template: %w", err)
+		}
+	}
+
+	clientID := cfg.Params["ClientId"]
+	if clientID == "" {
+		var id [8]byte
+		_, _ = rand.Read(id[:])
+		clientID = "lnaddr-" + hex.EncodeToString(id[:])
+	}
+
+	m.options = mqtt.NewClientOptions().
+		AddBroker(m.Broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Params["Username"]).
+		SetPassword(cfg.Params["Password"]).
+		SetConnectTimeout(cfg.timeout()).
+		SetAutoReconnect(true)
+
+	if secure {
+		tlsConfig, err := mqttTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		m.options.SetTLSConfig(tlsConfig)
+	}
+
+	return m, nil
+}
+
+// mqttTLSConfig loads the CA certificate that verifies the broker and the
+// client certificate for mutual TLS, if configured.
+func mqttTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if path := cfg.Params["CACert"]; path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CACert: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+	}
+
+	certFile, keyFile := cfg.Params["ClientCert"], cfg.Params["ClientKey"]
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client "+
+				"certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// render returns the topic and payload of the message for the payment. The
+// payload is the payment as JSON unless a PayloadTemplate is configured.
+func (m *MqttNotifier) render(payment *Payment) (string, []byte, error) {
+	topic, err := render(m.topic, payment)
+	if err != nil {
+		return "", nil, fmt.Errorf("error executing topic template: %w",
+			err)
+	}
+	if topic == "" || strings.ContainsAny(topic, "+#\x00") {
+		return "", nil, fmt.Errorf("invalid topic %q", topic)
+	}
+
+	if m.payload == nil {
+		payload, err := json.Marshal(&jsonPayment{
+			Payment:   payment,
+			AmountSat: payment.AmountSat(),
+		})
+
+		return topic, payload, err
+	}
+
+	payload, err := render(m.payload, payment)
+	if err != nil {
+		return "", nil, fmt.Errorf("error executing payload template: "+
+			"%w", err)
+	}
+
+	return topic, []byte(payload), nil
+}
+
+// Notify publishes the payment to the rendered topic.
+func (m *MqttNotifier) Notify(ctx context.Context, payment *Payment) error {
+	topic, payload, err := m.render(payment)
+	if err != nil {
+		return err
+	}
+
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = mqttWait(ctx, client.Publish(topic, m.QoS, m.Retain, payload))
+	if err != nil {
+		return fmt.Errorf("unable to publish to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+// connect returns the client of the notifier, connecting to the broker on
+// first use. Once connected the client reconnects on its own.
+func (m *MqttNotifier) connect(ctx context.Context) (mqtt.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client != nil {
+		return m.client, nil
+	}
+
+	client := mqtt.NewClient(m.options)
+	if err := mqttWait(ctx, client.Connect()); err != nil {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("unable to connect to %s: %w",
+			m.Target(), err)
+	}
+	m.client = client
+
+	return client, nil
+}
+
+// mqttWait waits for the MQTT operation to complete or the context to end.
+func mqttWait(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Target returns the broker URL without the credentials it may contain.
+func (m *MqttNotifier) Target() string {
+	u, err := url.Parse(m.Broker)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+
+	return u.String()
+}
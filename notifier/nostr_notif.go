@@ -0,0 +1,285 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip17"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	// ProtocolAuto sends NIP-17 messages to recipients that publish DM
+	// relays and NIP-04 messages to everyone else.
+	ProtocolAuto = "auto"
+
+	// ProtocolNIP17 always sends NIP-17 gift-wrapped messages.
+	ProtocolNIP17 = "nip17"
+
+	// ProtocolNIP04 always sends NIP-04 encrypted direct messages.
+	ProtocolNIP04 = "nip04"
+)
+
+// NostrNotifier sends encrypted direct messages to npubs.
+type NostrNotifier struct {
+	Cfg Config
+
+	// Recipients are the hex public keys the messages are sent to.
+	Recipients []string
+
+	// Relays are where the messages are published and where the DM relays
+	// of the recipients are looked up.
+	Relays []string
+
+	// Protocol is ProtocolAuto, ProtocolNIP17 or ProtocolNIP04.
+	Protocol string
+
+	sk   string
+	kr   nostr.Keyer
+	body messageTemplate
+
+	// mu guards sent, the recipients already reached per delivery ID of
+	// deliveries that failed for other recipients. Entries are dropped
+	// once the delivery succeeds or the outbox gives up on it. They are
+	// only kept in memory, so retries after a restart or a replayed dead
+	// letter reach all recipients again.
+	mu   sync.Mutex
+	sent map[string]map[string]bool
+}
+
+var _ Notifier = (*NostrNotifier)(nil)
+
+// NewNostrNotifier parses the keys and relays of the notifier and compiles
+// its body template. Target and Relays may list several npubs and relay URLs
+// separated by commas.
+func NewNostrNotifier(cfg Config) (*NostrNotifier, error) {
+	n := &NostrNotifier{
+		Cfg:      cfg,
+		Relays:   splitList(cfg.Params["Relays"]),
+		Protocol: strings.ToLower(paramOr(cfg, "Protocol", ProtocolAuto)),
+		sent:     make(map[string]map[string]bool),
+	}
+
+	switch n.Protocol {
+	case ProtocolAuto, ProtocolNIP17, ProtocolNIP04:
+
+	default:
+		return nil, fmt.Errorf("invalid Protocol %q, expected %q, %q or "+
+			"%q", n.Protocol, ProtocolAuto, ProtocolNIP17,
+			ProtocolNIP04)
+	}
+
+	if len(n.Relays) == 0 {
+		return nil, errors.New("no relays configured")
+	}
+	for _, r := range n.Relays {
+		if !nostr.IsValidRelayURL(r) {
+			return nil, fmt.Errorf("invalid relay URL %q", r)
+		}
+	}
+
+	for _, npub := range splitList(cfg.Params["Target"]) {
+		pk, err := decodeKey(npub, "npub")
+		if err != nil {
+			return nil, fmt.Errorf("invalid Target %q: %w", npub, err)
+		}
+		n.Recipients = append(n.Recipients, pk)
+	}
+	if len(n.Recipients) == 0 {
+		return nil, errors.New("no Target npub configured")
+	}
+
+	var err error
+	n.sk, err = decodeKey(cfg.Params["Nsec"], "nsec")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Nsec: %w", err)
+	}
+	n.kr, err = keyer.NewPlainKeySigner(n.sk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Nsec: %w", err)
+	}
+
+	n.body, err = parseTemplate(
+		"body", paramOr(cfg, "BodyTemplate", defaultBodyTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	return n, nil
+}
+
+// splitList splits a comma separated list and drops empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// decodeKey returns the hex key of a bech32 encoded key with the prefix or of
+// a hex key.
+func decodeKey(key, prefix string) (string, error) {
+	if strings.HasPrefix(key, prefix+"1") {
+		p, v, err := nip19.Decode(key)
+		if err != nil {
+			return "", err
+		}
+		if p != prefix {
+			return "", fmt.Errorf("expected %s, got %s", prefix, p)
+		}
+
+		return v.(string), nil
+	}
+
+	if !nostr.IsValid32ByteHex(key) {
+		return "", fmt.Errorf("expected %s or hex key", prefix)
+	}
+
+	return key, nil
+}
+
+// Notify sends the message to every recipient. It fails if any recipient
+// couldn't be reached through any relay. Retries of an outbox delivery skip
+// the recipients that were already reached.
+func (n *NostrNotifier) Notify(ctx context.Context, payment *Payment) error {
+	text, err := render(n.body, payment)
+	if err != nil {
+		return fmt.Errorf("error executing body template: %w", err)
+	}
+
+	id, _ := DeliveryID(ctx)
+
+	n.mu.Lock()
+	sent := n.sent[id]
+	delete(n.sent, id)
+	n.mu.Unlock()
+
+	if sent == nil {
+		sent = make(map[string]bool)
+	}
+
+	pool := nostr.NewSimplePool(ctx)
+	defer pool.Close("notification sent")
+
+	var errs []error
+	for _, pk := range n.Recipients {
+		if sent[pk] {
+			log.Debugf("Skipping %s, already sent delivery %s", pk,
+				id)
+			continue
+		}
+
+		if err := n.send(ctx, pool, pk, text); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pk, err))
+			continue
+		}
+		sent[pk] = true
+	}
+
+	// Only failed deliveries are retried, so only they are remembered.
+	if len(errs) > 0 && id != "" {
+		n.mu.Lock()
+		n.sent[id] = sent
+		n.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// abandon forgets the recipients reached by a delivery the outbox gave up on.
+func (n *NostrNotifier) abandon(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.sent, id)
+}
+
+// send sends the message to a single recipient. NIP-17 messages go to the DM
+// relays the recipient published, falling back to NIP-04 in auto mode if
+// there are none.
+func (n *NostrNotifier) send(ctx context.Context, pool *nostr.SimplePool,
+	pk, text string) error {
+
+	var (
+		relays = n.Relays
+		event  nostr.Event
+		err    error
+	)
+	protocol := n.Protocol
+	if protocol != ProtocolNIP04 {
+		dmRelays := nip17.GetDMRelays(ctx, pk, pool, n.Relays)
+		switch {
+		case len(dmRelays) > 0:
+			relays, protocol = dmRelays, ProtocolNIP17
+
+		case protocol == ProtocolAuto:
+			protocol = ProtocolNIP04
+		}
+	}
+
+	if protocol == ProtocolNIP17 {
+		_, event, err = nip17.PrepareMessage(
+			ctx, text, nil, n.kr, pk, nil,
+		)
+	} else {
+		event, err = n.nip04Message(pk, text)
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for res := range pool.PublishMany(ctx, relays, event) {
+		if res.Error == nil {
+			log.Debugf("Sent %s message to %s through %s", protocol,
+				pk, res.RelayURL)
+
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", res.RelayURL,
+			res.Error))
+	}
+
+	return fmt.Errorf("no relay accepted the message: %w",
+		errors.Join(errs...))
+}
+
+// nip04Message returns a signed NIP-04 direct message.
+func (n *NostrNotifier) nip04Message(pk, text string) (nostr.Event, error) {
+	key, err := nip04.ComputeSharedSecret(pk, n.sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	content, err := nip04.Encrypt(text, key)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	event := nostr.Event{
+		Kind:      nostr.KindEncryptedDirectMessage,
+		Content:   content,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", pk}},
+	}
+	if err := event.Sign(n.sk); err != nil {
+		return nostr.Event{}, err
+	}
+
+	return event, nil
+}
+
+func (n *NostrNotifier) Target() string {
+	return n.Cfg.Params["Target"]
+}
@@ -0,0 +1,237 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+// testKey is a Nostr key pair.
+type testKey struct {
+	sk, pk string
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatalf("GetPublicKey: %v", err)
+	}
+
+	return testKey{sk, pk}
+}
+
+func (k testKey) npub() string {
+	npub, _ := nip19.EncodePublicKey(k.pk)
+	return npub
+}
+
+func (k testKey) nsec() string {
+	nsec, _ := nip19.EncodePrivateKey(k.sk)
+	return nsec
+}
+
+func TestNostrNotifier_NIP17(t *testing.T) {
+	relay, inbox := newTestRelay(t), newTestRelay(t)
+	sender, recipient := newTestKey(t), newTestKey(t)
+
+	// The recipient announces their DM inbox on the configured relay.
+	relay.add(signedEvent(t, recipient.sk, nostr.Event{
+		Kind: nostr.KindDMRelayList,
+		Tags: nostr.Tags{{"relay", inbox.url()}},
+	}))
+
+	n := newTestNotifier(t, NewNostrNotifier, map[string]string{
+		"Nsec":         sender.nsec(),
+		"Target":       recipient.npub(),
+		"Relays":       relay.url(),
+		"BodyTemplate": "⚡ {{.Amount}} sats: {{.Comment}}",
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		AmountMsat: 21_000,
+		Comment:    "gm",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	if wraps := relay.received(nostr.KindGiftWrap); len(wraps) != 0 {
+		t.Fatalf("gift wrap sent to the outbox relay")
+	}
+	wraps := inbox.received(nostr.KindGiftWrap)
+	if len(wraps) != 1 {
+		t.Fatalf("expected one gift wrap, got %d", len(wraps))
+	}
+	if p := wraps[0].Tags.GetFirst([]string{"p"}); p == nil ||
+		(*p)[1] != recipient.pk {
+
+		t.Fatalf("gift wrap not addressed to recipient: %v", wraps[0].Tags)
+	}
+
+	kr, err := keyer.NewPlainKeySigner(recipient.sk)
+	if err != nil {
+		t.Fatalf("NewPlainKeySigner: %v", err)
+	}
+	rumor, err := nip59.GiftUnwrap(wraps[0],
+		func(otherPubkey, ciphertext string) (string, error) {
+			return kr.Decrypt(
+				context.Background(), ciphertext, otherPubkey,
+			)
+		},
+	)
+	if err != nil {
+		t.Fatalf("unable to unwrap message: %v", err)
+	}
+	if rumor.Kind != nostr.KindDirectMessage || rumor.PubKey != sender.pk ||
+		rumor.Content != "⚡ 21 sats: gm" {
+
+		t.Fatalf("unexpected message %+v", rumor)
+	}
+}
+
+func TestNostrNotifier_NIP04Fallback(t *testing.T) {
+	relay := newTestRelay(t)
+	sender, recipient := newTestKey(t), newTestKey(t)
+
+	// Without DM relays the recipient gets a NIP-04 message.
+	n := newTestNotifier(t, NewNostrNotifier, map[string]string{
+		"Nsec":   sender.nsec(),
+		"Target": recipient.npub(),
+		"Relays": relay.url(),
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	dms := relay.received(nostr.KindEncryptedDirectMessage)
+	if len(dms) != 1 || dms[0].PubKey != sender.pk {
+		t.Fatalf("expected one NIP-04 message, got %+v", dms)
+	}
+
+	key, err := nip04.ComputeSharedSecret(sender.pk, recipient.sk)
+	if err != nil {
+		t.Fatalf("ComputeSharedSecret: %v", err)
+	}
+	text, err := nip04.Decrypt(dms[0].Content, key)
+	if err != nil {
+		t.Fatalf("unable to decrypt message: %v", err)
+	}
+	if !strings.HasPrefix(text, "You've received 1 sats") {
+		t.Fatalf("unexpected message %q", text)
+	}
+}
+
+func TestNostrNotifier_MultipleRecipients(t *testing.T) {
+	relay := newTestRelay(t)
+	sender := newTestKey(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	// Forcing NIP-04 ignores the DM relays.
+	relay.add(signedEvent(t, alice.sk, nostr.Event{
+		Kind: nostr.KindDMRelayList,
+		Tags: nostr.Tags{{"relay", relay.url()}},
+	}))
+
+	n := newTestNotifier(t, NewNostrNotifier, map[string]string{
+		"Nsec":     sender.nsec(),
+		"Target":   alice.npub() + ", " + bob.pk,
+		"Relays":   relay.url(),
+		"Protocol": ProtocolNIP04,
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	recipients := make(map[string]bool)
+	for _, dm := range relay.received(nostr.KindEncryptedDirectMessage) {
+		recipients[(*dm.Tags.GetFirst([]string{"p"}))[1]] = true
+	}
+	if len(recipients) != 2 || !recipients[alice.pk] || !recipients[bob.pk] {
+		t.Fatalf("unexpected recipients %v", recipients)
+	}
+}
+
+func TestNostrNotifier_RetrySkipsReached(t *testing.T) {
+	relay, inbox := newTestRelay(t), newTestRelay(t)
+	sender := newTestKey(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+
+	// Alice's DM relay is down, bob gets a NIP-04 message.
+	relay.add(signedEvent(t, alice.sk, nostr.Event{
+		Kind: nostr.KindDMRelayList,
+		Tags: nostr.Tags{{"relay", inbox.url()}},
+	}))
+	inbox.srv.Close()
+
+	n := newTestNotifier(t, NewNostrNotifier, map[string]string{
+		"Nsec":   sender.nsec(),
+		"Target": alice.npub() + "," + bob.npub(),
+		"Relays": relay.url(),
+	})
+
+	ctx := context.WithValue(
+		context.Background(), deliveryIDKey{}, "delivery-1",
+	)
+	for i := 0; i < 2; i++ {
+		err := n.Notify(ctx, &Payment{AmountMsat: 1000})
+		if err == nil || !strings.Contains(err.Error(), alice.pk) {
+			t.Fatalf("expected error for alice, got %v", err)
+		}
+	}
+
+	// The retry only tried to reach alice again.
+	dms := relay.received(nostr.KindEncryptedDirectMessage)
+	if len(dms) != 1 {
+		t.Fatalf("expected one message to bob, got %d", len(dms))
+	}
+
+	// Other deliveries reach bob again.
+	ctx = context.WithValue(
+		context.Background(), deliveryIDKey{}, "delivery-2",
+	)
+	_ = n.Notify(ctx, &Payment{AmountMsat: 1000})
+	dms = relay.received(nostr.KindEncryptedDirectMessage)
+	if len(dms) != 2 {
+		t.Fatalf("expected two messages to bob, got %d", len(dms))
+	}
+
+	// Nothing is kept of deliveries the outbox gave up on.
+	n.abandon("delivery-1")
+	n.abandon("delivery-2")
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.sent) != 0 {
+		t.Fatalf("unexpected reached recipients %v", n.sent)
+	}
+}
+
+func TestNostrNotifier_Unreachable(t *testing.T) {
+	relay := newTestRelay(t)
+	url := relay.url()
+	relay.srv.Close()
+
+	n := newTestNotifier(t, NewNostrNotifier, map[string]string{
+		"Nsec":     newTestKey(t).nsec(),
+		"Target":   newTestKey(t).npub(),
+		"Relays":   url,
+		"Protocol": ProtocolNIP04,
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil {
+		t.Fatalf("expected error for unreachable relay")
+	}
+}
@@ -1,58 +1,201 @@
 package notifier
 
-import "github.com/btcsuite/btclog"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
 
 var log btclog.Logger
 
 type Config struct {
-	Type      string
+	// Name identifies the notifier in the outbox. It must be unique and
+	// stay the same across restarts, so that pending deliveries are
+	// resumed with the right notifier. Defaults to the type and the
+	// position in the config, e.g. "mail-0".
+	Name string
+	Type string
+
+	// MinAmount is the smallest amount in sats the notifier is called
+	// for. It is used if the filter sets no MinAmount.
 	MinAmount uint64
-	Params    map[string]string
+
+	// Filter selects the payments the notifier is called for.
+	Filter Filter
+
+	// TimeoutSec bounds every notification, 30 seconds by default.
+	TimeoutSec int
+
+	Params map[string]string
 }
 
+// Notifier delivers a notification about a settled payment. Notify must
+// return once its context is done, the notification counts as failed then.
 type Notifier interface {
-	Notify(amount uint64, comment string) error
+	Notify(ctx context.Context, payment *Payment) error
 	Target() string
 }
 
-var notifiers []Notifier
+// namedNotifier is a configured notifier together with its name.
+type namedNotifier struct {
+	name    string
+	timeout time.Duration
+	filter  *filter
+	Notifier
+}
 
-func SetupNotifiers(notifierConfigs []Config, logger btclog.Logger) {
+var (
+	notifiers []namedNotifier
 
-	log = logger
+	// outbox is set once SetupOutbox was called. Notifications are then
+	// delivered through it instead of directly.
+	outbox *Outbox
+
+	// sendSlots bounds the notifications sent directly at the same time
+	// to DefaultWorkers, like the workers of the outbox.
+	sendSlots = make(chan struct{}, DefaultWorkers)
+)
 
-	for _, c := range notifierConfigs {
-		switch c.Type {
-		case "mail":
-			notifiers = append(
-				notifiers, NewMailNotifier(c),
-			)
+// SetupNotifiers creates the configured notifiers. The addresses of the
+// filters are resolved to the users of the store. An error is returned if a
+// notifier is misconfigured.
+func SetupNotifiers(notifierConfigs []Config, userStore users.Store,
+	logger btclog.Logger) error {
 
-		case "http":
-			notifiers = append(
-				notifiers, NewHttpNotifier(c),
-			)
+	log = logger
 
-		case "telegram":
-			notifiers = append(
-				notifiers, NewTelegramNotifier(c),
-			)
+	names := make(map[string]bool)
+	for i, c := range notifierConfigs {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", c.Type, i)
+		}
+		if names[name] {
+			log.Errorf("Duplicate notifier name %s, skipping", name)
+			continue
+		}
 
-		default:
+		n, err := newNotifier(c)
+		switch {
+		case errors.Is(err, errUnknownType):
 			log.Infof("Unknown notifier type: %s", c.Type)
+			continue
+
+		case err != nil:
+			return fmt.Errorf("invalid notifier %s: %w", name, err)
 		}
+
+		f, err := newFilter(c, userStore)
+		if err != nil {
+			return fmt.Errorf("invalid filter of notifier %s: %w",
+				name, err)
+		}
+
+		names[name] = true
+		notifiers = append(
+			notifiers, namedNotifier{name, c.timeout(), f, n},
+		)
+	}
+
+	return nil
+}
+
+// errUnknownType is returned by newNotifier for unknown notifier types.
+var errUnknownType = errors.New("unknown notifier type")
+
+// newNotifier creates a notifier of the type of the config.
+func newNotifier(c Config) (Notifier, error) {
+	switch c.Type {
+	case "mail":
+		return NewMailNotifier(c)
+
+	case "http":
+		return NewHttpNotifier(c)
+
+	case "telegram":
+		return NewTelegramNotifier(c)
+
+	case "nostr":
+		return NewNostrNotifier(c)
+
+	case "matrix":
+		return NewMatrixNotifier(c)
+
+	case "discord":
+		return NewDiscordNotifier(c)
+
+	case "slack":
+		return NewSlackNotifier(c)
+
+	case "mqtt":
+		return NewMqttNotifier(c)
+
+	case "exec":
+		return NewExecNotifier(c)
+
+	case "ntfy":
+		return NewNtfyNotifier(c)
+
+	case "gotify":
+		return NewGotifyNotifier(c)
+
+	default:
+		return nil, errUnknownType
 	}
 }
 
-func BroadcastNotification(amount uint64, comment string) {
-	log.Infof("Received %d sats with comment: %s", amount, comment)
+// BroadcastNotification notifies all configured notifiers of the payment. If
+// an outbox is set up, the deliveries are queued there and retried on
+// failure. Otherwise the notifiers are called by at most DefaultWorkers
+// goroutines and BroadcastNotification returns once all of them finished or
+// timed out.
+func BroadcastNotification(ctx context.Context, payment *Payment) {
+	log.Infof("Received %d msat to %s with comment: %s",
+		payment.AmountMsat, payment.Address, payment.Comment)
+
+	if outbox != nil {
+		if err := outbox.Enqueue(payment); err != nil {
+			log.Errorf("Unable to queue notifications for %s: %v",
+				payment.PaymentHash, err)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
 	for _, n := range notifiers {
-		err := n.Notify(amount, comment)
-		if err != nil {
-			log.Infof("Error sending notification to %s: %s",
-				n.Target(), err)
-		} else {
-			log.Infof("Notification sent to %s", n.Target())
+		if reason := n.filter.skip(payment); reason != "" {
+			log.Debugf("Not notifying %s: %s", n.Target(), reason)
+			continue
 		}
+
+		select {
+		case sendSlots <- struct{}{}:
+
+		case <-ctx.Done():
+			log.Infof("Not notifying %s: %v", n.Target(), ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sendSlots
+				wg.Done()
+			}()
+
+			err := notifyTimeout(ctx, n.name, n, n.timeout, payment)
+			if err != nil {
+				log.Infof("Error sending notification to %s: %s",
+					n.Target(), err)
+			} else {
+				log.Infof("Notification sent to %s", n.Target())
+			}
+		}()
 	}
+	wg.Wait()
 }
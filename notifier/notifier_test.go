@@ -0,0 +1,216 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestNotifier creates a notifier with the params, later params override
+// earlier ones. The test fails if the notifier can't be created.
+func newTestNotifier[T Notifier](t *testing.T,
+	newNotifier func(Config) (T, error), params ...map[string]string) T {
+
+	t.Helper()
+
+	cfg := Config{Params: make(map[string]string)}
+	for _, p := range params {
+		maps.Copy(cfg.Params, p)
+	}
+
+	n, err := newNotifier(cfg)
+	if err != nil {
+		t.Fatalf("unable to create notifier: %v", err)
+	}
+
+	return n
+}
+
+func TestNewNotifier_Invalid(t *testing.T) {
+	sender, recipient := newTestKey(t), newTestKey(t)
+	nsec, npub := sender.nsec(), recipient.npub()
+	homeserver := "https://matrix.example.com"
+	discordWebhook := "https://discord.com/api/webhooks/1/abc"
+	broker := "tcp://localhost:1883"
+	ntfy := "https://ntfy.example.com"
+	gotify := "https://gotify.example.com"
+
+	for _, test := range []struct {
+		typ    string
+		params map[string]string
+		err    string
+	}{
+		{"pigeon", nil, "unknown notifier type"},
+
+		{"mail", map[string]string{"From": "a@b.c", "Target": "d@e.f",
+			"SmtpServer": "nohost"}, "invalid SmtpServer"},
+		{"mail", map[string]string{"From": "a@b.c", "Target": "d@e.f",
+			"SmtpServer": "h:25", "Security": "ssl"},
+			"invalid Security"},
+		{"mail", map[string]string{"From": "not an address",
+			"Target": "d@e.f", "SmtpServer": "h:25"},
+			"invalid From"},
+		{"mail", map[string]string{"From": "a@b.c", "Target": "",
+			"SmtpServer": "h:25"}, "invalid Target"},
+
+		{"telegram", map[string]string{"Token": "secret"},
+			"invalid ChatId"},
+		{"telegram", map[string]string{"ChatId": "@payments",
+			"Token": "secret"}, "invalid ChatId"},
+		{"telegram", map[string]string{"ChatId": "42"},
+			"Token required"},
+		{"telegram", map[string]string{"ChatId": "42", "Token": "secret",
+			"ParseMode": "Markdown", "BodyTemplate": "{{.Amount}}"},
+			"invalid parse mode"},
+		{"telegram", map[string]string{"ChatId": "42", "Token": "secret",
+			"ParseMode": ModeHTML}, "requires a BodyTemplate"},
+
+		{"nostr", map[string]string{"Nsec": nsec, "Target": npub},
+			"no relays"},
+		{"nostr", map[string]string{"Nsec": nsec,
+			"Relays": "wss://relay.example.com"}, "no Target"},
+		{"nostr", map[string]string{"Nsec": nsec,
+			"Target": "npub1invalid",
+			"Relays": "wss://relay.example.com"}, "invalid Target"},
+		{"nostr", map[string]string{"Nsec": "", "Target": npub,
+			"Relays": "wss://relay.example.com"}, "invalid Nsec"},
+		{"nostr", map[string]string{"Nsec": npub, "Target": npub,
+			"Relays": "wss://relay.example.com"}, "invalid Nsec"},
+		{"nostr", map[string]string{"Nsec": nsec, "Target": npub,
+			"Relays": "https://relay.example.com"},
+			"invalid relay URL"},
+		{"nostr", map[string]string{"Nsec": nsec, "Target": npub,
+			"Relays": "wss://relay.example.com", "Protocol": "nip44"},
+			"invalid Protocol"},
+
+		{"matrix", map[string]string{"Homeserver": "matrix.example.com",
+			"RoomId": testRoomId, "AccessToken": testAccessToken},
+			"invalid Homeserver"},
+		{"matrix", map[string]string{"Homeserver": homeserver,
+			"RoomId": "#ops:example.com", "AccessToken": "x"},
+			"invalid RoomId"},
+		{"matrix", map[string]string{"Homeserver": homeserver,
+			"RoomId": testRoomId}, "AccessToken required"},
+		{"matrix", map[string]string{"Homeserver": homeserver,
+			"RoomId": testRoomId, "AccessToken": "x",
+			"HtmlTemplate": "{{.Unknown}}"},
+			"invalid HTML template"},
+
+		{"discord", nil, "invalid webhook URL"},
+		{"discord", map[string]string{
+			"Target": "discord.com/api/webhooks/1/abc"},
+			"invalid webhook URL"},
+		{"discord", map[string]string{"Target": discordWebhook,
+			"Color": "orange"}, "invalid Color"},
+		{"discord", map[string]string{"Target": discordWebhook,
+			"TitleTemplate": "{{.Unknown}}"},
+			"invalid title template"},
+
+		{"mqtt", map[string]string{"Topic": "payments"},
+			"invalid Broker URL"},
+		{"mqtt", map[string]string{"Broker": "localhost:1883",
+			"Topic": "payments"}, "invalid Broker URL"},
+		{"mqtt", map[string]string{"Broker": "http://localhost:1883",
+			"Topic": "payments"}, "invalid Broker scheme"},
+		{"mqtt", map[string]string{"Broker": broker}, "Topic required"},
+		{"mqtt", map[string]string{"Broker": broker,
+			"Topic": "{{.Unknown}}"}, "invalid topic template"},
+		{"mqtt", map[string]string{"Broker": broker,
+			"Topic": "payments", "QoS": "3"}, "invalid QoS"},
+		{"mqtt", map[string]string{"Broker": broker,
+			"Topic": "payments", "Retain": "maybe"},
+			"invalid Retain"},
+		{"mqtt", map[string]string{"Broker": "ssl://localhost:8883",
+			"Topic": "payments", "CACert": "/does/not/exist.pem"},
+			"unable to read CACert"},
+
+		{"exec", map[string]string{"Command": ""}, "Command required"},
+		{"exec", map[string]string{"Command": "  "},
+			"Command required"},
+		{"exec", map[string]string{"Command": "/does/not/exist"},
+			"invalid Command"},
+
+		{"ntfy", map[string]string{"Topic": "payments"},
+			"invalid Server"},
+		{"ntfy", map[string]string{"Server": ntfy, "Topic": "a/b"},
+			"invalid or missing Topic"},
+		{"ntfy", map[string]string{"Server": "ntfy.example.com",
+			"Topic": "payments"}, "invalid Server"},
+		{"ntfy", map[string]string{"Server": ntfy, "Topic": "payments",
+			"Priority": "6"}, "invalid Priority"},
+		{"ntfy", map[string]string{"Server": ntfy, "Topic": "payments",
+			"Priority": "loud"}, "invalid Priority"},
+		{"ntfy", map[string]string{"Server": ntfy, "Topic": "payments",
+			"ClickTemplate": "{{.Unknown}}"},
+			"invalid click template"},
+
+		{"gotify", map[string]string{"Token": "app-token"},
+			"invalid Server"},
+		{"gotify", map[string]string{"Server": gotify},
+			"Token required"},
+		{"gotify", map[string]string{"Server": gotify, "Token": "x",
+			"Priority": "11"}, "invalid Priority"},
+		{"gotify", map[string]string{"Server": gotify, "Token": "x",
+			"TitleTemplate": "{{.Unknown}}"},
+			"invalid title template"},
+	} {
+		_, err := newNotifier(Config{Type: test.typ, Params: test.params})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected %q error for %s notifier with %v, "+
+				"got %v", test.err, test.typ, test.params, err)
+		}
+	}
+}
+
+// concurrencyNotifier records how many notifications are sent at the same
+// time.
+type concurrencyNotifier struct {
+	mu                      sync.Mutex
+	calls, running, maxSeen int
+}
+
+func (c *concurrencyNotifier) Notify(context.Context, *Payment) error {
+	c.mu.Lock()
+	c.calls++
+	c.running++
+	c.maxSeen = max(c.maxSeen, c.running)
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *concurrencyNotifier) Target() string { return "concurrency" }
+
+func TestBroadcastNotification_Bounded(t *testing.T) {
+	saved := notifiers
+	t.Cleanup(func() { notifiers = saved })
+
+	n := &concurrencyNotifier{}
+	notifiers = nil
+	for i := 0; i < 3*DefaultWorkers; i++ {
+		notifiers = append(notifiers, namedNotifier{
+			fmt.Sprintf("concurrency-%d", i), time.Second,
+			&filter{}, n,
+		})
+	}
+
+	BroadcastNotification(context.Background(), &Payment{AmountMsat: 1000})
+
+	if n.calls != 3*DefaultWorkers {
+		t.Fatalf("expected %d calls, got %d", 3*DefaultWorkers, n.calls)
+	}
+	if n.maxSeen > DefaultWorkers {
+		t.Fatalf("%d notifications sent at once, expected at most %d",
+			n.maxSeen, DefaultWorkers)
+	}
+}
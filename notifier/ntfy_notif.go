@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ntfyPriorities maps the priority names of ntfy to their numbers.
+var ntfyPriorities = map[string]int{
+	"min":     1,
+	"low":     2,
+	"default": 3,
+	"high":    4,
+	"max":     5,
+	"urgent":  5,
+}
+
+// NtfyNotifier publishes push notifications to a topic of an ntfy server.
+type NtfyNotifier struct {
+	Cfg Config
+
+	// Server is the base URL of the ntfy server.
+	Server string
+
+	// Topic is the topic the phones subscribed to.
+	Topic string
+
+	// Token is an access token of the server, if the topic is protected.
+	Token string
+
+	// Priority is 1 (min) to 5 (max), the server's default if 0.
+	Priority int
+
+	// Tags are shown next to the title, tags matching an emoji short code
+	// like "zap" are shown as emoji.
+	Tags []string
+
+	templates *pushTemplates
+	client    *http.Client
+}
+
+var _ Notifier = (*NtfyNotifier)(nil)
+
+// ntfyMessage is the JSON publishing request of ntfy.
+type ntfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Click    string   `json:"click,omitempty"`
+}
+
+// NewNtfyNotifier checks the server URL, topic and priority and compiles the
+// templates of the notifier.
+func NewNtfyNotifier(cfg Config) (*NtfyNotifier, error) {
+	n := &NtfyNotifier{
+		Cfg:    cfg,
+		Server: strings.TrimSuffix(cfg.Params["Server"], "/"),
+		Topic:  cfg.Params["Topic"],
+		Token:  cfg.Params["Token"],
+		Tags:   splitList(cfg.Params["Tags"]),
+		client: &http.Client{Timeout: cfg.timeout()},
+	}
+
+	if err := checkWebhookURL(n.Server); err != nil {
+		return nil, fmt.Errorf("invalid Server: %w", err)
+	}
+	if n.Topic == "" || strings.ContainsAny(n.Topic, "/?#") {
+		return nil, errors.New("invalid or missing Topic")
+	}
+
+	if p := cfg.Params["Priority"]; p != "" {
+		var ok bool
+		n.Priority, ok = ntfyPriorities[strings.ToLower(p)]
+		if !ok {
+			priority, err := strconv.Atoi(p)
+			if err != nil || priority < 1 || priority > 5 {
+				return nil, fmt.Errorf("invalid Priority %q, "+
+					"expected 1 to 5 or min, low, default, "+
+					"high, max", p)
+			}
+			n.Priority = priority
+		}
+	}
+
+	var err error
+	n.templates, err = newPushTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// Notify publishes the notification as JSON to the root of the server, which
+// takes the topic from the message.
+func (n *NtfyNotifier) Notify(ctx context.Context, payment *Payment) error {
+	msg, err := n.templates.render(payment)
+	if err != nil {
+		return err
+	}
+
+	var header http.Header
+	if n.Token != "" {
+		header = http.Header{"Authorization": {"Bearer " + n.Token}}
+	}
+
+	return postJSON(ctx, n.client, n.Server, header, &ntfyMessage{
+		Topic:    n.Topic,
+		Title:    msg.Title,
+		Message:  msg.Body,
+		Priority: n.Priority,
+		Tags:     n.Tags,
+		Click:    msg.Click,
+	})
+}
+
+func (n *NtfyNotifier) Target() string {
+	return n.Server + "/" + n.Topic
+}
@@ -0,0 +1,135 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// pushRequest is a request received by the stub push server.
+type pushRequest struct {
+	Path string
+	Body json.RawMessage
+}
+
+// stubPushServer is a local stand-in for ntfy and Gotify. It rejects requests
+// without the expected authorization header.
+type stubPushServer struct {
+	srv *httptest.Server
+
+	// header and value are the expected authorization header.
+	header, value string
+
+	mu       sync.Mutex
+	requests []pushRequest
+}
+
+func newStubPushServer(t *testing.T, header, value string) *stubPushServer {
+	t.Helper()
+
+	s := &stubPushServer{header: header, value: value}
+	s.srv = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(s.header) != s.value {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			var body json.RawMessage
+			if r.Method != http.MethodPost ||
+				json.NewDecoder(r.Body).Decode(&body) != nil {
+
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.requests = append(s.requests, pushRequest{
+				r.URL.Path, body,
+			})
+			_, _ = w.Write([]byte(`{"id": 1}`))
+		},
+	))
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+func (s *stubPushServer) received() []pushRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]pushRequest{}, s.requests...)
+}
+
+func TestNtfyNotifier_Notify(t *testing.T) {
+	srv := newStubPushServer(t, "Authorization", "Bearer tk_secret")
+	n := newTestNotifier(t, NewNtfyNotifier, map[string]string{
+		"Server":        srv.srv.URL + "/",
+		"Topic":         "payments",
+		"Token":         "tk_secret",
+		"Priority":      "high",
+		"Tags":          "zap, tips",
+		"ClickTemplate": "https://example.com/p/{{.PaymentHash}}",
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		PaymentHash: "aa",
+		AmountMsat:  21_000,
+		Comment:     "thanks",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	requests := srv.received()
+	if len(requests) != 1 || requests[0].Path != "/" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	var msg ntfyMessage
+	if err := json.Unmarshal(requests[0].Body, &msg); err != nil {
+		t.Fatalf("unable to decode message: %v", err)
+	}
+	if msg.Topic != "payments" || msg.Title != "⚡ 21 sats received" ||
+		!strings.Contains(msg.Message, `Sender said: "thanks"`) ||
+		msg.Priority != 4 ||
+		!slices.Equal(msg.Tags, []string{"zap", "tips"}) ||
+		msg.Click != "https://example.com/p/aa" {
+
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
+
+func TestNtfyNotifier_Unauthorized(t *testing.T) {
+	srv := newStubPushServer(t, "Authorization", "Bearer tk_secret")
+	n := newTestNotifier(t, NewNtfyNotifier, map[string]string{
+		"Server": srv.srv.URL,
+		"Topic":  "payments",
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected status 401 error, got %v", err)
+	}
+}
+
+func TestNtfyNotifier_InvalidClick(t *testing.T) {
+	n := newTestNotifier(t, NewNtfyNotifier, map[string]string{
+		"Server":        "https://ntfy.example.com",
+		"Topic":         "payments",
+		"ClickTemplate": "{{.Comment}}",
+	})
+
+	// A relative click URL is rejected before anything is sent.
+	err := n.Notify(context.Background(), &Payment{Comment: "relative"})
+	if err == nil || !strings.Contains(err.Error(), "invalid click URL") {
+		t.Fatalf("expected invalid click URL error, got %v", err)
+	}
+}
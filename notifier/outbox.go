@@ -0,0 +1,665 @@
+package notifier
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// DefaultMaxAttempts is the number of delivery attempts before a
+	// notification is moved to the dead letters.
+	DefaultMaxAttempts = 10
+
+	// DefaultInitialBackoff is the delay before the first retry. It is
+	// doubled for every following retry.
+	DefaultInitialBackoff = 30 * time.Second
+
+	// DefaultMaxBackoff caps the delay between two retries.
+	DefaultMaxBackoff = time.Hour
+
+	// deliveredRetention is how long completed deliveries are remembered
+	// to keep queueing the same payment idempotent.
+	deliveredRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrUnknownDelivery is returned if no delivery with the given ID
+	// exists.
+	ErrUnknownDelivery = errors.New("unknown delivery")
+
+	outboxBucket    = []byte("notify-outbox")
+	deadBucket      = []byte("notify-dead")
+	deliveredBucket = []byte("notify-delivered")
+)
+
+// OutboxConfig configures the retries of failed notifications.
+type OutboxConfig struct {
+	MaxAttempts       int `json:"MaxAttempts" toml:"MaxAttempts"`
+	InitialBackoffSec int `json:"InitialBackoffSec" toml:"InitialBackoffSec"`
+	MaxBackoffSec     int `json:"MaxBackoffSec" toml:"MaxBackoffSec"`
+
+	// Workers is the number of notifications sent concurrently.
+	Workers int `json:"Workers" toml:"Workers"`
+}
+
+// Delivery is the notification of a payment through one notifier.
+type Delivery struct {
+	// ID is the payment hash and notifier name, separated by a colon.
+	ID          string    `json:"id"`
+	Notifier    string    `json:"notifier"`
+	Payment     *Payment  `json:"payment"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// deliveryID returns the ID of the delivery of a payment through a notifier.
+func deliveryID(paymentHash, name string) string {
+	return paymentHash + ":" + name
+}
+
+type deliveryIDKey struct{}
+
+// abandoner is implemented by notifiers that keep state per delivery ID. The
+// outbox calls abandon when it gives up on a delivery.
+type abandoner interface {
+	abandon(id string)
+}
+
+// DeliveryID returns the ID of the delivery a notifier is called for. It can
+// be passed on to receivers that deduplicate requests, as a delivery may be
+// attempted more than once.
+func DeliveryID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(deliveryIDKey{}).(string)
+	return id, ok
+}
+
+// Outbox persists the delivery of every notification until it succeeded.
+// Failed deliveries are retried with exponential backoff and moved to the
+// dead letters once all attempts are used up.
+//
+// Deliveries are keyed by payment hash and notifier name, so queueing a
+// payment twice doesn't notify twice. A delivery that was interrupted by a
+// shutdown is attempted again after the restart.
+//
+// Deliveries are sent by a fixed number of workers, and every attempt is
+// bounded by the timeout of its notifier, so a hanging notifier can't hold up
+// the others.
+type Outbox struct {
+	cfg       OutboxConfig
+	db        *bbolt.DB
+	notifiers map[string]Notifier
+
+	// timeouts overrides DefaultTimeout per notifier name.
+	timeouts map[string]time.Duration
+
+	// filters select the payments queued per notifier name. Notifiers
+	// without filter are queued every payment.
+	filters map[string]*filter
+
+	// inFlight holds the IDs of the deliveries handed to the workers.
+	inFlight   map[string]bool
+	inFlightMu sync.Mutex
+	jobs       chan *Delivery
+
+	wake chan struct{}
+	quit chan struct{}
+	wg   sync.WaitGroup
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// SetupOutbox creates an outbox for the notifiers set up through
+// SetupNotifiers and starts delivering notifications through it.
+func SetupOutbox(db *bbolt.DB, cfg OutboxConfig) (*Outbox, error) {
+	named := make(map[string]Notifier, len(notifiers))
+	timeouts := make(map[string]time.Duration, len(notifiers))
+	filters := make(map[string]*filter, len(notifiers))
+	for _, n := range notifiers {
+		named[n.name] = n.Notifier
+		timeouts[n.name] = n.timeout
+		filters[n.name] = n.filter
+	}
+
+	o, err := NewOutbox(db, cfg, named)
+	if err != nil {
+		return nil, err
+	}
+	o.timeouts = timeouts
+	o.filters = filters
+	o.Start()
+	outbox = o
+
+	return o, nil
+}
+
+// NewOutbox creates the buckets of the outbox. Deliveries for a name that
+// isn't in notifiers are moved to the dead letters.
+func NewOutbox(db *bbolt.DB, cfg OutboxConfig,
+	notifiers map[string]Notifier) (*Outbox, error) {
+
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.InitialBackoffSec <= 0 {
+		cfg.InitialBackoffSec = int(DefaultInitialBackoff.Seconds())
+	}
+	if cfg.MaxBackoffSec <= 0 {
+		cfg.MaxBackoffSec = int(DefaultMaxBackoff.Seconds())
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+
+	o := &Outbox{
+		cfg:       cfg,
+		db:        db,
+		notifiers: notifiers,
+		timeouts:  make(map[string]time.Duration),
+		filters:   make(map[string]*filter),
+		inFlight:  make(map[string]bool),
+		jobs:      make(chan *Delivery),
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		now:       time.Now,
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range [][]byte{
+			outboxBucket, deadBucket, deliveredBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		return o.pruneDelivered(tx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create outbox buckets: %w",
+			err)
+	}
+
+	return o, nil
+}
+
+// pruneDelivered forgets deliveries that completed long ago.
+func (o *Outbox) pruneDelivered(tx *bbolt.Tx) error {
+	cutoff := o.now().Add(-deliveredRetention).Unix()
+
+	var expired [][]byte
+	b := tx.Bucket(deliveredBucket)
+	err := b.ForEach(func(k, v []byte) error {
+		if len(v) == 8 && int64(binary.BigEndian.Uint64(v)) < cutoff {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Enqueue queues the delivery of the payment through every notifier whose
+// filter passes it. Payments that were queued before are skipped.
+func (o *Outbox) Enqueue(payment *Payment) error {
+	if payment.PaymentHash == "" {
+		return errors.New("payment hash required")
+	}
+
+	now := o.now()
+	err := o.db.Update(func(tx *bbolt.Tx) error {
+		for name, n := range o.notifiers {
+			f, ok := o.filters[name]
+			if ok {
+				if reason := f.skip(payment); reason != "" {
+					log.Debugf("Not notifying %s: %s",
+						n.Target(), reason)
+					continue
+				}
+			}
+
+			id := []byte(deliveryID(payment.PaymentHash, name))
+			if tx.Bucket(outboxBucket).Get(id) != nil ||
+				tx.Bucket(deadBucket).Get(id) != nil ||
+				tx.Bucket(deliveredBucket).Get(id) != nil {
+
+				log.Debugf("Skipping already queued delivery %s",
+					id)
+				continue
+			}
+
+			err := putDelivery(tx.Bucket(outboxBucket), &Delivery{
+				ID:          string(id),
+				Notifier:    name,
+				Payment:     payment,
+				NextAttempt: now,
+				CreatedAt:   now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	o.notify()
+
+	return nil
+}
+
+// notify wakes up the delivery loop.
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start starts delivering the queued notifications.
+func (o *Outbox) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-o.quit
+		cancel()
+	}()
+
+	for i := 0; i < o.cfg.Workers; i++ {
+		o.wg.Add(1)
+		go o.work(ctx)
+	}
+
+	o.wg.Add(1)
+	go o.run()
+}
+
+// Stop stops the delivery loop and waits for it to exit. Deliveries that are
+// in flight are cancelled and retried on the next start.
+func (o *Outbox) Stop() {
+	close(o.quit)
+	o.wg.Wait()
+}
+
+// run hands the due deliveries to the workers and sleeps until the next one
+// is due or the outbox changed.
+func (o *Outbox) run() {
+	defer o.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		due, next, err := o.due()
+		if err != nil {
+			log.Errorf("Unable to read notification outbox: %v", err)
+			next = o.now().Add(time.Minute)
+		}
+
+		for _, d := range due {
+			select {
+			case o.jobs <- d:
+			case <-o.quit:
+				return
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(next.Sub(o.now()))
+		}
+
+		select {
+		case <-o.wake:
+		case <-timer.C:
+		case <-o.quit:
+			return
+		}
+	}
+}
+
+// work attempts the deliveries handed over by run.
+func (o *Outbox) work(ctx context.Context) {
+	defer o.wg.Done()
+
+	for {
+		select {
+		case d := <-o.jobs:
+			if _, err := o.attempt(ctx, d); err != nil {
+				log.Errorf("Unable to update delivery %s: %v",
+					d.ID, err)
+			}
+
+			o.inFlightMu.Lock()
+			delete(o.inFlight, d.ID)
+			o.inFlightMu.Unlock()
+
+			// Let run pick up the next attempt of the delivery.
+			o.notify()
+
+		case <-o.quit:
+			return
+		}
+	}
+}
+
+// due returns the deliveries that are due and not in flight yet, and marks
+// them as in flight. It also returns when the next delivery is due, which is
+// zero if there is none.
+func (o *Outbox) due() ([]*Delivery, time.Time, error) {
+	pending, err := o.list(outboxBucket)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	o.inFlightMu.Lock()
+	defer o.inFlightMu.Unlock()
+
+	var (
+		due  []*Delivery
+		next time.Time
+	)
+	for _, d := range pending {
+		switch {
+		case o.inFlight[d.ID]:
+
+		case d.NextAttempt.After(o.now()):
+			if next.IsZero() || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+
+		default:
+			o.inFlight[d.ID] = true
+			due = append(due, d)
+		}
+	}
+
+	return due, next, nil
+}
+
+// attempt delivers the notification once and records the outcome. It returns
+// the time of the next attempt if the delivery is retried.
+func (o *Outbox) attempt(ctx context.Context, d *Delivery) (time.Time,
+	error) {
+
+	n, ok := o.notifiers[d.Notifier]
+	if !ok {
+		d.LastError = "notifier not configured"
+		log.Warnf("Moving delivery %s to dead letters: %s", d.ID,
+			d.LastError)
+		return time.Time{}, o.moveToDead(d)
+	}
+
+	timeout, ok := o.timeouts[d.Notifier]
+	if !ok {
+		timeout = DefaultTimeout
+	}
+	err := notifyTimeout(
+		context.WithValue(ctx, deliveryIDKey{}, d.ID), d.Notifier, n,
+		timeout, d.Payment,
+	)
+	switch {
+	case err == nil:
+		log.Infof("Notification sent to %s", n.Target())
+		return time.Time{}, o.complete(d)
+
+	// Deliveries interrupted by a shutdown are attempted again after the
+	// restart without counting the attempt.
+	case ctx.Err() != nil:
+		return time.Time{}, nil
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= o.cfg.MaxAttempts {
+		log.Errorf("Giving up sending notification to %s after %d "+
+			"attempts: %v", n.Target(), d.Attempts, err)
+		if a, ok := n.(abandoner); ok {
+			a.abandon(d.ID)
+		}
+
+		return time.Time{}, o.moveToDead(d)
+	}
+
+	// Rate limited deliveries wait at least as long as the receiver asked.
+	delay := o.backoff(d.Attempts)
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) {
+		delay = max(delay, rateLimit.RetryAfter)
+	}
+
+	d.NextAttempt = o.now().Add(delay)
+	log.Infof("Error sending notification to %s, retrying at %v: %v",
+		n.Target(), d.NextAttempt, err)
+
+	return d.NextAttempt, o.db.Update(func(tx *bbolt.Tx) error {
+		return putDelivery(tx.Bucket(outboxBucket), d)
+	})
+}
+
+// backoff returns the delay before the retry that follows the given number of
+// failed attempts.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	maxBackoff := time.Duration(o.cfg.MaxBackoffSec) * time.Second
+	backoff := time.Duration(o.cfg.InitialBackoffSec) * time.Second
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxBackoff)
+}
+
+// complete removes a successful delivery from the outbox and remembers it.
+func (o *Outbox) complete(d *Delivery) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(outboxBucket).Delete(
+			[]byte(d.ID),
+		); err != nil {
+			return err
+		}
+
+		var ts [8]byte
+		binary.BigEndian.PutUint64(ts[:], uint64(o.now().Unix()))
+
+		return tx.Bucket(deliveredBucket).Put([]byte(d.ID), ts[:])
+	})
+}
+
+// moveToDead moves a delivery from the outbox to the dead letters.
+func (o *Outbox) moveToDead(d *Delivery) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(outboxBucket).Delete(
+			[]byte(d.ID),
+		); err != nil {
+			return err
+		}
+
+		return putDelivery(tx.Bucket(deadBucket), d)
+	})
+}
+
+// Pending returns the deliveries that are waiting for their next attempt,
+// oldest first.
+func (o *Outbox) Pending() ([]*Delivery, error) {
+	return o.list(outboxBucket)
+}
+
+// DeadLetters returns the deliveries that failed for good, oldest first.
+func (o *Outbox) DeadLetters() ([]*Delivery, error) {
+	return o.list(deadBucket)
+}
+
+// Replay moves a dead letter back to the outbox and resets its attempts.
+func (o *Outbox) Replay(id string) error {
+	err := o.db.Update(func(tx *bbolt.Tx) error {
+		d, err := getDelivery(tx.Bucket(deadBucket), id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(deadBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		d.Attempts = 0
+		d.NextAttempt = o.now()
+
+		return putDelivery(tx.Bucket(outboxBucket), d)
+	})
+	if err != nil {
+		return err
+	}
+
+	o.notify()
+
+	return nil
+}
+
+// DeleteDeadLetter drops a dead letter for good.
+func (o *Outbox) DeleteDeadLetter(id string) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := getDelivery(tx.Bucket(deadBucket), id); err != nil {
+			return err
+		}
+
+		return tx.Bucket(deadBucket).Delete([]byte(id))
+	})
+}
+
+func (o *Outbox) list(bucket []byte) ([]*Delivery, error) {
+	var deliveries []*Delivery
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, v []byte) error {
+			d := &Delivery{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, d)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+
+	return deliveries, nil
+}
+
+func putDelivery(b *bbolt.Bucket, d *Delivery) error {
+	v, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(d.ID), v)
+}
+
+func getDelivery(b *bbolt.Bucket, id string) (*Delivery, error) {
+	v := b.Get([]byte(id))
+	if v == nil {
+		return nil, ErrUnknownDelivery
+	}
+
+	d := &Delivery{}
+	if err := json.Unmarshal(v, d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// outboxResponse is returned when listing the outbox.
+type outboxResponse struct {
+	Pending     []*Delivery `json:"pending"`
+	DeadLetters []*Delivery `json:"deadLetters"`
+}
+
+// HandleAdmin serves the admin API of the outbox, which must be protected by
+// the caller. It expects the patterns /admin/notifications,
+// /admin/notifications/{id} and /admin/notifications/{id}/{action}:
+//
+//	GET    /admin/notifications             lists pending and dead deliveries
+//	POST   /admin/notifications/{id}/replay replays a dead letter
+//	DELETE /admin/notifications/{id}        drops a dead letter
+func (o *Outbox) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, action := r.PathValue("id"), r.PathValue("action")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		pending, err := o.Pending()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dead, err := o.DeadLetters()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(&outboxResponse{
+			Pending:     append([]*Delivery{}, pending...),
+			DeadLetters: append([]*Delivery{}, dead...),
+		})
+
+	case r.Method == http.MethodPost && id != "" && action == "replay":
+		err := o.Replay(id)
+		if errors.Is(err, ErrUnknownDelivery) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Replaying notification %s", id)
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == http.MethodDelete && id != "" && action == "":
+		err := o.DeleteDeadLetter(id)
+		if errors.Is(err, ErrUnknownDelivery) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
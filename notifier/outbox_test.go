@@ -0,0 +1,413 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.etcd.io/bbolt"
+)
+
+func init() {
+	log = btclog.Disabled
+}
+
+// fakeNotifier fails the first failures calls and records the delivery IDs
+// of all calls and of the abandoned deliveries.
+type fakeNotifier struct {
+	mu        sync.Mutex
+	failures  int
+	calls     []string
+	abandoned []string
+	sent      chan struct{}
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, _ *Payment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id, _ := DeliveryID(ctx)
+	f.calls = append(f.calls, id)
+	if len(f.calls) <= f.failures {
+		return errors.New("unavailable")
+	}
+	if f.sent != nil {
+		f.sent <- struct{}{}
+	}
+
+	return nil
+}
+
+func (f *fakeNotifier) Target() string { return "fake" }
+
+func (f *fakeNotifier) abandon(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.abandoned = append(f.abandoned, id)
+}
+
+func (f *fakeNotifier) numCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.calls)
+}
+
+func newTestOutbox(t *testing.T, cfg OutboxConfig,
+	notifiers map[string]Notifier) *Outbox {
+
+	t.Helper()
+
+	db, err := bbolt.Open(
+		filepath.Join(t.TempDir(), "test.db"), 0600, nil,
+	)
+	if err != nil {
+		t.Fatalf("unable to open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	o, err := NewOutbox(db, cfg, notifiers)
+	if err != nil {
+		t.Fatalf("NewOutbox: %v", err)
+	}
+
+	return o
+}
+
+// deliverDue attempts all due deliveries synchronously and returns when the
+// next one is due.
+func deliverDue(t *testing.T, o *Outbox) time.Time {
+	t.Helper()
+
+	due, next, err := o.due()
+	if err != nil {
+		t.Fatalf("due: %v", err)
+	}
+	for _, d := range due {
+		retry, err := o.attempt(context.Background(), d)
+		if err != nil {
+			t.Fatalf("attempt: %v", err)
+		}
+		if !retry.IsZero() && (next.IsZero() || retry.Before(next)) {
+			next = retry
+		}
+		delete(o.inFlight, d.ID)
+	}
+
+	return next
+}
+
+// waitFor polls the condition until it holds or fails the test after five
+// seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	n := &fakeNotifier{sent: make(chan struct{}, 1)}
+	o := newTestOutbox(t, OutboxConfig{}, map[string]Notifier{"fake": n})
+	o.Start()
+	defer o.Stop()
+
+	payment := &Payment{PaymentHash: "aa", AmountMsat: 21000}
+	if err := o.Enqueue(payment); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	select {
+	case <-n.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("notification not sent")
+	}
+
+	waitFor(t, func() bool {
+		pending, err := o.Pending()
+		return err == nil && len(pending) == 0
+	})
+
+	// Queueing the same payment again must not notify twice.
+	if err := o.Enqueue(payment); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if pending, _ := o.Pending(); len(pending) != 0 {
+		t.Fatalf("delivered payment queued again")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.calls) != 1 || n.calls[0] != "aa:fake" {
+		t.Fatalf("unexpected deliveries %v", n.calls)
+	}
+}
+
+func TestOutboxRetriesAndDeadLetters(t *testing.T) {
+	n := &fakeNotifier{failures: 3}
+	o := newTestOutbox(t, OutboxConfig{
+		MaxAttempts:       3,
+		InitialBackoffSec: 10,
+		MaxBackoffSec:     15,
+	}, map[string]Notifier{"fake": n})
+
+	now := time.Unix(1_700_000_000, 0)
+	o.now = func() time.Time { return now }
+
+	err := o.Enqueue(&Payment{PaymentHash: "bb", AmountMsat: 21000})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	for i, backoff := range []time.Duration{
+		10 * time.Second, 15 * time.Second,
+	} {
+		next := deliverDue(t, o)
+		if next != now.Add(backoff) {
+			t.Fatalf("attempt %d: unexpected next attempt %v", i,
+				next)
+		}
+
+		// Nothing is attempted before the backoff passed.
+		deliverDue(t, o)
+		if n.numCalls() != i+1 {
+			t.Fatalf("unexpected calls %d", n.numCalls())
+		}
+		now = next
+	}
+
+	deliverDue(t, o)
+	dead, err := o.DeadLetters()
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("expected one dead letter, got %v %v", dead, err)
+	}
+	if dead[0].Attempts != 3 || dead[0].LastError != "unavailable" {
+		t.Fatalf("unexpected dead letter %+v", dead[0])
+	}
+	n.mu.Lock()
+	if len(n.abandoned) != 1 || n.abandoned[0] != "bb:fake" {
+		t.Fatalf("unexpected abandoned deliveries %v", n.abandoned)
+	}
+	n.mu.Unlock()
+
+	// Dead letters aren't queued again, but can be replayed.
+	err = o.Enqueue(&Payment{PaymentHash: "bb", AmountMsat: 21000})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if pending, _ := o.Pending(); len(pending) != 0 {
+		t.Fatalf("dead letter queued again")
+	}
+
+	if err := o.Replay(dead[0].ID); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	deliverDue(t, o)
+	if n.numCalls() != 4 {
+		t.Fatalf("replay not delivered")
+	}
+	dead, _ = o.DeadLetters()
+	pending, _ := o.Pending()
+	if len(dead) != 0 || len(pending) != 0 {
+		t.Fatalf("unexpected leftovers %v %v", dead, pending)
+	}
+
+	if err := o.Replay("bb:fake"); !errors.Is(err, ErrUnknownDelivery) {
+		t.Fatalf("expected ErrUnknownDelivery, got %v", err)
+	}
+}
+
+func TestOutboxSkipsFiltered(t *testing.T) {
+	n := &fakeNotifier{}
+	o := newTestOutbox(t, OutboxConfig{}, map[string]Notifier{"fake": n})
+
+	f, err := newFilter(Config{MinAmount: 500}, nil)
+	if err != nil {
+		t.Fatalf("newFilter: %v", err)
+	}
+	o.filters["fake"] = f
+
+	err = o.Enqueue(&Payment{PaymentHash: "cc", AmountMsat: 21000})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	pending, _ := o.Pending()
+	if len(pending) != 0 {
+		t.Fatalf("filtered payment was queued")
+	}
+	deliverDue(t, o)
+	if n.numCalls() != 0 {
+		t.Fatalf("notifier called for filtered payment")
+	}
+}
+
+// rateLimitedNotifier is always rate limited.
+type rateLimitedNotifier struct {
+	retryAfter time.Duration
+}
+
+func (r *rateLimitedNotifier) Notify(context.Context, *Payment) error {
+	return &RateLimitError{RetryAfter: r.retryAfter}
+}
+
+func (r *rateLimitedNotifier) Target() string { return "rate limited" }
+
+func TestOutboxRateLimited(t *testing.T) {
+	o := newTestOutbox(t, OutboxConfig{
+		InitialBackoffSec: 10,
+	}, map[string]Notifier{
+		"slow": &rateLimitedNotifier{time.Hour},
+		"fast": &rateLimitedNotifier{time.Second},
+	})
+
+	now := time.Unix(1_700_000_000, 0)
+	o.now = func() time.Time { return now }
+
+	err := o.Enqueue(&Payment{PaymentHash: "dd", AmountMsat: 21000})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	deliverDue(t, o)
+
+	// The retry waits for the rate limit, but never less than the backoff.
+	pending, err := o.Pending()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("expected two pending deliveries, got %v %v", pending,
+			err)
+	}
+	for _, d := range pending {
+		expected := now.Add(10 * time.Second)
+		if d.Notifier == "slow" {
+			expected = now.Add(time.Hour)
+		}
+		if !d.NextAttempt.Equal(expected) {
+			t.Errorf("%s: unexpected next attempt %v", d.Notifier,
+				d.NextAttempt)
+		}
+	}
+}
+
+func TestOutboxAdmin(t *testing.T) {
+	o := newTestOutbox(t, OutboxConfig{MaxAttempts: 1}, map[string]Notifier{
+		"fake": &fakeNotifier{failures: 1},
+	})
+	err := o.Enqueue(&Payment{PaymentHash: "dd", AmountMsat: 21000})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	deliverDue(t, o)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/notifications", o.HandleAdmin)
+	mux.HandleFunc("/admin/notifications/{id}", o.HandleAdmin)
+	mux.HandleFunc("/admin/notifications/{id}/{action}", o.HandleAdmin)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/admin/notifications")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	var resp outboxResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("unable to decode listing: %v", err)
+	}
+	if len(resp.Pending) != 0 || len(resp.DeadLetters) != 1 ||
+		resp.DeadLetters[0].ID != "dd:fake" ||
+		resp.DeadLetters[0].Payment.AmountMsat != 21000 {
+
+		t.Fatalf("unexpected listing %+v", resp)
+	}
+
+	rec = do(http.MethodDelete, "/admin/notifications/unknown")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	if rec := do(
+		http.MethodPost, "/admin/notifications/dd:fake/replay",
+	); rec.Code != http.StatusAccepted {
+
+		t.Fatalf("unexpected replay status %d", rec.Code)
+	}
+	if rec := do(
+		http.MethodDelete, "/admin/notifications/dd:fake",
+	); rec.Code != http.StatusNotFound {
+
+		t.Fatalf("replayed delivery still a dead letter: %d", rec.Code)
+	}
+}
+
+// hangingNotifier blocks until its context is done.
+type hangingNotifier struct{}
+
+func (h *hangingNotifier) Notify(ctx context.Context, _ *Payment) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (h *hangingNotifier) Target() string { return "hanging" }
+
+func TestOutboxTimesOutHangingNotifier(t *testing.T) {
+	slow := &hangingNotifier{}
+
+	fast := &fakeNotifier{sent: make(chan struct{}, 2)}
+	o := newTestOutbox(t, OutboxConfig{Workers: 2}, map[string]Notifier{
+		"slow": slow,
+		"fast": fast,
+	})
+	o.timeouts["slow"] = 50 * time.Millisecond
+	o.Start()
+	defer o.Stop()
+
+	failures := testutil.ToFloat64(notifyFailures.WithLabelValues("slow"))
+	for _, hash := range []string{"ee", "ff"} {
+		err := o.Enqueue(&Payment{PaymentHash: hash, AmountMsat: 1000})
+		if err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	// The hanging notifier must not hold up the other one.
+	for range 2 {
+		select {
+		case <-fast.sent:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notification not sent")
+		}
+	}
+
+	waitFor(t, func() bool {
+		pending, err := o.Pending()
+		return err == nil && len(pending) == 2 &&
+			pending[0].Attempts == 1 && pending[1].Attempts == 1
+	})
+	pending, _ := o.Pending()
+	if !strings.Contains(pending[0].LastError, "timed out") {
+		t.Fatalf("unexpected error %q", pending[0].LastError)
+	}
+
+	if got := testutil.ToFloat64(
+		notifyFailures.WithLabelValues("slow"),
+	); got != failures+2 {
+		t.Fatalf("expected 2 recorded failures, got %v", got-failures)
+	}
+}
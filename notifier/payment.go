@@ -0,0 +1,49 @@
+package notifier
+
+import "time"
+
+// PayerData is the LUD-18 payer data a wallet sent along with the invoice
+// request.
+type PayerData struct {
+	Name       string `json:"name,omitempty"`
+	Pubkey     string `json:"pubkey,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	Email      string `json:"email,omitempty"`
+}
+
+// Payment is a settled payment to one of our lightning addresses.
+type Payment struct {
+	// Address is the lightning address that was paid. It is empty for
+	// invoices requested through the legacy callback without username.
+	Address string `json:"address,omitempty"`
+
+	// AmountMsat is the amount that was paid.
+	AmountMsat uint64 `json:"amountMsat"`
+
+	// Comment is the LUD-12 comment of the payer.
+	Comment string `json:"comment,omitempty"`
+
+	// PayerData is set if the wallet sent LUD-18 payer data.
+	PayerData *PayerData `json:"payerData,omitempty"`
+
+	// ZapSender is the hex encoded nostr pubkey of the zap request's
+	// author and ZapEventID the zapped event, if the payment was a zap.
+	ZapSender  string `json:"zapSender,omitempty"`
+	ZapEventID string `json:"zapEventId,omitempty"`
+
+	// PaymentHash and Preimage are hex encoded.
+	PaymentHash string `json:"paymentHash"`
+	Preimage    string `json:"preimage,omitempty"`
+
+	SettledAt time.Time `json:"settledAt"`
+}
+
+// AmountSat returns the paid amount in full satoshis.
+func (p *Payment) AmountSat() uint64 {
+	return p.AmountMsat / 1000
+}
+
+// IsZap reports whether the payment was a nostr zap.
+func (p *Payment) IsZap() bool {
+	return p.ZapSender != ""
+}
@@ -0,0 +1,92 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// pushTemplates are the templates of push notifiers: the title and body of
+// the notification and the optional URL opened when it's clicked.
+type pushTemplates struct {
+	title messageTemplate
+	body  messageTemplate
+
+	// click is nil without ClickTemplate.
+	click messageTemplate
+}
+
+// pushMessage is a rendered push notification.
+type pushMessage struct {
+	Title string
+	Body  string
+	Click string
+}
+
+// newPushTemplates compiles the TitleTemplate, BodyTemplate and ClickTemplate
+// of the notifier.
+func newPushTemplates(cfg Config) (*pushTemplates, error) {
+	var (
+		t   pushTemplates
+		err error
+	)
+
+	t.title, err = parseTemplate(
+		"title", paramOr(cfg, "TitleTemplate", defaultTitleTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid title template: %w", err)
+	}
+
+	t.body, err = parseTemplate(
+		"body", paramOr(cfg, "BodyTemplate", defaultBodyTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	if click := cfg.Params["ClickTemplate"]; click != "" {
+		t.click, err = parseTemplate("click", click, false, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid click template: %w", err)
+		}
+	}
+
+	return &t, nil
+}
+
+// render renders the notification for the payment. The click URL must be
+// absolute, an empty one means the notification has no click action.
+func (t *pushTemplates) render(payment *Payment) (*pushMessage, error) {
+	var (
+		msg pushMessage
+		err error
+	)
+
+	msg.Title, err = render(t.title, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing title template: %w",
+			err)
+	}
+
+	msg.Body, err = render(t.body, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing body template: %w", err)
+	}
+
+	if t.click != nil {
+		msg.Click, err = render(t.click, payment)
+		if err != nil {
+			return nil, fmt.Errorf("error executing click template: "+
+				"%w", err)
+		}
+
+		u, err := url.Parse(msg.Click)
+		if msg.Click != "" && (err != nil || !u.IsAbs()) {
+			return nil, fmt.Errorf("invalid click URL %q", msg.Click)
+		}
+	}
+
+	return &msg, nil
+}
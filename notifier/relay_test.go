@@ -0,0 +1,139 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testRelay is a minimal in-process Nostr relay that stores every valid event
+// and answers subscriptions from its store.
+type testRelay struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	events []nostr.Event
+}
+
+func newTestRelay(t *testing.T) *testRelay {
+	t.Helper()
+
+	r := &testRelay{}
+	r.srv = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.srv.Close)
+
+	return r
+}
+
+func (r *testRelay) url() string {
+	return "ws" + strings.TrimPrefix(r.srv.URL, "http")
+}
+
+// add stores an event as if it had been published.
+func (r *testRelay) add(ev nostr.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, ev)
+}
+
+// received returns the stored events of the kind.
+func (r *testRelay) received(kind int) []nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []nostr.Event
+	for _, ev := range r.events {
+		if ev.Kind == kind {
+			events = append(events, ev)
+		}
+	}
+
+	return events
+}
+
+func (r *testRelay) serve(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.CloseNow() }()
+
+	ctx := req.Context()
+	write := func(env nostr.Envelope) error {
+		msg, err := env.MarshalJSON()
+		if err != nil {
+			return err
+		}
+
+		return conn.Write(ctx, websocket.MessageText, msg)
+	}
+
+	parser := nostr.NewMessageParser()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		env, err := parser.ParseMessage(string(data))
+		if err != nil {
+			continue
+		}
+
+		switch env := env.(type) {
+		case *nostr.EventEnvelope:
+			ok, _ := env.Event.CheckSignature()
+			reason := ""
+			if ok {
+				r.add(env.Event)
+			} else {
+				reason = "invalid: bad signature"
+			}
+			err = write(&nostr.OKEnvelope{
+				EventID: env.Event.ID, OK: ok, Reason: reason,
+			})
+
+		case *nostr.ReqEnvelope:
+			var matches []nostr.Event
+			r.mu.Lock()
+			for _, ev := range r.events {
+				if env.Filters.Match(&ev) {
+					matches = append(matches, ev)
+				}
+			}
+			r.mu.Unlock()
+
+			for _, ev := range matches {
+				err = write(&nostr.EventEnvelope{
+					SubscriptionID: &env.SubscriptionID,
+					Event:          ev,
+				})
+				if err != nil {
+					return
+				}
+			}
+			eose := nostr.EOSEEnvelope(env.SubscriptionID)
+			err = write(&eose)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// signedEvent returns the event signed with the key.
+func signedEvent(t *testing.T, sk string, ev nostr.Event) nostr.Event {
+	t.Helper()
+
+	ev.CreatedAt = nostr.Now()
+	if err := ev.Sign(sk); err != nil {
+		t.Fatalf("unable to sign event: %v", err)
+	}
+
+	return ev
+}
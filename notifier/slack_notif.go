@@ -0,0 +1,127 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SlackNotifier posts a Block Kit message to a Slack incoming webhook.
+type SlackNotifier struct {
+	Cfg Config
+
+	// URL is the incoming webhook URL.
+	URL string
+
+	title  messageTemplate
+	client *http.Client
+}
+
+var _ Notifier = (*SlackNotifier)(nil)
+
+type slackMessage struct {
+	// Text is shown in notifications and clients without blocks.
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// NewSlackNotifier checks the webhook URL and compiles the title template of
+// the notifier.
+func NewSlackNotifier(cfg Config) (*SlackNotifier, error) {
+	s := &SlackNotifier{
+		Cfg:    cfg,
+		URL:    cfg.Params["Target"],
+		client: &http.Client{Timeout: cfg.timeout()},
+	}
+
+	if err := checkWebhookURL(s.URL); err != nil {
+		return nil, err
+	}
+
+	var err error
+	s.title, err = parseTemplate(
+		"title", paramOr(cfg, "TitleTemplate", defaultTitleTemplate),
+		false, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid title template: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, payment *Payment) error {
+	msg, err := s.message(payment)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, s.client, s.URL, nil, msg)
+}
+
+// message builds the webhook message for the payment: a header with the
+// title, the short details side by side and the long ones in sections of
+// their own.
+func (s *SlackNotifier) message(payment *Payment) (*slackMessage, error) {
+	title, err := render(s.title, payment)
+	if err != nil {
+		return nil, fmt.Errorf("error executing title template: %w",
+			err)
+	}
+
+	var (
+		short    []slackText
+		sections []slackBlock
+	)
+	for _, f := range paymentFields(payment) {
+		text := slackText{"mrkdwn", fmt.Sprintf("*%s*\n%s", f.Name,
+			truncate(escapeSlack(f.Value), 1900))}
+		if f.Short {
+			short = append(short, text)
+			continue
+		}
+		sections = append(sections, slackBlock{
+			Type: "section",
+			Text: &text,
+		})
+	}
+
+	msg := &slackMessage{
+		Text: title,
+		Blocks: []slackBlock{{
+			Type: "header",
+			Text: &slackText{"plain_text", truncate(title, 150)},
+		}, {
+			Type:   "section",
+			Fields: short,
+		}},
+	}
+	msg.Blocks = append(msg.Blocks, sections...)
+
+	return msg, nil
+}
+
+// slackReplacer escapes the control characters of Slack's mrkdwn.
+var slackReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeSlack escapes text for Slack's mrkdwn, so that comments can't
+// mention users or inject links.
+func escapeSlack(s string) string {
+	return slackReplacer.Replace(s)
+}
+
+func (s *SlackNotifier) Target() string {
+	return "Slack webhook"
+}
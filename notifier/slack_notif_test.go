@@ -0,0 +1,101 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSlackNotifier_Notify(t *testing.T) {
+	hook := newStubWebhook(t)
+	n, err := NewSlackNotifier(Config{
+		Type: "slack",
+		Params: map[string]string{
+			"Target":        hook.srv.URL,
+			"TitleTemplate": "{{.Amount}} sats for {{.Address}}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSlackNotifier returned error: %v", err)
+	}
+
+	payment := *zapPayment
+	payment.Comment = "<!channel> & <https://evil.example|click>"
+	if err := n.Notify(context.Background(), &payment); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	bodies := hook.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected one request, got %d", len(bodies))
+	}
+	var msg slackMessage
+	if err := json.Unmarshal(bodies[0], &msg); err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+
+	title := "21 sats for tips@example.com"
+	if msg.Text != title || len(msg.Blocks) != 4 {
+		t.Fatalf("unexpected message %s", bodies[0])
+	}
+	if b := msg.Blocks[0]; b.Type != "header" ||
+		*b.Text != (slackText{"plain_text", title}) {
+
+		t.Errorf("unexpected header %+v", b)
+	}
+
+	fields := msg.Blocks[1].Fields
+	if len(fields) != 3 ||
+		fields[0] != (slackText{"mrkdwn", "*Amount*\n21 sats"}) ||
+		fields[2] != (slackText{"mrkdwn", "*Payer*\nsatoshi"}) {
+
+		t.Errorf("unexpected fields %+v", fields)
+	}
+
+	comment := msg.Blocks[3].Text
+	expected := "*Comment*\n&lt;!channel&gt; &amp; " +
+		"&lt;https://evil.example|click&gt;"
+	if comment == nil || comment.Text != expected {
+		t.Errorf("comment not escaped: %+v", comment)
+	}
+}
+
+func TestSlackNotifier_RateLimit(t *testing.T) {
+	hook := newStubWebhook(t,
+		rateLimited("0", "rate_limited"),
+		rateLimited("120", "rate_limited"),
+	)
+	n, err := NewSlackNotifier(Config{
+		Type:   "slack",
+		Params: map[string]string{"Target": hook.srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("NewSlackNotifier returned error: %v", err)
+	}
+
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	var rateLimit *RateLimitError
+	if !errors.As(err, &rateLimit) ||
+		rateLimit.RetryAfter != 2*time.Minute {
+
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if len(hook.received()) != 2 {
+		t.Fatalf("expected one retry, got %d requests",
+			len(hook.received()))
+	}
+
+	// Other errors aren't retried.
+	hook = newStubWebhook(t, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("no_service"))
+	})
+	n.URL = hook.srv.URL
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || errors.As(err, &rateLimit) {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedMail is a mail accepted by the test SMTP server.
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string
+}
+
+// testSMTPServer is a minimal in-process SMTP server that accepts every
+// mail.
+type testSMTPServer struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+
+	// startTLS offers the STARTTLS extension.
+	startTLS bool
+
+	// implicitTLS serves TLS right away.
+	implicitTLS bool
+
+	mu    sync.Mutex
+	mails []*receivedMail
+}
+
+// testCertificate creates a self-signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, pool
+}
+
+func newTestSMTPServer(t *testing.T, startTLS,
+	implicitTLS bool) (*testSMTPServer, *x509.CertPool) {
+
+	t.Helper()
+
+	cert, pool := testCertificate(t)
+	s := &testSMTPServer{
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+		startTLS:    startTLS,
+		implicitTLS: implicitTLS,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	if implicitTLS {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	s.ln = ln
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, pool
+}
+
+func (s *testSMTPServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *testSMTPServer) received() []*receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*receivedMail{}, s.mails...)
+}
+
+func (s *testSMTPServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	tp := textproto.NewConn(conn)
+	mail := &receivedMail{tls: s.implicitTLS}
+	reply := func(format string, args ...any) {
+		_ = tp.PrintfLine(format, args...)
+	}
+	reply("220 localhost ESMTP test")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			if s.startTLS && !mail.tls {
+				reply("250-STARTTLS")
+			}
+			reply("250-8BITMIME")
+			reply("250 AUTH PLAIN")
+
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			mail.tls = true
+
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			auth, _ := base64.StdEncoding.DecodeString(resp)
+			mail.auth = string(auth)
+			reply("235 authenticated")
+
+		case "MAIL":
+			mail.from = between(arg, "<", ">")
+			reply("250 ok")
+
+		case "RCPT":
+			mail.to = append(mail.to, between(arg, "<", ">"))
+			reply("250 ok")
+
+		case "DATA":
+			reply("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			mail = &receivedMail{tls: mail.tls}
+			reply("250 queued")
+
+		case "QUIT":
+			reply("221 bye")
+			return
+
+		default:
+			reply("500 unknown command")
+		}
+	}
+}
+
+func between(s, start, end string) string {
+	_, s, _ = strings.Cut(s, start)
+	s, _, _ = strings.Cut(s, end)
+
+	return s
+}
+
+func newTestMailNotifier(t *testing.T, server string, pool *x509.CertPool,
+	params map[string]string) *MailNotifier {
+
+	t.Helper()
+
+	cfg := Config{
+		Type: "mail",
+		Params: map[string]string{
+			"From":       "Tips <tips@example.com>",
+			"Target":     "alice@example.com, Bob <bob@example.com>",
+			"SmtpServer": server,
+		},
+	}
+	for k, v := range params {
+		cfg.Params[k] = v
+	}
+
+	n, err := NewMailNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewMailNotifier returned error: %v", err)
+	}
+	n.rootCAs = pool
+
+	return n
+}
+
+func TestMailNotifier_StartTLS(t *testing.T) {
+	server, pool := newTestSMTPServer(t, true, false)
+	n := newTestMailNotifier(t, server.addr(), pool, map[string]string{
+		"Login":    "user",
+		"Password": "pass",
+	})
+
+	err := n.Notify(context.Background(), &Payment{
+		AmountMsat: 21_000,
+		Comment:    "Grüße aus Wien ⚡",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	mails := server.received()
+	if len(mails) != 1 {
+		t.Fatalf("expected one mail, got %d", len(mails))
+	}
+	m := mails[0]
+	if !m.tls || m.auth != "\x00user\x00pass" {
+		t.Errorf("mail not sent over TLS with auth: %+v", m)
+	}
+	if m.from != "tips@example.com" ||
+		strings.Join(m.to, ",") != "alice@example.com,bob@example.com" {
+
+		t.Errorf("unexpected envelope %s -> %v", m.from, m.to)
+	}
+
+	parsed, err := mailHeader(m.data)
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	for key, expected := range map[string]string{
+		"From": `"Tips" <tips@example.com>`,
+		"To": `<alice@example.com>, "Bob" ` +
+			`<bob@example.com>`,
+		"Mime-Version": "1.0",
+	} {
+		if got := parsed.Get(key); got != expected {
+			t.Errorf("unexpected %s header. want %q got %q", key,
+				expected, got)
+		}
+	}
+	if id := parsed.Get("Message-Id"); !strings.HasSuffix(
+		id, "@example.com>",
+	) {
+
+		t.Errorf("unexpected Message-ID %q", id)
+	}
+
+	body := mailBody(t, []byte(m.data))
+	if !strings.Contains(body, "Grüße aus Wien ⚡") {
+		t.Errorf("comment not encoded properly: %q", body)
+	}
+}
+
+func TestMailNotifier_ImplicitTLS(t *testing.T) {
+	server, pool := newTestSMTPServer(t, false, true)
+	n := newTestMailNotifier(t, server.addr(), pool, map[string]string{
+		"Security": SecurityTLS,
+	})
+
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	mails := server.received()
+	if len(mails) != 1 || !mails[0].tls || mails[0].auth != "" {
+		t.Fatalf("unexpected mails %+v", mails)
+	}
+}
+
+func TestMailNotifier_Relay(t *testing.T) {
+	server, pool := newTestSMTPServer(t, false, false)
+
+	// STARTTLS is required unless disabled explicitly.
+	n := newTestMailNotifier(t, server.addr(), pool, nil)
+	err := n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected STARTTLS error, got %v", err)
+	}
+
+	n = newTestMailNotifier(t, server.addr(), pool, map[string]string{
+		"Security": SecurityNone,
+	})
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	mails := server.received()
+	if len(mails) != 1 || mails[0].tls || mails[0].auth != "" {
+		t.Fatalf("unexpected mails %+v", mails)
+	}
+}
+
+// mailHeader parses the header of a raw mail.
+func mailHeader(data string) (textproto.MIMEHeader, error) {
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(data)))
+	return r.ReadMIMEHeader()
+}
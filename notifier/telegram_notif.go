@@ -1,56 +1,230 @@
 package notifier
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+const (
+	// ModeMarkdownV2 is Telegram's MarkdownV2 parse mode.
+	ModeMarkdownV2 = "MarkdownV2"
+
+	// ModeHTML is Telegram's HTML parse mode.
+	ModeHTML = tgbotapi.ModeHTML
+)
+
 type TelegramNotifier struct {
-	Cfg       Config
-	ChatId    int64
-	Token     string
-	MinAmount uint64
+	Cfg    Config
+	ChatId int64
+	Token  string
+
+	// ParseMode is ModeMarkdownV2, ModeHTML or empty for plain text. It
+	// requires a BodyTemplate, the default message is plain text.
+	ParseMode string
+
+	body messageTemplate
+
+	// endpoint is the format of Bot API method URLs, taking the token
+	// and the method.
+	endpoint string
+	client   *http.Client
+
+	// mu guards the mute.
+	mu    sync.Mutex
+	muted time.Time
 }
 
 var _ Notifier = (*TelegramNotifier)(nil)
 
-func NewTelegramNotifier(cfg Config) *TelegramNotifier {
-	chatId, _ := strconv.ParseInt(cfg.Params["ChatId"], 10, 64)
-	return &TelegramNotifier{
+// NewTelegramNotifier compiles the body template of the notifier. Values in
+// HTML templates are escaped automatically. In MarkdownV2 templates the text
+// fields of the payment are escaped, other values can be escaped with the
+// escape function.
+func NewTelegramNotifier(cfg Config) (*TelegramNotifier, error) {
+	chatId, err := strconv.ParseInt(cfg.Params["ChatId"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ChatId %q", cfg.Params["ChatId"])
+	}
+
+	t := &TelegramNotifier{
 		Cfg:       cfg,
 		ChatId:    chatId,
 		Token:     cfg.Params["Token"],
-		MinAmount: cfg.MinAmount,
+		ParseMode: cfg.Params["ParseMode"],
+		endpoint:  tgbotapi.APIEndpoint,
+		client:    &http.Client{Timeout: cfg.timeout()},
+	}
+	if t.Token == "" {
+		return nil, errors.New("Token required")
 	}
+
+	body := cfg.Params["BodyTemplate"]
+	if body == "" {
+		if t.ParseMode != "" {
+			return nil, fmt.Errorf("ParseMode %s requires a "+
+				"BodyTemplate", t.ParseMode)
+		}
+		body = defaultBodyTemplate
+	}
+
+	var (
+		html  bool
+		funcs template.FuncMap
+	)
+	switch t.ParseMode {
+	case "":
+
+	case ModeMarkdownV2:
+		funcs = template.FuncMap{"escape": escapeMarkdownV2}
+
+	case ModeHTML:
+		html = true
+
+	default:
+		return nil, fmt.Errorf("invalid parse mode %q, expected %q or %q",
+			t.ParseMode, ModeMarkdownV2, ModeHTML)
+	}
+
+	t.body, err = parseTemplate("body", body, html, funcs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	return t, nil
+}
+
+// text renders the message for the payment.
+func (t *TelegramNotifier) text(payment *Payment) (string, error) {
+	if t.ParseMode == ModeMarkdownV2 {
+		payment = escapePayment(payment, escapeMarkdownV2)
+	}
+
+	return render(t.body, payment)
 }
 
-func (t *TelegramNotifier) Notify(amount uint64,
-	comment string) (err error) {
+// Notify sends the message through the Bot API's sendMessage method. The
+// request is made directly, as tgbotapi doesn't take a context.
+func (t *TelegramNotifier) Notify(ctx context.Context,
+	payment *Payment) error {
+
+	if t.isMuted() {
+		log.Debugf("Telegram notifications to %d muted", t.ChatId)
+		return nil
+	}
+
+	body, err := t.text(payment)
+	if err != nil {
+		return fmt.Errorf("error executing body template: %w", err)
+	}
 
-	if amount < t.MinAmount {
-		return fmt.Errorf("amount is too small, required %d got %d",
-			t.MinAmount, amount)
+	params := url.Values{
+		"chat_id": {strconv.FormatInt(t.ChatId, 10)},
+		"text":    {body},
 	}
-	if comment != "" {
-		comment = fmt.Sprintf("Sender said: \"%s\"", comment)
+	if t.ParseMode != "" {
+		params.Set("parse_mode", t.ParseMode)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost,
+		fmt.Sprintf(t.endpoint, t.Token, "sendMessage"),
+		strings.NewReader(params.Encode()),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
 	}
-	tgBot, err := tgbotapi.NewBotAPI(t.Token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.client.Do(req)
 	if err != nil {
-		log.Warnf("Couldn't create telegram bot api")
-		return err
+		// The URL holds the bot token, so it's left out of the error.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("error sending request: %w", err)
 	}
-	body := fmt.Sprintf("Subject: lnaddress payment\n\nYou've "+
-		"received %d sats to your lightning address. %s", amount,
-		comment)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
 
-	tgMessage := tgbotapi.NewMessage(t.ChatId, body)
-	_, err = tgBot.Send(tgMessage)
+	var apiResp tgbotapi.APIResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&apiResp)
+	if err != nil {
+		return fmt.Errorf("unexpected response (status %d): %w",
+			resp.StatusCode, err)
+	}
+	if !apiResp.Ok {
+		return fmt.Errorf("telegram error %d: %s", apiResp.ErrorCode,
+			apiResp.Description)
+	}
 
-	return err
+	return nil
+}
+
+// isMuted reports whether notifications are muted.
+func (t *TelegramNotifier) isMuted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return time.Now().Before(t.muted)
+}
+
+// Mute drops the notifications until the given time.
+func (t *TelegramNotifier) Mute(until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.muted = until
+}
+
+// MuteTelegram mutes all telegram notifiers using the bot token until the
+// given time. A zero time unmutes them.
+func MuteTelegram(token string, until time.Time) {
+	for _, n := range notifiers {
+		if t, ok := n.Notifier.(*TelegramNotifier); ok &&
+			t.Token == token {
+
+			t.Mute(until)
+		}
+	}
 }
 
 func (t *TelegramNotifier) Target() string {
 	return fmt.Sprintf("ChatId: %d", t.ChatId)
 }
+
+// escapePayment returns a copy of the payment with all text fields escaped.
+func escapePayment(p *Payment, escape func(string) string) *Payment {
+	escaped := *p
+	escaped.Address = escape(p.Address)
+	escaped.Comment = escape(p.Comment)
+	escaped.ZapSender = escape(p.ZapSender)
+	escaped.ZapEventID = escape(p.ZapEventID)
+	escaped.PaymentHash = escape(p.PaymentHash)
+	escaped.Preimage = escape(p.Preimage)
+
+	if p.PayerData != nil {
+		escaped.PayerData = &PayerData{
+			Name:       escape(p.PayerData.Name),
+			Pubkey:     escape(p.PayerData.Pubkey),
+			Identifier: escape(p.PayerData.Identifier),
+			Email:      escape(p.PayerData.Email),
+		}
+	}
+
+	return &escaped
+}
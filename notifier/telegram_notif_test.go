@@ -1,8 +1,14 @@
 package notifier
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestTelegramNotifier_MinAmount(t *testing.T) {
@@ -15,10 +21,14 @@ func TestTelegramNotifier_MinAmount(t *testing.T) {
 		},
 	}
 
-	n := NewTelegramNotifier(cfg)
+	// MinAmount is applied by the filter before the notifier is called.
+	f, err := newFilter(cfg, nil)
+	if err != nil {
+		t.Fatalf("newFilter returned error: %v", err)
+	}
 
-	if err := n.Notify(100, "hi"); err == nil {
-		t.Fatalf("expected error for amount below MinAmount, got nil")
+	if f.skip(&Payment{AmountMsat: 100_000, Comment: "hi"}) == "" {
+		t.Fatalf("expected payment below MinAmount to be skipped")
 	}
 }
 
@@ -31,7 +41,10 @@ func TestTelegramNotifier_Target(t *testing.T) {
 		},
 	}
 
-	n := NewTelegramNotifier(cfg)
+	n, err := NewTelegramNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewTelegramNotifier returned error: %v", err)
+	}
 
 	target := n.Target()
 	expected := "ChatId: 6789"
@@ -47,3 +60,159 @@ func TestTelegramNotifier_ImplementsInterface(t *testing.T) {
 		t.Fatal(errors.New("unreachable"))
 	}
 }
+
+func TestTelegramNotifier_ParseModes(t *testing.T) {
+	payment := &Payment{
+		AmountMsat: 21_000,
+		Comment:    "1+1=2 <b>!</b>",
+		PayerData:  &PayerData{Name: "satoshi_n"},
+	}
+
+	for _, test := range []struct {
+		mode     string
+		template string
+		expected string
+	}{{
+		mode:     "",
+		template: "{{.Amount}} sats: {{.Comment}}",
+		expected: "21 sats: 1+1=2 <b>!</b>",
+	}, {
+		mode:     ModeMarkdownV2,
+		template: "*{{.Amount}} sats* from {{.PayerData.Name}}: {{.Comment}} {{escape \"(ok)\"}}",
+		expected: "*21 sats* from satoshi\\_n: 1\\+1\\=2 <b\\>\\!</b\\> \\(ok\\)",
+	}, {
+		mode:     ModeHTML,
+		template: "<b>{{.Amount}} sats</b>: {{.Comment}}",
+		expected: "<b>21 sats</b>: 1&#43;1=2 &lt;b&gt;!&lt;/b&gt;",
+	}} {
+		n, err := NewTelegramNotifier(Config{
+			Type: "telegram",
+			Params: map[string]string{
+				"ChatId":       "42",
+				"Token":        "secret",
+				"ParseMode":    test.mode,
+				"BodyTemplate": test.template,
+			},
+		})
+		if err != nil {
+			t.Fatalf("%q: NewTelegramNotifier returned error: %v",
+				test.mode, err)
+		}
+
+		text, err := n.text(payment)
+		if err != nil {
+			t.Fatalf("%q: text returned error: %v", test.mode, err)
+		}
+		if text != test.expected {
+			t.Errorf("%q: want %q got %q", test.mode, test.expected,
+				text)
+		}
+	}
+
+	// The original payment is left untouched.
+	if payment.Comment != "1+1=2 <b>!</b>" {
+		t.Errorf("payment modified: %q", payment.Comment)
+	}
+}
+
+func TestMuteTelegram(t *testing.T) {
+	muted, err := NewTelegramNotifier(Config{
+		Type:   "telegram",
+		Params: map[string]string{"ChatId": "1", "Token": "a"},
+	})
+	if err != nil {
+		t.Fatalf("NewTelegramNotifier returned error: %v", err)
+	}
+	other, err := NewTelegramNotifier(Config{
+		Type:   "telegram",
+		Params: map[string]string{"ChatId": "1", "Token": "b"},
+	})
+	if err != nil {
+		t.Fatalf("NewTelegramNotifier returned error: %v", err)
+	}
+
+	saved := notifiers
+	t.Cleanup(func() { notifiers = saved })
+	notifiers = []namedNotifier{
+		{name: "telegram-0", Notifier: muted},
+		{name: "telegram-1", Notifier: other},
+	}
+
+	MuteTelegram("a", time.Now().Add(time.Hour))
+
+	// A muted notifier drops the notification without contacting the
+	// Bot API.
+	err = muted.Notify(context.Background(), &Payment{AmountMsat: 1000})
+	if err != nil {
+		t.Fatalf("muted Notify returned error: %v", err)
+	}
+	if !other.muted.IsZero() {
+		t.Fatalf("notifier with other token muted")
+	}
+
+	MuteTelegram("a", time.Time{})
+	if !muted.muted.IsZero() {
+		t.Fatalf("notifier not unmuted")
+	}
+}
+
+func TestTelegramNotifier_Notify(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/botsecret/sendMessage" ||
+				r.ParseForm() != nil {
+
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"ok":false,` +
+					`"error_code":404,"description":"Not Found"}`))
+				return
+			}
+			form = r.PostForm
+			_, _ = w.Write([]byte(`{"ok":true,"result":{}}`))
+		},
+	))
+	defer srv.Close()
+
+	n, err := NewTelegramNotifier(Config{
+		Type: "telegram",
+		Params: map[string]string{
+			"ChatId":       "42",
+			"Token":        "secret",
+			"ParseMode":    ModeHTML,
+			"BodyTemplate": "<b>{{.Amount}} sats</b>",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewTelegramNotifier returned error: %v", err)
+	}
+	n.endpoint = srv.URL + "/bot%s/%s"
+
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 21_000})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	if form.Get("chat_id") != "42" || form.Get("text") != "<b>21 sats</b>" ||
+		form.Get("parse_mode") != ModeHTML {
+
+		t.Fatalf("unexpected message %v", form)
+	}
+
+	// Errors of the Bot API are returned, without leaking the token.
+	n.Token = "wrong"
+	err = n.Notify(context.Background(), &Payment{AmountMsat: 21_000})
+	if err == nil || !strings.Contains(err.Error(), "Not Found") {
+		t.Fatalf("expected Not Found error, got %v", err)
+	}
+
+	// An aborted request returns without waiting for the server.
+	n.endpoint = "http://127.0.0.1:1/bot%s/%s"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = n.Notify(ctx, &Payment{AmountMsat: 21_000})
+	if !errors.Is(err, context.Canceled) ||
+		strings.Contains(err.Error(), "wrong") {
+
+		t.Fatalf("expected canceled error without token, got %v", err)
+	}
+}
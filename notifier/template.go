@@ -0,0 +1,112 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+)
+
+const (
+	// defaultSubjectTemplate is the subject of notifications without
+	// SubjectTemplate.
+	defaultSubjectTemplate = "lnaddress payment"
+
+	// defaultBodyTemplate is the text of notifications without
+	// BodyTemplate.
+	defaultBodyTemplate = "You've received {{.Amount}} sats to your " +
+		"lightning address. {{with .Comment}}Sender said: " +
+		"\"{{.}}\"{{end}}"
+)
+
+// messageTemplate is a compiled text/template or html/template.
+type messageTemplate interface {
+	Execute(w io.Writer, data any) error
+}
+
+// messageData is passed to the message templates.
+type messageData struct {
+	*Payment
+
+	// Amount is the paid amount in sats.
+	Amount uint64
+}
+
+// templateFuncs are available in all templates.
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. {"comment": {{json .Comment}}}.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+
+	// escape escapes a value for the format of the message. It is
+	// replaced per notifier.
+	"escape": func(s string) string { return s },
+}
+
+// parseTemplate compiles a message template. HTML templates escape all
+// values automatically. The funcs override the default template functions.
+func parseTemplate(name, text string, html bool,
+	funcs template.FuncMap) (messageTemplate, error) {
+
+	all := make(template.FuncMap, len(templateFuncs)+len(funcs))
+	for k, f := range templateFuncs {
+		all[k] = f
+	}
+	for k, f := range funcs {
+		all[k] = f
+	}
+
+	var (
+		t   messageTemplate
+		err error
+	)
+	if html {
+		t, err = htmltemplate.New(name).Funcs(
+			htmltemplate.FuncMap(all),
+		).Parse(text)
+	} else {
+		t, err = template.New(name).Funcs(all).Parse(text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Templates referring to unknown fields only fail when executed, so
+	// we render them once with an empty payment.
+	if _, err := render(t, &Payment{PayerData: &PayerData{}}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// render executes a message template for the payment.
+func render(t messageTemplate, payment *Payment) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, &messageData{
+		Payment: payment,
+		Amount:  payment.AmountSat(),
+	})
+
+	return buf.String(), err
+}
+
+// markdownV2Replacer escapes the characters that are reserved in Telegram's
+// MarkdownV2.
+var markdownV2Replacer = func() *strings.Replacer {
+	var pairs []string
+	for _, c := range "\\_*[]()~`>#+-=|{}.!" {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+
+	return strings.NewReplacer(pairs...)
+}()
+
+// escapeMarkdownV2 escapes text for Telegram's MarkdownV2 parse mode.
+func escapeMarkdownV2(s string) string {
+	return markdownV2Replacer.Replace(s)
+}
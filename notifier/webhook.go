@@ -0,0 +1,182 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	// maxRateLimitWait is the longest a webhook notifier waits for a rate
+	// limit to pass before it leaves the retry to the outbox.
+	maxRateLimitWait = 10 * time.Second
+
+	// maxRateLimitRetries is how often a rate limited request is retried
+	// within a single notification.
+	maxRateLimitRetries = 3
+
+	// defaultRetryAfter is assumed if a rate limit response doesn't say
+	// when to retry.
+	defaultRetryAfter = 5 * time.Second
+)
+
+// RateLimitError is returned by notifiers that were rate limited by the
+// receiver. The outbox doesn't retry the delivery before RetryAfter passed.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %v", e.RetryAfter)
+}
+
+// retryAfter returns how long a 429 response asks to wait. The Retry-After
+// header holds seconds or a date, Discord additionally sends retry_after in
+// seconds in the body.
+func retryAfter(resp *http.Response, body []byte,
+	now time.Time) time.Duration {
+
+	if h := resp.Header.Get("Retry-After"); h != "" {
+		if sec, err := strconv.ParseFloat(h, 64); err == nil && sec >= 0 {
+			return time.Duration(sec * float64(time.Second))
+		}
+		if t, err := http.ParseTime(h); err == nil {
+			return max(t.Sub(now), 0)
+		}
+	}
+
+	var discord struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if json.Unmarshal(body, &discord) == nil && discord.RetryAfter > 0 {
+		return time.Duration(discord.RetryAfter * float64(time.Second))
+	}
+
+	return defaultRetryAfter
+}
+
+// postJSON posts the payload to a webhook with the additional header, which
+// may be nil. Rate limited requests are retried after the requested delay if
+// it fits into the context's deadline, otherwise a *RateLimitError is
+// returned. Webhook URLs often hold a secret, so they are left out of the
+// errors.
+func postJSON(ctx context.Context, client *http.Client, endpoint string,
+	header http.Header, payload any) error {
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for retries := 0; ; retries++ {
+		req, err := http.NewRequestWithContext(
+			ctx, http.MethodPost, endpoint, bytes.NewReader(body),
+		)
+		if err != nil {
+			return fmt.Errorf("error creating request: %w", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+
+			return fmt.Errorf("error sending request: %w", err)
+		}
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error reading response body: %w", err)
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return nil
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return fmt.Errorf("unexpected status code: %d (%s)",
+				resp.StatusCode, respBody)
+		}
+
+		wait := retryAfter(resp, respBody, time.Now())
+		deadline, ok := ctx.Deadline()
+		if retries >= maxRateLimitRetries || wait > maxRateLimitWait ||
+			ok && time.Now().Add(wait).After(deadline) {
+
+			return &RateLimitError{RetryAfter: wait}
+		}
+
+		log.Debugf("Rate limited by %s, retrying in %v", req.URL.Host,
+			wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+
+	return string(r[:n-1]) + "…"
+}
+
+// paymentField is a labeled detail of a payment shown in rich messages.
+type paymentField struct {
+	Name  string
+	Value string
+
+	// Short fields may be shown side by side.
+	Short bool
+}
+
+// paymentFields returns the details of a payment shown in rich messages.
+// Empty details are left out.
+func paymentFields(p *Payment) []paymentField {
+	fields := []paymentField{
+		{"Amount", fmt.Sprintf("%d sats", p.AmountSat()), true},
+	}
+	if p.Address != "" {
+		fields = append(fields, paymentField{"Recipient", p.Address, true})
+	}
+	if p.PayerData != nil {
+		payer := p.PayerData.Name
+		if payer == "" {
+			payer = p.PayerData.Identifier
+		}
+		if payer != "" {
+			fields = append(fields, paymentField{"Payer", payer, true})
+		}
+	}
+	if p.IsZap() {
+		sender, err := nip19.EncodePublicKey(p.ZapSender)
+		if err != nil {
+			sender = p.ZapSender
+		}
+		fields = append(fields, paymentField{"Zap from", sender, false})
+	}
+	if p.Comment != "" {
+		fields = append(fields, paymentField{"Comment", p.Comment, false})
+	}
+
+	return fields
+}
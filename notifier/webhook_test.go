@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		header   string
+		body     string
+		expected time.Duration
+	}{
+		{"30", "", 30 * time.Second},
+		{"0.5", "", 500 * time.Millisecond},
+		{"Fri, 01 Mar 2024 10:01:00 GMT", "", time.Minute},
+		{"Fri, 01 Mar 2024 09:00:00 GMT", "", 0},
+		{"", `{"retry_after": 1.5, "global": false}`,
+			1500 * time.Millisecond},
+		{"", "rate_limited", defaultRetryAfter},
+	} {
+		resp := &http.Response{Header: make(http.Header)}
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+
+		got := retryAfter(resp, []byte(test.body), now)
+		if got != test.expected {
+			t.Errorf("%q %q: want %v got %v", test.header, test.body,
+				test.expected, got)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("⚡⚡⚡", 3); got != "⚡⚡⚡" {
+		t.Errorf("unexpected truncation %q", got)
+	}
+	if got := truncate("⚡⚡⚡⚡", 3); got != "⚡⚡…" {
+		t.Errorf("unexpected truncation %q", got)
+	}
+}
+
+func TestPostJSON_HidesURL(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	endpoint := srv.URL + "/hooks/s3cr3t"
+	err := postJSON(
+		context.Background(), srv.Client(), endpoint, nil, struct{}{},
+	)
+	if err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Fatalf("expected error without the URL, got %v", err)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hieblmi/go-host-lnaddr/lnurl"
+	"github.com/hieblmi/go-host-lnaddr/qr"
+	"github.com/hieblmi/go-host-lnaddr/users"
+	"github.com/hieblmi/go-host-lnaddr/widget"
+)
+
+// lnurlpURL returns the LNURLp endpoint of the user.
+func lnurlpURL(config ServerConfig, name string) string {
+	return fmt.Sprintf(
+		"%s/.well-known/lnurlp/%s", config.ExternalURL, name,
+	)
+}
+
+// lud17URL returns the LUD-17 form of an LNURLp endpoint, which wallets can
+// open without decoding bech32.
+func lud17URL(endpoint string) string {
+	if rest, ok := strings.CutPrefix(endpoint, "https://"); ok {
+		return "lnurlp://" + rest
+	}
+
+	return strings.Replace(endpoint, "http://", "lnurlp://", 1)
+}
+
+// qrDataURI renders the content as PNG QR code data URI.
+func qrDataURI(content string) (template.URL, error) {
+	opts := qr.DefaultOptions()
+	opts.Level = "H"
+	png, err := qr.Render(content, opts)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(png)
+
+	return template.URL("data:image/png;base64," + encoded), nil
+}
+
+// setupIndexHandler lists all users with their QR code and a link to their
+// page. The listing is only served if ListAllURLs is set, so addresses can't
+// be enumerated otherwise.
+func setupIndexHandler(config ServerConfig, store users.Store) {
+	if !config.ListAllURLs || config.ExternalURL == "" {
+		return
+	}
+
+	list, err := store.List()
+	if err != nil {
+		log.Errorf("Unable to list users: %v", err)
+		return
+	}
+	if len(list) == 0 {
+		return
+	}
+
+	type user struct {
+		User    string
+		Address string
+		Encoded string
+		QRCode  template.URL
+	}
+
+	var entries []user
+	for _, u := range list {
+		encoded, err := lnurl.Encode(lnurlpURL(config, u.Name))
+		if err != nil {
+			log.Errorf("Unable to encode url: %v", err)
+			continue
+		}
+
+		qr, err := qrDataURI(encoded)
+		if err != nil {
+			log.Errorf("Unable to encode QR code: %v", err)
+			continue
+		}
+
+		entries = append(entries, user{
+			User:    u.Name,
+			Address: u.Address,
+			Encoded: encoded,
+			QRCode:  qr,
+		})
+
+	}
+	htmlTemplate := `<!DOCTYPE html>
+<html>
+<head>
+	<title>LNURLs</title>
+</head>
+<body>
+	<h1>LNURLs</h1>
+	<ul>
+		{{range .}}
+		<li>
+			<h2>User: <a href="/u/{{.User}}">{{.Address}}</a></h2>
+			<img src="{{.QRCode}}" style="margin-left:-18px"/><br/>
+			<pre>{{.Encoded}}</pre>
+		</li>
+		{{end}}
+	</ul>
+</body>
+</html>
+`
+
+	bodyTemlate, err := template.New("html").Parse(htmlTemplate)
+	if err != nil {
+		log.Errorf("Error building URL template: %v", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = bodyTemlate.Execute(&buf, entries)
+	if err != nil {
+		log.Errorf("Error executing URL template: %v", err)
+		return
+	}
+
+	http.HandleFunc(
+		"/", useLogger(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(buf.Bytes())
+		}),
+	)
+}
+
+// userPage is the data of a user's landing page.
+type userPage struct {
+	Address        string
+	Encoded        string
+	QRCode         template.URL
+	LightningLink  template.URL
+	LUD17Link      template.URL
+	Description    string
+	LongDesc       string
+	Thumbnail      template.URL
+	MinSendableSat int
+	MaxSendableSat int
+	CommentAllowed int
+}
+
+var userPageTemplate = template.Must(template.New("user").Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Address}}</title>
+	<style>
+		body { font-family: sans-serif; text-align: center; margin: 2em; }
+		pre { white-space: pre-wrap; word-break: break-all; }
+		img.thumbnail { max-width: 96px; max-height: 96px; }
+	</style>
+</head>
+<body>
+	{{if .Thumbnail}}<img class="thumbnail" src="{{.Thumbnail}}" alt=""/>{{end}}
+	<h1>{{.Address}}</h1>
+	{{if .Description}}<p>{{.Description}}</p>{{end}}
+	{{if .LongDesc}}<p>{{.LongDesc}}</p>{{end}}
+	<a href="{{.LightningLink}}"><img src="{{.QRCode}}" alt="LNURL QR code"/></a>
+	<p>
+		<a href="{{.LightningLink}}">Open in wallet</a> |
+		<a href="{{.LUD17Link}}">lnurlp link</a>
+	</p>
+	<p>
+		Send between {{.MinSendableSat}} and {{.MaxSendableSat}} sats.
+		{{if .CommentAllowed}}Comments up to {{.CommentAllowed}}
+		characters are delivered.{{end}}
+	</p>
+	<pre>{{.Encoded}}</pre>
+</body>
+</html>
+`))
+
+// setupUserPages serves a landing page for every user at /u/{user}. The pages
+// are served independently of ListAllURLs, as visitors have to know the
+// username to find one.
+func setupUserPages(config ServerConfig, store users.Store) {
+	if config.ExternalURL == "" {
+		return
+	}
+
+	http.HandleFunc(
+		"GET /u/{user}", useLogger(handleUserPage(config, store)),
+	)
+}
+
+// handleUserPage renders the landing page of a user with the QR code and links
+// wallets can open, along with the address metadata.
+func handleUserPage(config ServerConfig, store users.Store) http.HandlerFunc {
+	var description, longDesc string
+	for _, m := range config.Metadata {
+		if len(m) < 2 {
+			continue
+		}
+		switch m[0] {
+		case "text/plain":
+			description = m[1]
+
+		case "text/long-desc":
+			longDesc = m[1]
+		}
+	}
+
+	var thumbnail template.URL
+	if config.Thumbnail != "" {
+		m, err := thumbnailToMetadata(config.Thumbnail)
+		if err != nil {
+			log.Warnf("Unable to load thumbnail: %v", err)
+		} else {
+			thumbnail = template.URL("data:" + m[0] + "," + m[1])
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := store.Lookup(r.PathValue("user"))
+		switch {
+		case errors.Is(err, users.ErrUnknownUser),
+			errors.Is(err, users.ErrInvalidName):
+
+			http.NotFound(w, r)
+			return
+
+		case err != nil:
+			log.Errorf("Unable to look up user %s: %v",
+				r.PathValue("user"), err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+
+		endpoint := lnurlpURL(config, user.Name)
+		encoded, err := lnurl.Encode(endpoint)
+		if err != nil {
+			log.Errorf("Unable to encode url: %v", err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+		qr, err := qrDataURI(encoded)
+		if err != nil {
+			log.Errorf("Unable to encode QR code: %v", err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+
+		page := userPage{
+			Address:        user.Address,
+			Encoded:        encoded,
+			QRCode:         qr,
+			LightningLink:  template.URL("lightning:" + encoded),
+			LUD17Link:      template.URL(lud17URL(endpoint)),
+			Description:    description,
+			LongDesc:       longDesc,
+			Thumbnail:      thumbnail,
+			MinSendableSat: config.MinSendableMsat / 1000,
+			MaxSendableSat: config.MaxSendableMsat / 1000,
+			CommentAllowed: config.MaxCommentLength,
+		}
+
+		var buf bytes.Buffer
+		if err := userPageTemplate.Execute(&buf, page); err != nil {
+			log.Errorf("Unable to render user page: %v", err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	}
+}
+
+const (
+	// payloadLNURL encodes the bech32 LNURL of the user.
+	payloadLNURL = "lnurl"
+
+	// payloadAddress encodes the lightning address itself.
+	payloadAddress = "address"
+
+	// payloadBIP21 encodes a BIP21 unified URI with the LNURL as lightning
+	// parameter.
+	payloadBIP21 = "bip21"
+
+	// qrCacheSize is the number of rendered QR codes kept in memory.
+	qrCacheSize = 256
+)
+
+// setupQRHandler serves QR code images of the users at /qr/{user}.
+func setupQRHandler(config ServerConfig, store users.Store) {
+	if config.ExternalURL == "" {
+		return
+	}
+
+	// QR requests aren't logged, the logger would write the images to
+	// the log.
+	http.HandleFunc("GET /qr/{user}", handleQR(
+		config, store, qr.NewCache(qrCacheSize),
+	))
+}
+
+// qrPayload returns the content of the user's QR code for the payload type.
+func qrPayload(config ServerConfig, user *users.User,
+	payload string) (string, error) {
+
+	switch payload {
+	case payloadAddress:
+		return user.Address, nil
+
+	case payloadLNURL, payloadBIP21:
+		encoded, err := lnurl.Encode(lnurlpURL(config, user.Name))
+		if err != nil {
+			return "", err
+		}
+		if payload == payloadBIP21 {
+			return "bitcoin:?lightning=" + encoded, nil
+		}
+
+		return encoded, nil
+
+	default:
+		return "", fmt.Errorf("%w: unknown payload %q",
+			qr.ErrInvalidOptions, payload)
+	}
+}
+
+// parseQROptions reads the QR code options from the query, falling back to
+// the defaults for parameters that aren't given.
+func parseQROptions(query url.Values) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	if format := query.Get("format"); format != "" {
+		opts.Format = strings.ToLower(format)
+	}
+	if level := query.Get("level"); level != "" {
+		opts.Level = strings.ToUpper(level)
+	}
+
+	for name, value := range map[string]*int{
+		"size":   &opts.Size,
+		"margin": &opts.Margin,
+	} {
+		param := query.Get(name)
+		if param == "" {
+			continue
+		}
+
+		var err error
+		*value, err = strconv.Atoi(param)
+		if err != nil {
+			return opts, fmt.Errorf("%w: %s must be a number",
+				qr.ErrInvalidOptions, name)
+		}
+	}
+
+	return opts, opts.Validate()
+}
+
+// handleQR renders the QR code of a user as PNG or SVG image. The images only
+// depend on the request, so they are cached in memory and by clients.
+func handleQR(config ServerConfig, store users.Store,
+	cache *qr.Cache) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := store.Lookup(r.PathValue("user"))
+		switch {
+		case errors.Is(err, users.ErrUnknownUser),
+			errors.Is(err, users.ErrInvalidName):
+
+			http.NotFound(w, r)
+			return
+
+		case err != nil:
+			log.Errorf("Unable to look up user %s: %v",
+				r.PathValue("user"), err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+
+		opts, err := parseQROptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		payload := r.URL.Query().Get("payload")
+		if payload == "" {
+			payload = payloadLNURL
+		}
+		content, err := qrPayload(config, user, payload)
+		if errors.Is(err, qr.ErrInvalidOptions) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Errorf("Unable to encode QR payload: %v", err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+
+		img, err := cache.Render(content, opts)
+		if err != nil {
+			log.Errorf("Unable to render QR code: %v", err)
+			http.Error(w, "internal error",
+				http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256(img)
+		w.Header().Set("Content-Type", opts.ContentType())
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img))
+	}
+}
+
+// setupWidgetHandlers serves the embeddable tip-jar widget and an iframe-able
+// tip page per user.
+func setupWidgetHandlers(store users.Store) {
+	h := widget.NewHandler(store)
+
+	http.HandleFunc("GET /widget.js", h.HandleScript)
+	http.HandleFunc("GET /tip/qr", h.HandleInvoiceQR)
+	http.HandleFunc("GET /tip/{user}", useLogger(h.HandlePage))
+}
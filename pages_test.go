@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/qr"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+func TestUserPage(t *testing.T) {
+	log = btclog.Disabled
+
+	store, err := users.NewConfigStore([]string{"tips@example.com"})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	config := ServerConfig{
+		ExternalURL:      "https://example.com",
+		MinSendableMsat:  1000,
+		MaxSendableMsat:  100_000_000,
+		MaxCommentLength: 140,
+		Metadata: [][]string{
+			{"text/plain", "Tips <3"},
+			{"text/identifier", "tips@example.com"},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /u/{user}", handleUserPage(config, store))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/u/Tips")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	for _, want := range []string{
+		"<h1>tips@example.com</h1>",
+		"Tips &lt;3",
+		`src="data:image/png;base64,`,
+		`href="lightning:LNURL1`,
+		`href="lnurlp://example.com/.well-known/lnurlp/tips"`,
+		"between 1 and 100000 sats",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("expected %q in page:\n%s", want, body)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/u/unknown")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestLUD17URL(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/.well-known/lnurlp/a": "lnurlp://" +
+			"example.com/.well-known/lnurlp/a",
+		"http://abc.onion/.well-known/lnurlp/a": "lnurlp://" +
+			"abc.onion/.well-known/lnurlp/a",
+	}
+	for in, want := range tests {
+		if got := lud17URL(in); got != want {
+			t.Fatalf("lud17URL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestQRHandler(t *testing.T) {
+	log = btclog.Disabled
+
+	store, err := users.NewConfigStore([]string{"tips@example.com"})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+	config := ServerConfig{ExternalURL: "https://example.com"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /qr/{user}", handleQR(config, store, qr.NewCache(10)),
+	)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		query       string
+		code        int
+		contentType string
+	}{
+		{"", http.StatusOK, "image/png"},
+		{"?format=svg&level=h&margin=0&size=512&payload=bip21",
+			http.StatusOK, "image/svg+xml"},
+		{"?payload=address", http.StatusOK, "image/png"},
+		{"?payload=onchain", http.StatusBadRequest, ""},
+		{"?size=big", http.StatusBadRequest, ""},
+		{"?size=10", http.StatusBadRequest, ""},
+		{"?level=Z", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(ts.URL + "/qr/tips" + test.query)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.code {
+			t.Fatalf("%s: expected %d, got %d", test.query,
+				test.code, resp.StatusCode)
+		}
+		if test.contentType != "" &&
+			resp.Header.Get("Content-Type") != test.contentType {
+
+			t.Fatalf("%s: unexpected content type %s", test.query,
+				resp.Header.Get("Content-Type"))
+		}
+	}
+
+	// Clients revalidate with the ETag.
+	resp, err := http.Get(ts.URL + "/qr/tips")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/qr/tips", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", resp.StatusCode)
+	}
+
+	content, _ := qrPayload(
+		config, &users.User{Name: "tips"}, payloadBIP21,
+	)
+	if !strings.HasPrefix(content, "bitcoin:?lightning=LNURL1") {
+		t.Fatalf("unexpected BIP21 payload %s", content)
+	}
+}
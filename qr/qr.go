@@ -0,0 +1,263 @@
+// Package qr renders QR codes as PNG or SVG images.
+package qr
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"sync"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// FormatPNG renders a PNG image.
+	FormatPNG = "png"
+
+	// FormatSVG renders an SVG image.
+	FormatSVG = "svg"
+
+	// DefaultSize is the image width and height in pixels if not given.
+	DefaultSize = 256
+
+	// MinSize and MaxSize bound the image size in pixels.
+	MinSize = 64
+	MaxSize = 2048
+
+	// DefaultMargin is the quiet zone around the code in modules, as
+	// required by the QR code specification.
+	DefaultMargin = 4
+
+	// MaxMargin is the largest accepted margin in modules.
+	MaxMargin = 32
+)
+
+// ErrInvalidOptions is returned for options out of the accepted range.
+var ErrInvalidOptions = errors.New("invalid QR code options")
+
+// levels maps the error correction levels to the encoder's recovery levels.
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options configure how a QR code is rendered.
+type Options struct {
+	// Format is either FormatPNG or FormatSVG.
+	Format string
+
+	// Size is the width and height of the image in pixels. The modules
+	// are scaled to whole pixels, so the code may be a bit smaller than
+	// the image.
+	Size int
+
+	// Level is the error correction level: L, M, Q or H.
+	Level string
+
+	// Margin is the quiet zone around the code in modules.
+	Margin int
+}
+
+// DefaultOptions returns the options used for parameters that aren't given.
+func DefaultOptions() Options {
+	return Options{
+		Format: FormatPNG,
+		Size:   DefaultSize,
+		Level:  "M",
+		Margin: DefaultMargin,
+	}
+}
+
+// Validate checks that the options are in the accepted range.
+func (o Options) Validate() error {
+	switch {
+	case o.Format != FormatPNG && o.Format != FormatSVG:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidOptions,
+			o.Format)
+
+	case o.Size < MinSize || o.Size > MaxSize:
+		return fmt.Errorf("%w: size must be between %d and %d",
+			ErrInvalidOptions, MinSize, MaxSize)
+
+	case o.Margin < 0 || o.Margin > MaxMargin:
+		return fmt.Errorf("%w: margin must be between 0 and %d",
+			ErrInvalidOptions, MaxMargin)
+	}
+	if _, ok := levels[strings.ToUpper(o.Level)]; !ok {
+		return fmt.Errorf("%w: unknown error correction level %q",
+			ErrInvalidOptions, o.Level)
+	}
+
+	return nil
+}
+
+// ContentType returns the MIME type of the rendered image.
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+
+	return "image/png"
+}
+
+// Render encodes the content as QR code image.
+func Render(content string, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	q, err := qrcode.New(content, levels[strings.ToUpper(opts.Level)])
+	if err != nil {
+		return nil, err
+	}
+	q.DisableBorder = true
+	modules := q.Bitmap()
+
+	// Every module is drawn with the same whole number of pixels so the
+	// code stays sharp. Codes too large for the size get one pixel per
+	// module, which grows the image.
+	total := len(modules) + 2*opts.Margin
+	scale := opts.Size / total
+	if scale < 1 {
+		scale = 1
+	}
+	offset := (opts.Size - total*scale) / 2
+	if offset < 0 {
+		offset = 0
+	}
+	offset += opts.Margin * scale
+
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts.Size, scale, offset), nil
+	}
+
+	return renderPNG(modules, opts.Size, scale, offset)
+}
+
+func renderPNG(modules [][]bool, size, scale, offset int) ([]byte, error) {
+	dim := max(size, 2*offset+len(modules)*scale)
+	img := image.NewPaletted(
+		image.Rect(0, 0, dim, dim),
+		color.Palette{color.White, color.Black},
+	)
+	for y, row := range modules {
+		for x, set := range row {
+			if !set {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(
+						offset+x*scale+dx,
+						offset+y*scale+dy, 1,
+					)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func renderSVG(modules [][]bool, size, scale, offset int) []byte {
+	dim := max(size, 2*offset+len(modules)*scale)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" `+
+		`height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		"\n", dim, dim, dim, dim)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n",
+		dim, dim)
+
+	// All modules are drawn as a single path, merging horizontal runs.
+	buf.WriteString(`<path fill="#000" d="`)
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz",
+				offset+start*scale, offset+y*scale,
+				(x-start)*scale, scale, (x-start)*scale)
+		}
+	}
+	buf.WriteString(`"/>` + "\n</svg>\n")
+
+	return buf.Bytes()
+}
+
+// Cache keeps the most recently rendered images in memory.
+type Cache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key   string
+	image []byte
+}
+
+// NewCache returns a cache that holds at most max images.
+func NewCache(max int) *Cache {
+	return &Cache{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Render returns the cached image of the content with the given options or
+// renders and caches it.
+func (c *Cache) Render(content string, opts Options) ([]byte, error) {
+	key := fmt.Sprintf("%s|%d|%s|%d|%s", opts.Format, opts.Size,
+		strings.ToUpper(opts.Level), opts.Margin, content)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+
+		return e.Value.(*cacheEntry).image, nil
+	}
+	c.mu.Unlock()
+
+	img, err := Render(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(
+			&cacheEntry{key: key, image: img},
+		)
+		for c.order.Len() > c.max {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,106 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+const content = "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C" +
+	"35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6" +
+	"XYEFHVGCXXCMYXYMNSERXFQ5FNS"
+
+func TestRender_PNG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Size = 300
+	opts.Margin = 2
+
+	img, err := Render(content, opts)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	bounds := decoded.Bounds()
+	if bounds.Dx() != 300 || bounds.Dy() != 300 {
+		t.Fatalf("unexpected size %v", bounds)
+	}
+
+	// The corners are part of the margin, the finder pattern starts
+	// right after it.
+	r, _, _, _ := decoded.At(0, 0).RGBA()
+	if r == 0 {
+		t.Fatalf("expected white margin")
+	}
+	found := false
+	for i := 0; i < 150 && !found; i++ {
+		r, _, _, _ := decoded.At(i, i).RGBA()
+		found = r == 0
+	}
+	if !found {
+		t.Fatalf("expected finder pattern on the diagonal")
+	}
+}
+
+func TestRender_SVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Format = FormatSVG
+
+	img, err := Render(content, opts)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	svg := string(img)
+	if !strings.Contains(svg, `viewBox="0 0 256 256"`) ||
+		!strings.Contains(svg, `<path fill="#000" d="M`) {
+
+		t.Fatalf("unexpected svg: %s", svg)
+	}
+}
+
+func TestRender_InvalidOptions(t *testing.T) {
+	for _, mod := range []func(*Options){
+		func(o *Options) { o.Format = "gif" },
+		func(o *Options) { o.Size = 10 },
+		func(o *Options) { o.Size = 10_000 },
+		func(o *Options) { o.Level = "X" },
+		func(o *Options) { o.Margin = -1 },
+	} {
+		opts := DefaultOptions()
+		mod(&opts)
+		_, err := Render(content, opts)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected ErrInvalidOptions for %+v, got %v",
+				opts, err)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	opts := DefaultOptions()
+
+	first, err := c.Render("a", opts)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	again, _ := c.Render("a", opts)
+	if &first[0] != &again[0] {
+		t.Fatalf("expected cached image")
+	}
+
+	_, _ = c.Render("b", opts)
+	_, _ = c.Render("c", opts)
+	if c.order.Len() != 2 {
+		t.Fatalf("expected 2 cached images, got %d", c.order.Len())
+	}
+	if _, ok := c.entries[opts.Format+"|256|M|4|a"]; ok {
+		t.Fatalf("expected least recently used image to be evicted")
+	}
+}
@@ -0,0 +1,436 @@
+// Package tgbot runs a Telegram bot that answers questions about the received
+// payments and creates invoices for authorized chats.
+package tgbot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/hieblmi/go-host-lnaddr/invoice"
+	"github.com/hieblmi/go-host-lnaddr/qr"
+)
+
+const (
+	// defaultLast is the number of payments /last lists without
+	// argument, maxLast the most it lists.
+	defaultLast = 10
+	maxLast     = 50
+
+	// defaultMute is how long /mute mutes without argument.
+	defaultMute = time.Hour
+
+	// defaultInvoiceExpiry is the expiry of invoices created with
+	// /invoice.
+	defaultInvoiceExpiry = time.Hour
+
+	// pollTimeout is the long polling timeout of getUpdates in seconds.
+	pollTimeout = 30
+
+	// retryDelay is the pause after getUpdates failed.
+	retryDelay = 5 * time.Second
+)
+
+var log btclog.Logger
+
+// SetLogger allows the main package to provide a shared logger.
+func SetLogger(l btclog.Logger) { log = l }
+
+// Config configures the Telegram bot.
+type Config struct {
+	// Token is the Bot API token. Defaults to the token of the first
+	// telegram notifier.
+	Token string `json:"Token" toml:"Token"`
+
+	// ChatIDs are the chats whose commands are answered. Defaults to the
+	// chats of the telegram notifiers using the same token.
+	ChatIDs []int64 `json:"ChatIDs" toml:"ChatIDs"`
+
+	// Timezone is the IANA time zone /today is computed in. Defaults to
+	// the server's local time zone.
+	Timezone string `json:"Timezone" toml:"Timezone"`
+
+	// InvoiceExpirySec is the expiry of invoices created with /invoice,
+	// one hour by default.
+	InvoiceExpirySec int `json:"InvoiceExpirySec" toml:"InvoiceExpirySec"`
+}
+
+// InvoiceMaker creates invoices, usually the invoice.Manager. The invoices
+// are recorded as payments to the address once settled.
+type InvoiceMaker interface {
+	CreateInvoice(address string, params invoice.Params) (string, []byte,
+		error)
+}
+
+// BotConfig holds the dependencies of the bot.
+type BotConfig struct {
+	Config
+
+	// Payments is where the received payments are looked up.
+	Payments *invoice.Store
+
+	// Invoices creates the invoices of /invoice.
+	Invoices InvoiceMaker
+
+	// Addresses are the lightning addresses the bot reports on. The
+	// invoices of /invoice are made out to the first one.
+	Addresses []string
+
+	// Mute mutes the telegram notifications until the given time. A zero
+	// time unmutes them.
+	Mute func(until time.Time)
+
+	// Client talks to the Bot API, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Bot answers the commands of the authorized chats.
+type Bot struct {
+	Cfg *BotConfig
+
+	api      *tgbotapi.BotAPI
+	chats    map[int64]bool
+	location *time.Location
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBot creates a bot and checks its token with the Bot API.
+func NewBot(cfg *BotConfig) (*Bot, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("token required")
+	}
+	if len(cfg.ChatIDs) == 0 {
+		return nil, errors.New("no authorized chats")
+	}
+
+	location := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	api, err := tgbotapi.NewBotAPIWithClient(cfg.Token, client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to Bot API: %w", err)
+	}
+
+	chats := make(map[int64]bool, len(cfg.ChatIDs))
+	for _, id := range cfg.ChatIDs {
+		chats[id] = true
+	}
+
+	return &Bot{
+		Cfg:      cfg,
+		api:      api,
+		chats:    chats,
+		location: location,
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+// Start starts answering commands.
+func (b *Bot) Start() {
+	b.wg.Add(1)
+	go b.run()
+}
+
+// Stop stops the bot. It waits for the pending long poll to return.
+func (b *Bot) Stop() {
+	close(b.quit)
+	b.wg.Wait()
+}
+
+func (b *Bot) run() {
+	defer b.wg.Done()
+
+	log.Infof("Telegram bot @%s answering commands", b.api.Self.UserName)
+
+	offset := 0
+	for {
+		select {
+		case <-b.quit:
+			return
+		default:
+		}
+
+		updates, err := b.api.GetUpdates(tgbotapi.UpdateConfig{
+			Offset:  offset,
+			Timeout: pollTimeout,
+		})
+		if err != nil {
+			log.Warnf("Unable to get Telegram updates: %v", err)
+			select {
+			case <-time.After(retryDelay):
+			case <-b.quit:
+				return
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil && u.Message.IsCommand() {
+				b.handle(u.Message)
+			}
+		}
+	}
+}
+
+// handle answers a command.
+func (b *Bot) handle(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	if !b.chats[chatID] {
+		log.Warnf("Ignoring /%s from unauthorized chat %d",
+			msg.Command(), chatID)
+		b.reply(chatID, "This chat isn't authorized.")
+		return
+	}
+
+	args := strings.Fields(msg.CommandArguments())
+
+	var (
+		text string
+		err  error
+	)
+	switch msg.Command() {
+	case "today":
+		text, err = b.today()
+
+	case "last":
+		text, err = b.last(args)
+
+	case "total":
+		text, err = b.total()
+
+	case "invoice":
+		err = b.invoice(chatID, args)
+		if err == nil {
+			return
+		}
+
+	case "mute":
+		text, err = b.mute(args)
+
+	default:
+		text = helpText
+	}
+	if err != nil {
+		log.Errorf("Unable to answer /%s: %v", msg.Command(), err)
+		text = "Error: " + err.Error()
+	}
+
+	b.reply(chatID, text)
+}
+
+const helpText = `Commands:
+/today - payments received today
+/last [n] - the last n payments
+/total - all payments received
+/invoice <sats> [memo] - create an invoice
+/mute [duration] - mute notifications, e.g. /mute 2h, /mute off`
+
+func (b *Bot) reply(chatID int64, text string) {
+	if _, err := b.api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		log.Errorf("Unable to send Telegram reply: %v", err)
+	}
+}
+
+// payments returns the payments of all addresses settled at or after since,
+// most recent first. A limit greater than zero returns at most that many.
+func (b *Bot) payments(since time.Time, limit int) ([]*invoice.Record,
+	error) {
+
+	var all []*invoice.Record
+	for _, address := range b.Cfg.Addresses {
+		records, err := b.Cfg.Payments.Settled(address, since, limit)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, records...)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].SettledAt.After(all[j].SettledAt)
+	})
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+
+	return all, nil
+}
+
+func (b *Bot) today() (string, error) {
+	now := time.Now().In(b.location)
+	midnight := time.Date(
+		now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, b.location,
+	)
+
+	today, err := b.payments(midnight, 0)
+	if err != nil {
+		return "", err
+	}
+
+	return "Today: " + summary(today), nil
+}
+
+func (b *Bot) total() (string, error) {
+	records, err := b.payments(time.Time{}, 0)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{"Total: " + summary(records)}
+	if len(b.Cfg.Addresses) > 1 {
+		for _, address := range b.Cfg.Addresses {
+			var own []*invoice.Record
+			for _, r := range records {
+				if r.Address == address {
+					own = append(own, r)
+				}
+			}
+			lines = append(lines, address+": "+summary(own))
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b *Bot) last(args []string) (string, error) {
+	n := defaultLast
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid number %q", args[0])
+		}
+		n = min(n, maxLast)
+	}
+
+	records, err := b.payments(time.Time{}, n)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "No payments yet.", nil
+	}
+
+	lines := make([]string, 0, n)
+	for _, r := range records {
+		line := fmt.Sprintf("%s: %d sats to %s",
+			r.SettledAt.In(b.location).Format("2006-01-02 15:04"),
+			r.AmountPaidMsat/1000, r.Address)
+		if r.Comment != "" {
+			line += fmt.Sprintf(" - %q", r.Comment)
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b *Bot) invoice(chatID int64, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: /invoice <sats> [memo]")
+	}
+	sats, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || sats <= 0 {
+		return fmt.Errorf("invalid amount %q", args[0])
+	}
+
+	expiry := defaultInvoiceExpiry
+	if b.Cfg.InvoiceExpirySec > 0 {
+		expiry = time.Duration(b.Cfg.InvoiceExpirySec) * time.Second
+	}
+
+	if len(b.Cfg.Addresses) == 0 {
+		return errors.New("no address to create the invoice for")
+	}
+
+	pr, _, err := b.Cfg.Invoices.CreateInvoice(
+		b.Cfg.Addresses[0], invoice.Params{
+			Msat:        sats * 1000,
+			Description: strings.Join(args[1:], " "),
+			Expiry:      expiry,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to create invoice: %w", err)
+	}
+
+	// Upper case payment requests fit the more compact alphanumeric QR
+	// code mode.
+	img, err := qr.Render(strings.ToUpper(pr), qr.DefaultOptions())
+	if err != nil {
+		return err
+	}
+
+	photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{
+		Name:  "invoice.png",
+		Bytes: img,
+	})
+	photo.Caption = pr
+	if _, err := b.api.Send(photo); err != nil {
+		// The payment request is still useful without its QR code.
+		log.Errorf("Unable to send invoice QR code: %v", err)
+		b.reply(chatID, pr)
+	}
+
+	return nil
+}
+
+func (b *Bot) mute(args []string) (string, error) {
+	if b.Cfg.Mute == nil {
+		return "", errors.New("no notifications to mute")
+	}
+
+	duration := defaultMute
+	if len(args) > 0 {
+		if args[0] == "off" {
+			b.Cfg.Mute(time.Time{})
+			return "Notifications unmuted.", nil
+		}
+
+		var err error
+		duration, err = time.ParseDuration(args[0])
+		if err != nil || duration <= 0 {
+			return "", fmt.Errorf("invalid duration %q", args[0])
+		}
+	}
+
+	until := time.Now().Add(duration)
+	b.Cfg.Mute(until)
+
+	return "Notifications muted until " +
+		until.In(b.location).Format("2006-01-02 15:04") + ".", nil
+}
+
+// summary returns the number and sum of the payments.
+func summary(records []*invoice.Record) string {
+	var msat int64
+	for _, r := range records {
+		msat += r.AmountPaidMsat
+	}
+
+	noun := "payments"
+	if len(records) == 1 {
+		noun = "payment"
+	}
+
+	return fmt.Sprintf("%d %s, %d sats", len(records), noun, msat/1000)
+}
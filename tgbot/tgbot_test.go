@@ -0,0 +1,542 @@
+package tgbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/invoice"
+	"github.com/hieblmi/go-host-lnaddr/notifier"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"go.etcd.io/bbolt"
+	"google.golang.org/grpc"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+	invoice.SetLogger(btclog.Disabled)
+
+	// The settlements are broadcast to no notifiers.
+	_ = notifier.SetupNotifiers(nil, nil, btclog.Disabled)
+}
+
+const (
+	testToken = "123:test"
+	chatID    = 42
+)
+
+// reply is a message or photo the bot sent.
+type reply struct {
+	chatID int64
+	text   string
+	photo  []byte
+}
+
+// stubAPI is a local stand-in for the Telegram Bot API.
+type stubAPI struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	updates []json.RawMessage
+	nextID  int
+
+	// rejectPhotos makes sendPhoto fail.
+	rejectPhotos bool
+
+	replies chan reply
+}
+
+func newStubAPI(t *testing.T) (*stubAPI, *http.Client) {
+	t.Helper()
+
+	s := &stubAPI{t: t, replies: make(chan reply, 10)}
+	srv := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: rewriteTransport{target}}
+
+	return s, client
+}
+
+// rewriteTransport sends the requests to api.telegram.org to the stub.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (r rewriteTransport) RoundTrip(req *http.Request) (*http.Response,
+	error) {
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (s *stubAPI) serve(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + testToken + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, `{"ok":false,"description":"Unauthorized"}`,
+			http.StatusUnauthorized)
+		return
+	}
+
+	var result any
+	switch method := strings.TrimPrefix(r.URL.Path, prefix); method {
+	case "getMe":
+		result = map[string]any{
+			"id": 1, "is_bot": true, "first_name": "lnaddr",
+			"username": "lnaddr_bot",
+		}
+
+	case "getUpdates":
+		offset, _ := strconv.Atoi(r.FormValue("offset"))
+		result = s.pending(offset)
+
+	case "sendMessage":
+		s.replies <- reply{
+			chatID: formInt(r.FormValue("chat_id")),
+			text:   r.FormValue("text"),
+		}
+		result = sentMessage
+
+	case "sendPhoto":
+		s.mu.Lock()
+		reject := s.rejectPhotos
+		s.mu.Unlock()
+		if reject {
+			http.Error(w, `{"ok":false,"error_code":400,`+
+				`"description":"Bad Request: IMAGE_PROCESS_FAILED"}`,
+				http.StatusBadRequest)
+			return
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			s.t.Errorf("invalid photo upload: %v", err)
+			return
+		}
+		f, _, err := r.FormFile("photo")
+		if err != nil {
+			s.t.Errorf("photo missing: %v", err)
+			return
+		}
+		photo, _ := io.ReadAll(f)
+		s.replies <- reply{
+			chatID: formInt(r.FormValue("chat_id")),
+			text:   r.FormValue("caption"),
+			photo:  photo,
+		}
+		result = sentMessage
+
+	default:
+		s.t.Errorf("unexpected method %s", method)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ok": true, "result": result,
+	})
+}
+
+var sentMessage = map[string]any{
+	"message_id": 1, "date": 0, "chat": map[string]any{"id": chatID},
+}
+
+func formInt(s string) int64 {
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
+
+// pending returns the updates from the offset on. Like the real API it waits
+// a bit for new updates if there are none.
+func (s *stubAPI) pending(offset int) []json.RawMessage {
+	for i := 0; i < 10; i++ {
+		s.mu.Lock()
+		var updates []json.RawMessage
+		for id := offset; id < s.nextID; id++ {
+			updates = append(updates, s.updates[id])
+		}
+		s.mu.Unlock()
+
+		if len(updates) > 0 {
+			return updates
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return []json.RawMessage{}
+}
+
+// ask sends the command as the chat and returns the bot's reply.
+func (s *stubAPI) ask(chat int64, text string) reply {
+	s.t.Helper()
+
+	command, _, _ := strings.Cut(text, " ")
+
+	s.mu.Lock()
+	update := fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,`+
+		`"date":0,"chat":{"id":%d,"type":"private"},"text":%q,`+
+		`"entities":[{"type":"bot_command","offset":0,"length":%d}]}}`,
+		s.nextID, s.nextID, chat, text, len(command))
+	s.updates = append(s.updates, json.RawMessage(update))
+	s.nextID++
+	s.mu.Unlock()
+
+	select {
+	case r := <-s.replies:
+		return r
+
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("no reply to %s", text)
+		return reply{}
+	}
+}
+
+type fakeInvoices struct {
+	addresses []string
+	params    []invoice.Params
+}
+
+func (f *fakeInvoices) CreateInvoice(address string,
+	p invoice.Params) (string, []byte, error) {
+
+	f.addresses = append(f.addresses, address)
+	f.params = append(f.params, p)
+
+	return "lnbc50u1test", []byte{1}, nil
+}
+
+// newTestPayments creates a store with a few settled payments.
+func newTestPayments(t *testing.T) *invoice.Store {
+	t.Helper()
+
+	db, err := bbolt.Open(
+		filepath.Join(t.TempDir(), "test.db"), 0600, nil,
+	)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	payments, err := invoice.NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	now := time.Now()
+	for i, p := range []struct {
+		address   string
+		sats      int64
+		comment   string
+		settledAt time.Time
+	}{
+		{"alice@example.com", 21, "gm", now},
+		{"alice@example.com", 100, "", now.AddDate(0, 0, -3)},
+		{"bob@example.com", 1000, "", now.Add(-time.Second)},
+	} {
+		hash := strconv.Itoa(i)
+		err := payments.Add(&invoice.Record{
+			PaymentHash: hash, Address: p.address,
+			Comment: p.comment,
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		err = payments.Settle(hash, p.sats*1000, p.settledAt)
+		if err != nil {
+			t.Fatalf("Settle: %v", err)
+		}
+	}
+
+	return payments
+}
+
+func newTestBot(t *testing.T, client *http.Client, payments *invoice.Store,
+	invoices InvoiceMaker, mute func(time.Time)) *Bot {
+
+	t.Helper()
+
+	bot, err := NewBot(&BotConfig{
+		Config: Config{
+			Token:    testToken,
+			ChatIDs:  []int64{chatID},
+			Timezone: "UTC",
+		},
+		Payments:  payments,
+		Invoices:  invoices,
+		Addresses: []string{"alice@example.com", "bob@example.com"},
+		Mute:      mute,
+		Client:    client,
+	})
+	if err != nil {
+		t.Fatalf("NewBot returned error: %v", err)
+	}
+	bot.Start()
+	t.Cleanup(bot.Stop)
+
+	return bot
+}
+
+func TestBotCommands(t *testing.T) {
+	api, client := newStubAPI(t)
+	newTestBot(t, client, newTestPayments(t), &fakeInvoices{}, nil)
+
+	// The payment of three days ago only counts towards the total. Bob's
+	// payment is yesterday's if the test runs right at midnight UTC.
+	today := api.ask(chatID, "/today")
+	if today.chatID != chatID ||
+		!strings.HasPrefix(today.text, "Today: 2 payments, 1021 sats") &&
+			!strings.HasPrefix(today.text, "Today: 1 payment") {
+
+		t.Errorf("unexpected /today reply %+v", today)
+	}
+
+	last := api.ask(chatID, "/last 2")
+	lines := strings.Split(last.text, "\n")
+	if len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], `21 sats to alice@example.com - "gm"`) ||
+		!strings.HasSuffix(lines[1], "1000 sats to bob@example.com") {
+
+		t.Errorf("unexpected /last reply %q", last.text)
+	}
+
+	total := api.ask(chatID, "/total")
+	expected := "Total: 3 payments, 1121 sats\n" +
+		"alice@example.com: 2 payments, 121 sats\n" +
+		"bob@example.com: 1 payment, 1000 sats"
+	if total.text != expected {
+		t.Errorf("unexpected /total reply %q", total.text)
+	}
+
+	if help := api.ask(chatID, "/start"); help.text != helpText {
+		t.Errorf("unexpected /start reply %q", help.text)
+	}
+
+	invalid := api.ask(chatID, "/last many")
+	if !strings.HasPrefix(invalid.text, "Error: invalid number") {
+		t.Errorf("unexpected reply to invalid /last: %q", invalid.text)
+	}
+}
+
+func TestBotInvoice(t *testing.T) {
+	api, client := newStubAPI(t)
+	invoices := &fakeInvoices{}
+	newTestBot(t, client, newTestPayments(t), invoices, nil)
+
+	r := api.ask(chatID, "/invoice 5000 coffee and cake")
+	if r.text != "lnbc50u1test" ||
+		!bytes.HasPrefix(r.photo, []byte("\x89PNG")) {
+
+		t.Fatalf("expected QR code photo, got %q with %d bytes", r.text,
+			len(r.photo))
+	}
+
+	if len(invoices.params) != 1 ||
+		invoices.addresses[0] != "alice@example.com" {
+
+		t.Fatalf("expected one invoice to alice, got %v",
+			invoices.addresses)
+	}
+	p := invoices.params[0]
+	if p.Msat != 5_000_000 || p.Description != "coffee and cake" ||
+		p.Expiry != defaultInvoiceExpiry {
+
+		t.Errorf("unexpected invoice params %+v", p)
+	}
+
+	r = api.ask(chatID, "/invoice -1")
+	if !strings.HasPrefix(r.text, "Error: invalid amount") {
+		t.Errorf("unexpected reply to invalid amount: %q", r.text)
+	}
+
+	// Without the QR code the payment request is sent as text.
+	api.mu.Lock()
+	api.rejectPhotos = true
+	api.mu.Unlock()
+
+	r = api.ask(chatID, "/invoice 100")
+	if r.text != "lnbc50u1test" || r.photo != nil {
+		t.Errorf("expected text payment request, got %q with %d bytes",
+			r.text, len(r.photo))
+	}
+}
+
+// fakeLnd creates invoices that are settled as soon as they are subscribed
+// to.
+type fakeLnd struct {
+	lnrpc.LightningClient
+
+	invoice *lnrpc.Invoice
+}
+
+func (f *fakeLnd) AddInvoice(_ context.Context, in *lnrpc.Invoice,
+	_ ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+
+	f.invoice = &lnrpc.Invoice{
+		RHash:       []byte{1, 2, 3},
+		State:       lnrpc.Invoice_SETTLED,
+		AmtPaidMsat: in.ValueMsat,
+	}
+
+	return &lnrpc.AddInvoiceResponse{
+		PaymentRequest: "lnbc50u1test",
+		RHash:          f.invoice.RHash,
+	}, nil
+}
+
+func (f *fakeLnd) SubscribeInvoices(ctx context.Context,
+	_ *lnrpc.InvoiceSubscription, _ ...grpc.CallOption) (
+	lnrpc.Lightning_SubscribeInvoicesClient, error) {
+
+	return &settledStream{ctx: ctx, invoice: f.invoice}, nil
+}
+
+// settledStream returns the settled invoice and then blocks until the
+// subscription ends.
+type settledStream struct {
+	grpc.ClientStream
+
+	ctx     context.Context
+	invoice *lnrpc.Invoice
+}
+
+func (s *settledStream) Recv() (*lnrpc.Invoice, error) {
+	if inv := s.invoice; inv != nil {
+		s.invoice = nil
+		return inv, nil
+	}
+	<-s.ctx.Done()
+
+	return nil, s.ctx.Err()
+}
+
+func TestBotInvoice_Settled(t *testing.T) {
+	api, client := newStubAPI(t)
+
+	lnd := &fakeLnd{}
+	payments := newTestPayments(t)
+	sh := invoice.NewSettlementHandler(lnd, "", payments)
+	settled := make(chan struct{}, 1)
+	sh.AddListener(func(*invoice.Settlement) { settled <- struct{}{} })
+	mgr, err := invoice.NewInvoiceManager(&invoice.ManagerConfig{
+		LndClient:         lnd,
+		SettlementHandler: sh,
+		Store:             payments,
+	})
+	if err != nil {
+		t.Fatalf("NewInvoiceManager: %v", err)
+	}
+	newTestBot(t, client, payments, mgr, nil)
+
+	r := api.ask(chatID, "/invoice 5000 coffee")
+	if r.text != "lnbc50u1test" {
+		t.Fatalf("unexpected /invoice reply %q", r.text)
+	}
+	select {
+	case <-settled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("invoice not settled")
+	}
+
+	// The settled invoice counts as a payment to the first address.
+	today := api.ask(chatID, "/today")
+	if !strings.HasPrefix(today.text, "Today: 3 payments, 6021 sats") &&
+		!strings.HasPrefix(today.text, "Today: 2 payments, 5021 sats") {
+
+		t.Errorf("unexpected /today reply %q", today.text)
+	}
+
+	last := api.ask(chatID, "/last 1")
+	if !strings.HasSuffix(
+		last.text, `5000 sats to alice@example.com - "coffee"`,
+	) {
+
+		t.Errorf("unexpected /last reply %q", last.text)
+	}
+
+	total := api.ask(chatID, "/total")
+	if !strings.HasPrefix(total.text, "Total: 4 payments, 6121 sats\n"+
+		"alice@example.com: 3 payments, 5121 sats") {
+
+		t.Errorf("unexpected /total reply %q", total.text)
+	}
+}
+
+func TestBotMute(t *testing.T) {
+	api, client := newStubAPI(t)
+
+	var mutedUntil []time.Time
+	mute := func(until time.Time) {
+		mutedUntil = append(mutedUntil, until)
+	}
+	newTestBot(t, client, newTestPayments(t), &fakeInvoices{}, mute)
+
+	r := api.ask(chatID, "/mute 2h")
+	if !strings.HasPrefix(r.text, "Notifications muted until") {
+		t.Errorf("unexpected /mute reply %q", r.text)
+	}
+	r = api.ask(chatID, "/mute")
+	if !strings.HasPrefix(r.text, "Notifications muted until") {
+		t.Errorf("unexpected /mute reply %q", r.text)
+	}
+	r = api.ask(chatID, "/mute off")
+	if r.text != "Notifications unmuted." {
+		t.Errorf("unexpected /mute off reply %q", r.text)
+	}
+
+	if len(mutedUntil) != 3 {
+		t.Fatalf("expected three mutes, got %v", mutedUntil)
+	}
+	if d := time.Until(mutedUntil[0]); d < time.Hour || d > 2*time.Hour {
+		t.Errorf("expected mute for 2h, got %v", d)
+	}
+	if d := time.Until(mutedUntil[1]); d <= 0 || d > defaultMute {
+		t.Errorf("expected default mute, got %v", d)
+	}
+	if !mutedUntil[2].IsZero() {
+		t.Errorf("expected unmute, got %v", mutedUntil[2])
+	}
+}
+
+func TestBotUnauthorizedChat(t *testing.T) {
+	api, client := newStubAPI(t)
+	invoices := &fakeInvoices{}
+	newTestBot(t, client, newTestPayments(t), invoices, nil)
+
+	r := api.ask(7, "/invoice 5000")
+	if r.chatID != 7 || r.text != "This chat isn't authorized." {
+		t.Errorf("unexpected reply %+v", r)
+	}
+	if len(invoices.params) != 0 {
+		t.Errorf("invoice created for unauthorized chat")
+	}
+}
+
+func TestNewBotInvalid(t *testing.T) {
+	_, client := newStubAPI(t)
+
+	for _, cfg := range []Config{
+		{ChatIDs: []int64{chatID}},
+		{Token: testToken},
+		{Token: testToken, ChatIDs: []int64{chatID}, Timezone: "Mars"},
+		{Token: "wrong", ChatIDs: []int64{chatID}},
+	} {
+		_, err := NewBot(&BotConfig{Config: cfg, Client: client})
+		if err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/lightningnetwork/lnd/tor"
+)
+
+// defaultTorDialTimeout is the time we give Tor to build a circuit to the
+// lnd node.
+const defaultTorDialTimeout = time.Minute
+
+// TorConfig configures how the connection to lnd is routed through Tor.
+type TorConfig struct {
+	// SOCKS is the host:port of the Tor SOCKS5 proxy, for example
+	// 127.0.0.1:9050.
+	SOCKS string `json:"SOCKS" toml:"SOCKS"`
+
+	// StreamIsolation makes Tor build a fresh circuit for every
+	// connection by sending random SOCKS credentials.
+	StreamIsolation bool `json:"StreamIsolation" toml:"StreamIsolation"`
+
+	// SkipProxyForClearNet connects directly to RPCHost if it isn't an
+	// onion address.
+	SkipProxyForClearNet bool `json:"SkipProxyForClearNet" toml:"SkipProxyForClearNet"`
+
+	// DialTimeoutSec is the timeout for establishing a connection
+	// through the proxy. Defaults to one minute.
+	DialTimeoutSec int `json:"DialTimeoutSec" toml:"DialTimeoutSec"`
+}
+
+// torDialer returns a gRPC context dialer that connects through the
+// configured SOCKS5 proxy.
+func torDialer(cfg *TorConfig) func(context.Context, string) (net.Conn,
+	error) {
+
+	timeout := defaultTorDialTimeout
+	if cfg.DialTimeoutSec > 0 {
+		timeout = time.Duration(cfg.DialTimeoutSec) * time.Second
+	}
+
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		dialTimeout := timeout
+		if deadline, ok := ctx.Deadline(); ok {
+			if left := time.Until(deadline); left < dialTimeout {
+				dialTimeout = left
+			}
+		}
+
+		return tor.Dial(
+			address, cfg.SOCKS, cfg.StreamIsolation,
+			cfg.SkipProxyForClearNet, dialTimeout,
+		)
+	}
+}
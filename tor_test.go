@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// onionHost is a syntactically valid v3 onion address.
+var onionHost = strings.Repeat("a", 56) + ".onion"
+
+// socksRequest is what the SOCKS5 stub saw from a single client.
+type socksRequest struct {
+	user   string
+	target string
+}
+
+// startSocksStub starts a minimal SOCKS5 server that accepts every CONNECT
+// request and echoes everything sent through the tunnel.
+func startSocksStub(t *testing.T) (string, <-chan socksRequest) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	requests := make(chan socksRequest, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks(conn, requests)
+		}
+	}()
+
+	return l.Addr().String(), requests
+}
+
+func serveSocks(conn net.Conn, requests chan<- socksRequest) {
+	defer conn.Close()
+
+	var req socksRequest
+
+	// Greeting: version, number of methods, methods.
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(0x00)
+	for _, m := range methods {
+		if m == 0x02 {
+			method = 0x02
+		}
+	}
+	if _, err := conn.Write([]byte{0x05, method}); err != nil {
+		return
+	}
+
+	// Username/password sub-negotiation, as used for stream isolation.
+	if method == 0x02 {
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		user := make([]byte, b[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return
+		}
+		pass := make([]byte, b[0])
+		if _, err := io.ReadFull(conn, pass); err != nil {
+			return
+		}
+		req.user = string(user)
+		if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+			return
+		}
+	}
+
+	// CONNECT request with a domain name target.
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return
+	}
+	if b[1] != 0x01 || b[3] != 0x03 {
+		return
+	}
+	domain := make([]byte, b[4])
+	if _, err := io.ReadFull(conn, domain); err != nil {
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	req.target = net.JoinHostPort(
+		string(domain),
+		strconv.Itoa(int(binary.BigEndian.Uint16(port))),
+	)
+	requests <- req
+
+	_, err := conn.Write([]byte{
+		0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0,
+	})
+	if err != nil {
+		return
+	}
+
+	_, _ = io.Copy(conn, conn)
+}
+
+func TestTorDialer_Onion(t *testing.T) {
+	socks, requests := startSocksStub(t)
+
+	dial := torDialer(&TorConfig{SOCKS: socks})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target := net.JoinHostPort(onionHost, "10009")
+	conn, err := dial(ctx, target)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := <-requests
+	if req.target != target {
+		t.Errorf("unexpected target. want %s got %s", target,
+			req.target)
+	}
+	if req.user != "" {
+		t.Errorf("expected no credentials without stream isolation, "+
+			"got %q", req.user)
+	}
+
+	// The tunnel must be usable and the connection must report the onion
+	// service as its remote address instead of the proxy.
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("unexpected echo %q", buf)
+	}
+	if conn.RemoteAddr().String() != target {
+		t.Errorf("unexpected remote address %s", conn.RemoteAddr())
+	}
+}
+
+func TestTorDialer_StreamIsolation(t *testing.T) {
+	socks, requests := startSocksStub(t)
+
+	dial := torDialer(&TorConfig{SOCKS: socks, StreamIsolation: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target := net.JoinHostPort(onionHost, "10009")
+	creds := make(map[string]struct{})
+	for i := 0; i < 2; i++ {
+		conn, err := dial(ctx, target)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = conn.Close()
+
+		req := <-requests
+		if req.user == "" {
+			t.Fatalf("expected SOCKS credentials for stream " +
+				"isolation")
+		}
+		creds[req.user] = struct{}{}
+	}
+
+	if len(creds) != 2 {
+		t.Errorf("expected distinct credentials per connection")
+	}
+}
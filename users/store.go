@@ -0,0 +1,126 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrUnknownUser is returned by a Store if no user with the requested
+	// name exists.
+	ErrUnknownUser = errors.New("unknown user")
+
+	// ErrInvalidName is returned if a username contains characters that
+	// are not allowed by LUD-16.
+	ErrInvalidName = errors.New("invalid username")
+
+	// nameRegex is the set of characters LUD-16 allows for the username
+	// part of a lightning address.
+	nameRegex = regexp.MustCompile(`^[a-z0-9\-_.]+$`)
+)
+
+// User is a single lightning address hosted by this server.
+type User struct {
+	// Name is the normalized username, i.e. the part of the lightning
+	// address in front of the @.
+	Name string
+
+	// Address is the full lightning address as configured.
+	Address string
+}
+
+// Store resolves usernames to the users hosted by this server.
+type Store interface {
+	// Lookup returns the user with the given name. The name is normalized
+	// before the lookup, so lookups are case-insensitive. ErrUnknownUser
+	// is returned if no such user exists.
+	Lookup(name string) (*User, error)
+
+	// List returns all users of the store sorted by name.
+	List() ([]User, error)
+}
+
+// Normalize turns a username as entered by a payer into its canonical form
+// and validates it against the LUD-16 charset. A trailing domain is stripped
+// so that both "alice" and "Alice@example.com" resolve to "alice".
+func Normalize(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	name = strings.ToLower(name)
+
+	if !nameRegex.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	return name, nil
+}
+
+// ConfigStore is a static Store backed by the lightning addresses of the
+// config file.
+type ConfigStore struct {
+	users map[string]User
+}
+
+var _ Store = (*ConfigStore)(nil)
+
+// NewConfigStore creates a Store from a list of lightning addresses. It fails
+// if an address has an invalid username or if two addresses normalize to the
+// same username.
+func NewConfigStore(addresses []string) (*ConfigStore, error) {
+	s := &ConfigStore{
+		users: make(map[string]User, len(addresses)),
+	}
+
+	for _, addr := range addresses {
+		name, err := Normalize(addr)
+		if err != nil {
+			return nil, fmt.Errorf("lightning address %s: %w",
+				addr, err)
+		}
+
+		if existing, ok := s.users[name]; ok {
+			return nil, fmt.Errorf("lightning address %s "+
+				"clashes with %s", addr, existing.Address)
+		}
+
+		s.users[name] = User{
+			Name:    name,
+			Address: addr,
+		}
+	}
+
+	return s, nil
+}
+
+// Lookup returns the user with the given name.
+func (s *ConfigStore) Lookup(name string) (*User, error) {
+	name, err := Normalize(name)
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := s.users[name]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	return &user, nil
+}
+
+// List returns all configured users sorted by name.
+func (s *ConfigStore) List() ([]User, error) {
+	list := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
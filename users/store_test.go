@@ -0,0 +1,96 @@
+package users
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		invalid bool
+	}{
+		{in: "alice", want: "alice"},
+		{in: "Alice", want: "alice"},
+		{in: " BOB.tips ", want: "bob.tips"},
+		{in: "Carol_1-x@Example.com", want: "carol_1-x"},
+		{in: "", invalid: true},
+		{in: "eve smith", invalid: true},
+		{in: "mallory/../x", invalid: true},
+		{in: "ünicode", invalid: true},
+	}
+
+	for _, tc := range tests {
+		got, err := Normalize(tc.in)
+		if tc.invalid {
+			if !errors.Is(err, ErrInvalidName) {
+				t.Errorf("Normalize(%q): expected invalid "+
+					"name error, got %v", tc.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Normalize(%q): unexpected error: %v",
+				tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Normalize(%q): want %q got %q", tc.in,
+				tc.want, got)
+		}
+	}
+}
+
+func TestConfigStore_Lookup(t *testing.T) {
+	s, err := NewConfigStore([]string{
+		"tips@example.com", "Bob@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+
+	user, err := s.Lookup("TIPS")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if user.Name != "tips" || user.Address != "tips@example.com" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	user, err = s.Lookup("bob")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if user.Name != "bob" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	if _, err := s.Lookup("carol"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("expected unknown user error, got %v", err)
+	}
+	if _, err := s.Lookup("not valid"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected invalid name error, got %v", err)
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "bob" || list[1].Name != "tips" {
+		t.Errorf("unexpected list: %+v", list)
+	}
+}
+
+func TestNewConfigStore_Invalid(t *testing.T) {
+	if _, err := NewConfigStore([]string{"a b@example.com"}); err == nil {
+		t.Errorf("expected error for invalid username")
+	}
+
+	_, err := NewConfigStore([]string{
+		"tips@example.com", "TIPS@example.com",
+	})
+	if err == nil {
+		t.Errorf("expected error for clashing usernames")
+	}
+}
@@ -0,0 +1,128 @@
+// Package widget serves an embeddable tip-jar widget that requests invoices
+// from the LNURLp endpoints of the server.
+package widget
+
+import (
+	_ "embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/qr"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+const (
+	// maxInvoiceLength bounds the payment requests we render as QR code.
+	maxInvoiceLength = 2048
+
+	// invoiceQRCacheSize is the number of invoice QR codes kept in
+	// memory.
+	invoiceQRCacheSize = 128
+)
+
+var (
+	log btclog.Logger
+
+	//go:embed widget.js
+	script []byte
+
+	// invoiceRegex matches bech32 encoded BOLT11 payment requests.
+	invoiceRegex = regexp.MustCompile(`^ln[a-z]{2,6}[0-9a-z]+$`)
+)
+
+// SetLogger allows the main package to provide a shared logger.
+func SetLogger(l btclog.Logger) { log = l }
+
+// Handler serves the widget script, the tip page of every user and the QR
+// codes of the invoices the widget shows.
+type Handler struct {
+	store users.Store
+	cache *qr.Cache
+}
+
+// NewHandler creates a widget handler that resolves users through the store.
+func NewHandler(store users.Store) *Handler {
+	return &Handler{
+		store: store,
+		cache: qr.NewCache(invoiceQRCacheSize),
+	}
+}
+
+// HandleScript serves the widget script, which can be embedded on any page.
+func (h *Handler) HandleScript(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(script)
+}
+
+var pageTemplate = template.Must(template.New("tip").Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Tip {{.Name}}</title>
+	<style>body { margin: 0; } div { display: flex;
+		justify-content: center; padding: 8px; }</style>
+</head>
+<body>
+	<div id="tip"></div>
+	<script src="/widget.js" data-user="{{.Name}}" data-target="tip"></script>
+</body>
+</html>
+`))
+
+// HandlePage serves a page with just the widget of a user, meant to be
+// embedded as iframe.
+func (h *Handler) HandlePage(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.Lookup(r.PathValue("user"))
+	switch {
+	case errors.Is(err, users.ErrUnknownUser),
+		errors.Is(err, users.ErrInvalidName):
+
+		http.NotFound(w, r)
+		return
+
+	case err != nil:
+		log.Errorf("Unable to look up user %s: %v", r.PathValue("user"),
+			err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// The page is meant to be framed by other sites.
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTemplate.Execute(w, user); err != nil {
+		log.Errorf("Unable to render tip page: %v", err)
+	}
+}
+
+// HandleInvoiceQR renders the payment request given as pr query parameter as
+// PNG QR code. Only payment requests are rendered, so the endpoint can't be
+// used to create arbitrary QR codes.
+func (h *Handler) HandleInvoiceQR(w http.ResponseWriter, r *http.Request) {
+	pr := strings.ToLower(r.URL.Query().Get("pr"))
+	if len(pr) > maxInvoiceLength || !invoiceRegex.MatchString(pr) {
+		http.Error(w, "invalid payment request", http.StatusBadRequest)
+		return
+	}
+
+	// Upper case payment requests fit the more compact alphanumeric QR
+	// code mode.
+	img, err := h.cache.Render(strings.ToUpper(pr), qr.DefaultOptions())
+	if err != nil {
+		log.Errorf("Unable to render invoice QR code: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	_, _ = w.Write(img)
+}
@@ -0,0 +1,252 @@
+// Tip-jar widget for a lightning address hosted by go-host-lnaddr.
+//
+// Usage:
+//   <script src="https://example.com/widget.js" data-user="tips" async></script>
+//
+// Optional attributes:
+//   data-amounts: comma separated preset amounts in sats, "21,100,1000".
+//   data-target:  id of the element to render into, instead of right after
+//                 the script tag.
+(function () {
+  "use strict";
+
+  var script = document.currentScript;
+  if (!script || !script.dataset.user) {
+    return;
+  }
+
+  var origin = new URL(script.src).origin;
+  var user = script.dataset.user;
+  var amounts = (script.dataset.amounts || "21,100,1000")
+    .split(",")
+    .map(function (a) { return parseInt(a, 10); })
+    .filter(function (a) { return a > 0; });
+
+  var style = [
+    ":host { all: initial; font-family: sans-serif; }",
+    ".box { border: 1px solid #ddd; border-radius: 8px; padding: 1em;",
+    "  max-width: 320px; text-align: center; background: #fff; color: #222; }",
+    "h3 { margin: 0 0 0.5em; font-size: 1.1em; }",
+    ".amounts button { margin: 0.2em; }",
+    "button { padding: 0.4em 0.8em; border-radius: 4px; cursor: pointer;",
+    "  border: 1px solid #f7931a; background: #fff; color: #222; }",
+    "button.selected, button.primary { background: #f7931a; color: #fff; }",
+    "input, textarea { box-sizing: border-box; width: 100%; margin: 0.3em 0;",
+    "  padding: 0.4em; }",
+    "img { width: 240px; height: 240px; }",
+    ".pr { font-size: 0.7em; word-break: break-all; color: #666; }",
+    ".error { color: #b00; }",
+  ].join("\n");
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === "text") {
+        node.textContent = attrs[k];
+      } else if (k.indexOf("on") === 0) {
+        node.addEventListener(k.slice(2), attrs[k]);
+      } else {
+        node.setAttribute(k, attrs[k]);
+      }
+    });
+    (children || []).forEach(function (c) { node.appendChild(c); });
+    return node;
+  }
+
+  function getJSON(url) {
+    return fetch(url).then(function (resp) {
+      return resp.json().then(function (body) {
+        if (!resp.ok || body.status === "ERROR") {
+          throw new Error(body.reason || "Request failed");
+        }
+        return body;
+      });
+    });
+  }
+
+  var host = el("div");
+  var target = script.dataset.target &&
+    document.getElementById(script.dataset.target);
+  if (target) {
+    target.appendChild(host);
+  } else {
+    script.parentNode.insertBefore(host, script.nextSibling);
+  }
+  var root = host.attachShadow ? host.attachShadow({ mode: "open" }) : host;
+  root.appendChild(el("style", { text: style }));
+  var box = el("div", { class: "box" });
+  root.appendChild(box);
+
+  function show(children) {
+    box.textContent = "";
+    children.forEach(function (c) { box.appendChild(c); });
+  }
+
+  function showError(err) {
+    show([
+      el("p", { class: "error", text: err.message || String(err) }),
+      el("button", { text: "Try again", onclick: start }),
+    ]);
+  }
+
+  var params;
+
+  // current identifies the invoice shown, so that polls for an abandoned
+  // invoice don't change the widget.
+  var current = 0;
+
+  function start() {
+    show([el("p", { text: "Loading…" })]);
+    getJSON(origin + "/.well-known/lnurlp/" + encodeURIComponent(user))
+      .then(function (p) {
+        params = p;
+        showForm();
+      })
+      .catch(showError);
+  }
+
+  function showForm() {
+    current++;
+    var min = Math.ceil(params.minSendable / 1000);
+    var max = Math.floor(params.maxSendable / 1000);
+    var selected = amounts.filter(function (a) {
+      return a >= min && a <= max;
+    })[0] || min;
+
+    var custom = el("input", {
+      type: "number", min: min, max: max, value: selected,
+      "aria-label": "Amount in sats",
+    });
+    var buttons = amounts.filter(function (a) {
+      return a >= min && a <= max;
+    }).map(function (a) {
+      return el("button", {
+        text: a + " sats",
+        class: a === selected ? "selected" : "",
+        onclick: function (e) {
+          custom.value = a;
+          buttons.forEach(function (b) { b.className = ""; });
+          e.target.className = "selected";
+        },
+      });
+    });
+
+    var children = [
+      el("h3", { text: "Tip " + user }),
+      el("div", { class: "amounts" }, buttons),
+      custom,
+    ];
+    var comment;
+    if (params.commentAllowed > 0) {
+      comment = el("textarea", {
+        maxlength: params.commentAllowed, rows: 2,
+        placeholder: "Comment (optional)",
+      });
+      children.push(comment);
+    }
+    children.push(el("button", {
+      class: "primary", text: "Tip ⚡",
+      onclick: function () {
+        var sats = parseInt(custom.value, 10);
+        if (!(sats >= min && sats <= max)) {
+          showError(new Error("Amount must be between " + min +
+            " and " + max + " sats"));
+          return;
+        }
+        requestInvoice(sats, comment ? comment.value : "");
+      },
+    }));
+    show(children);
+  }
+
+  function requestInvoice(sats, comment) {
+    show([el("p", { text: "Creating invoice…" })]);
+
+    var url = new URL(params.callback);
+    url.searchParams.set("amount", sats * 1000);
+    if (comment) {
+      url.searchParams.set("comment", comment);
+    }
+
+    getJSON(url.toString())
+      .then(function (invoice) {
+        showInvoice(invoice);
+        tryWebLN(invoice.pr);
+        if (invoice.paymentHash) {
+          waitForPayment(invoice, current);
+        }
+      })
+      .catch(showError);
+  }
+
+  function showInvoice(invoice) {
+    var qr = origin + "/tip/qr?pr=" + encodeURIComponent(invoice.pr);
+    show([
+      el("a", { href: "lightning:" + invoice.pr }, [
+        el("img", { src: qr, alt: "Invoice QR code" }),
+      ]),
+      el("p", {}, [
+        el("a", { href: "lightning:" + invoice.pr, text: "Open wallet" }),
+        document.createTextNode(" · "),
+        el("a", {
+          href: "#", text: "Copy invoice",
+          onclick: function (e) {
+            e.preventDefault();
+            navigator.clipboard.writeText(invoice.pr);
+          },
+        }),
+      ]),
+      el("p", { class: "pr", text: invoice.pr }),
+    ]);
+  }
+
+  function tryWebLN(pr) {
+    if (!window.webln) {
+      return;
+    }
+    window.webln.enable()
+      .then(function () { return window.webln.sendPayment(pr); })
+      .catch(function () {
+        // The visitor can still pay by scanning the QR code.
+      });
+  }
+
+  function waitForPayment(invoice, id) {
+    var url = origin + "/invoice/status/" + invoice.paymentHash +
+      "?wait=30";
+
+    function poll() {
+      if (id !== current) {
+        return;
+      }
+      getJSON(url)
+        .then(function (status) {
+          if (id !== current) {
+            return;
+          }
+          if (status.status === "settled") {
+            showThanks(invoice);
+          } else if (status.status === "expired") {
+            showError(new Error("The invoice expired"));
+          } else {
+            poll();
+          }
+        })
+        .catch(function () {
+          setTimeout(poll, 5000);
+        });
+    }
+    poll();
+  }
+
+  function showThanks(invoice) {
+    var message = invoice.successAction && invoice.successAction.message;
+    show([
+      el("h3", { text: "⚡ Received, thank you!" }),
+      el("p", { text: message || "" }),
+      el("button", { text: "Tip again", onclick: showForm }),
+    ]);
+  }
+
+  start();
+})();
@@ -0,0 +1,96 @@
+package widget
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/users"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	store, err := users.NewConfigStore([]string{"tips@example.com"})
+	if err != nil {
+		t.Fatalf("NewConfigStore: %v", err)
+	}
+	h := NewHandler(store)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /widget.js", h.HandleScript)
+	mux.HandleFunc("GET /tip/qr", h.HandleInvoiceQR)
+	mux.HandleFunc("GET /tip/{user}", h.HandlePage)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp, string(body)
+}
+
+func TestWidget(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/widget.js")
+	if resp.StatusCode != http.StatusOK ||
+		!strings.Contains(body, "/invoice/status/") {
+
+		t.Fatalf("unexpected script %d", resp.StatusCode)
+	}
+
+	resp, body = get(t, ts.URL+"/tip/Tips")
+	if resp.StatusCode != http.StatusOK ||
+		!strings.Contains(body, `data-user="tips"`) ||
+		resp.Header.Get("Content-Security-Policy") !=
+			"frame-ancestors *" {
+
+		t.Fatalf("unexpected tip page %d: %s", resp.StatusCode, body)
+	}
+
+	resp, _ = get(t, ts.URL+"/tip/unknown")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestInvoiceQR(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/tip/qr?pr=lnbc210n1pjtest")
+	if resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Content-Type") != "image/png" ||
+		!strings.HasPrefix(body, "\x89PNG") {
+
+		t.Fatalf("unexpected QR response %d", resp.StatusCode)
+	}
+
+	for _, pr := range []string{
+		"", "https://evil.example.com", "lnbc 21",
+		"lnbc" + strings.Repeat("q", maxInvoiceLength),
+	} {
+		resp, _ := get(t, ts.URL+"/tip/qr?pr="+pr)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d", pr,
+				resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package withdraw
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrUnknownVoucher is returned if no voucher with the given k1
+	// exists.
+	ErrUnknownVoucher = errors.New("unknown voucher")
+
+	// ErrVoucherExpired is returned if a voucher is claimed after its
+	// expiry.
+	ErrVoucherExpired = errors.New("voucher expired")
+
+	// ErrVoucherUsedUp is returned if all uses of a voucher have been
+	// claimed already.
+	ErrVoucherUsedUp = errors.New("voucher used up")
+
+	// ErrAlreadyPaid is returned if an invoice was already submitted for
+	// a withdrawal before.
+	ErrAlreadyPaid = errors.New("invoice already submitted")
+
+	// ErrDailyLimit is returned if paying out a voucher would exceed the
+	// daily spend limit.
+	ErrDailyLimit = errors.New("daily withdraw limit reached")
+
+	vouchersBucket = []byte("withdraw-vouchers")
+	paymentsBucket = []byte("withdraw-payments")
+	dailyBucket    = []byte("withdraw-daily")
+)
+
+// Voucher is an LNURL-withdraw link that can be claimed a limited number of
+// times until it expires.
+type Voucher struct {
+	K1          string    `json:"k1"`
+	AmountMsat  int64     `json:"amountMsat"`
+	Uses        int       `json:"uses"`
+	Used        int       `json:"used"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// Remaining returns the number of times the voucher can still be claimed.
+func (v *Voucher) Remaining() int {
+	return v.Uses - v.Used
+}
+
+// PaymentStatus is the state of a withdrawal payment.
+type PaymentStatus string
+
+const (
+	// StatusInFlight is the status of a claimed withdrawal whose payment
+	// hasn't completed yet.
+	StatusInFlight PaymentStatus = "in-flight"
+
+	// StatusSucceeded is the status of a withdrawal that was paid.
+	StatusSucceeded PaymentStatus = "succeeded"
+
+	// StatusFailed is the status of a withdrawal whose payment failed.
+	// The claimed use is given back to the voucher.
+	StatusFailed PaymentStatus = "failed"
+)
+
+// Payment records a claim of a voucher. It is keyed by the payment hash of
+// the submitted invoice so that no invoice can be paid twice.
+type Payment struct {
+	PaymentHash string        `json:"paymentHash"`
+	K1          string        `json:"k1"`
+	AmountMsat  int64         `json:"amountMsat"`
+	FeeMsat     int64         `json:"feeMsat"`
+	Reserved    int64         `json:"reserved"`
+	Day         string        `json:"day"`
+	Status      PaymentStatus `json:"status"`
+	CreatedAt   time.Time     `json:"createdAt"`
+}
+
+// Store persists vouchers and their payments in a bbolt database. All checks
+// that protect against double spends happen within a single transaction.
+type Store struct {
+	db *bbolt.DB
+}
+
+// NewStore creates the buckets used by the withdraw store.
+func NewStore(db *bbolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range [][]byte{
+			vouchersBucket, paymentsBucket, dailyBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create withdraw buckets: %w",
+			err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// AddVoucher stores a new voucher.
+func (s *Store) AddVoucher(v *Voucher) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(vouchersBucket)
+		if b.Get([]byte(v.K1)) != nil {
+			return fmt.Errorf("voucher %s already exists", v.K1)
+		}
+
+		return putJSON(b, []byte(v.K1), v)
+	})
+}
+
+// Voucher returns the voucher with the given k1.
+func (s *Store) Voucher(k1 string) (*Voucher, error) {
+	var v *Voucher
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		v, err = getVoucher(tx, k1)
+
+		return err
+	})
+
+	return v, err
+}
+
+// Vouchers returns all vouchers ordered by creation time.
+func (s *Store) Vouchers() ([]*Voucher, error) {
+	var vouchers []*Voucher
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(vouchersBucket).ForEach(func(_, v []byte) error {
+			voucher := &Voucher{}
+			if err := json.Unmarshal(v, voucher); err != nil {
+				return err
+			}
+			vouchers = append(vouchers, voucher)
+
+			return nil
+		})
+	})
+	sort.Slice(vouchers, func(i, j int) bool {
+		return vouchers[i].CreatedAt.Before(vouchers[j].CreatedAt)
+	})
+
+	return vouchers, err
+}
+
+// DeleteVoucher removes a voucher so it can't be claimed anymore.
+func (s *Store) DeleteVoucher(k1 string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(vouchersBucket)
+		if b.Get([]byte(k1)) == nil {
+			return ErrUnknownVoucher
+		}
+
+		return b.Delete([]byte(k1))
+	})
+}
+
+// Claim atomically consumes one use of a voucher for the invoice with the
+// given payment hash. The amount plus the maximum fee is reserved against the
+// daily limit. A daily limit of zero means unlimited.
+func (s *Store) Claim(k1, paymentHash string, amountMsat, maxFeeMsat,
+	dailyLimitMsat int64, now time.Time) error {
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		voucher, err := getVoucher(tx, k1)
+		if err != nil {
+			return err
+		}
+		if !voucher.ExpiresAt.IsZero() && now.After(voucher.ExpiresAt) {
+			return ErrVoucherExpired
+		}
+		if voucher.Remaining() <= 0 {
+			return ErrVoucherUsedUp
+		}
+		if amountMsat != voucher.AmountMsat {
+			return fmt.Errorf("invoice amount %d msat doesn't "+
+				"match voucher amount %d msat", amountMsat,
+				voucher.AmountMsat)
+		}
+
+		payments := tx.Bucket(paymentsBucket)
+		if payments.Get([]byte(paymentHash)) != nil {
+			return ErrAlreadyPaid
+		}
+
+		day := now.UTC().Format(time.DateOnly)
+		reserved := amountMsat + maxFeeMsat
+		spent := getAmount(tx, day)
+		if dailyLimitMsat > 0 && spent+reserved > dailyLimitMsat {
+			return ErrDailyLimit
+		}
+		if err := putAmount(tx, day, spent+reserved); err != nil {
+			return err
+		}
+
+		voucher.Used++
+		err = putJSON(tx.Bucket(vouchersBucket), []byte(k1), voucher)
+		if err != nil {
+			return err
+		}
+
+		return putJSON(payments, []byte(paymentHash), &Payment{
+			PaymentHash: paymentHash,
+			K1:          k1,
+			AmountMsat:  amountMsat,
+			Reserved:    reserved,
+			Day:         day,
+			Status:      StatusInFlight,
+			CreatedAt:   now,
+		})
+	})
+}
+
+// Succeed marks a claimed payment as paid and releases the part of the
+// reserved amount that wasn't spent on fees.
+func (s *Store) Succeed(paymentHash string, feeMsat int64) error {
+	return s.finish(paymentHash, func(tx *bbolt.Tx, p *Payment) error {
+		p.Status = StatusSucceeded
+		p.FeeMsat = feeMsat
+
+		unused := p.Reserved - p.AmountMsat - feeMsat
+		return putAmount(tx, p.Day, getAmount(tx, p.Day)-unused)
+	})
+}
+
+// Fail marks a claimed payment as failed. The use of the voucher and the
+// reserved amount are given back, so the voucher can be claimed again.
+func (s *Store) Fail(paymentHash string) error {
+	return s.finish(paymentHash, func(tx *bbolt.Tx, p *Payment) error {
+		p.Status = StatusFailed
+
+		err := putAmount(tx, p.Day, getAmount(tx, p.Day)-p.Reserved)
+		if err != nil {
+			return err
+		}
+
+		voucher, err := getVoucher(tx, p.K1)
+		if errors.Is(err, ErrUnknownVoucher) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		voucher.Used--
+
+		return putJSON(
+			tx.Bucket(vouchersBucket), []byte(voucher.K1), voucher,
+		)
+	})
+}
+
+// Payment returns the payment for the given payment hash.
+func (s *Store) Payment(paymentHash string) (*Payment, error) {
+	var p *Payment
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		p, err = getPayment(tx, paymentHash)
+
+		return err
+	})
+
+	return p, err
+}
+
+// InFlight returns all payments whose outcome isn't known yet.
+func (s *Store) InFlight() ([]*Payment, error) {
+	var payments []*Payment
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(paymentsBucket).ForEach(func(_, v []byte) error {
+			p := &Payment{}
+			if err := json.Unmarshal(v, p); err != nil {
+				return err
+			}
+			if p.Status == StatusInFlight {
+				payments = append(payments, p)
+			}
+
+			return nil
+		})
+	})
+
+	return payments, err
+}
+
+func (s *Store) finish(paymentHash string,
+	update func(*bbolt.Tx, *Payment) error) error {
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		p, err := getPayment(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+		if p.Status != StatusInFlight {
+			return fmt.Errorf("payment %s already %s", paymentHash,
+				p.Status)
+		}
+		if err := update(tx, p); err != nil {
+			return err
+		}
+
+		return putJSON(
+			tx.Bucket(paymentsBucket), []byte(paymentHash), p,
+		)
+	})
+}
+
+func getVoucher(tx *bbolt.Tx, k1 string) (*Voucher, error) {
+	raw := tx.Bucket(vouchersBucket).Get([]byte(k1))
+	if raw == nil {
+		return nil, ErrUnknownVoucher
+	}
+
+	v := &Voucher{}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func getPayment(tx *bbolt.Tx, paymentHash string) (*Payment, error) {
+	raw := tx.Bucket(paymentsBucket).Get([]byte(paymentHash))
+	if raw == nil {
+		return nil, fmt.Errorf("unknown payment %s", paymentHash)
+	}
+
+	p := &Payment{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func getAmount(tx *bbolt.Tx, day string) int64 {
+	raw := tx.Bucket(dailyBucket).Get([]byte(day))
+	if len(raw) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(raw))
+}
+
+func putAmount(tx *bbolt.Tx, day string, amount int64) error {
+	if amount < 0 {
+		amount = 0
+	}
+
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], uint64(amount))
+
+	return tx.Bucket(dailyBucket).Put([]byte(day), raw[:])
+}
+
+func putJSON(b *bbolt.Bucket, key []byte, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key, raw)
+}
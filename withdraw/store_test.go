@@ -0,0 +1,155 @@
+package withdraw
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := bbolt.Open(
+		filepath.Join(t.TempDir(), "test.db"), 0600, nil,
+	)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	s, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	return s
+}
+
+func TestStore_ClaimUses(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	err := s.AddVoucher(&Voucher{
+		K1: "k1", AmountMsat: 1000, Uses: 2, CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("AddVoucher: %v", err)
+	}
+
+	if err := s.Claim("k1", "h1", 1000, 10, 0, now); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+
+	// The same invoice must never be paid twice.
+	err = s.Claim("k1", "h1", 1000, 10, 0, now)
+	if !errors.Is(err, ErrAlreadyPaid) {
+		t.Fatalf("expected ErrAlreadyPaid, got %v", err)
+	}
+
+	if err := s.Claim("k1", "h2", 1000, 10, 0, now); err != nil {
+		t.Fatalf("second claim: %v", err)
+	}
+	err = s.Claim("k1", "h3", 1000, 10, 0, now)
+	if !errors.Is(err, ErrVoucherUsedUp) {
+		t.Fatalf("expected ErrVoucherUsedUp, got %v", err)
+	}
+
+	// A failed payment gives the use back, a succeeded one doesn't.
+	if err := s.Fail("h1"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	if err := s.Succeed("h2", 3); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+	if err := s.Fail("h2"); err == nil {
+		t.Fatalf("expected error when finishing a payment twice")
+	}
+
+	v, err := s.Voucher("k1")
+	if err != nil {
+		t.Fatalf("Voucher: %v", err)
+	}
+	if v.Remaining() != 1 {
+		t.Fatalf("expected 1 remaining use, got %d", v.Remaining())
+	}
+
+	// The failed invoice stays recorded and can't be resubmitted.
+	err = s.Claim("k1", "h1", 1000, 10, 0, now)
+	if !errors.Is(err, ErrAlreadyPaid) {
+		t.Fatalf("expected ErrAlreadyPaid, got %v", err)
+	}
+}
+
+func TestStore_ClaimChecks(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	err := s.AddVoucher(&Voucher{
+		K1: "expired", AmountMsat: 1000, Uses: 1,
+		ExpiresAt: now.Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("AddVoucher: %v", err)
+	}
+	err = s.Claim("expired", "h1", 1000, 0, 0, now)
+	if !errors.Is(err, ErrVoucherExpired) {
+		t.Fatalf("expected ErrVoucherExpired, got %v", err)
+	}
+
+	err = s.Claim("unknown", "h1", 1000, 0, 0, now)
+	if !errors.Is(err, ErrUnknownVoucher) {
+		t.Fatalf("expected ErrUnknownVoucher, got %v", err)
+	}
+
+	err = s.AddVoucher(&Voucher{K1: "k1", AmountMsat: 1000, Uses: 5})
+	if err != nil {
+		t.Fatalf("AddVoucher: %v", err)
+	}
+	if err := s.Claim("k1", "h1", 2000, 0, 0, now); err == nil {
+		t.Fatalf("expected error for amount mismatch")
+	}
+}
+
+func TestStore_DailyLimit(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	err := s.AddVoucher(&Voucher{K1: "k1", AmountMsat: 1000, Uses: 10})
+	if err != nil {
+		t.Fatalf("AddVoucher: %v", err)
+	}
+
+	// Each claim reserves amount plus max fee: 1100 msat.
+	if err := s.Claim("k1", "h1", 1000, 100, 2150, now); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	err = s.Claim("k1", "h2", 1000, 100, 2150, now)
+	if !errors.Is(err, ErrDailyLimit) {
+		t.Fatalf("expected ErrDailyLimit, got %v", err)
+	}
+
+	// Unused fee reserves are released once the payment succeeded.
+	if err := s.Succeed("h1", 0); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+	if err := s.Claim("k1", "h2", 1000, 100, 2150, now); err != nil {
+		t.Fatalf("claim after release: %v", err)
+	}
+
+	// Failed payments release their whole reservation.
+	if err := s.Fail("h2"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	if err := s.Claim("k1", "h3", 1000, 100, 2150, now); err != nil {
+		t.Fatalf("claim after failure: %v", err)
+	}
+
+	// The limit is per day.
+	err = s.Claim("k1", "h4", 1000, 100, 2150, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("claim on next day: %v", err)
+	}
+}
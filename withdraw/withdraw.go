@@ -0,0 +1,504 @@
+package withdraw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/hieblmi/go-host-lnaddr/lnurl"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	log btclog.Logger
+)
+
+// SetLogger allows the main package to provide a shared logger.
+func SetLogger(l btclog.Logger) { log = l }
+
+const (
+	// defaultPaymentTimeout is the time lnd may spend on paying a
+	// withdrawal invoice.
+	defaultPaymentTimeout = time.Minute
+
+	// defaultDescription is shown by wallets if a voucher has no
+	// description.
+	defaultDescription = "Withdraw voucher"
+)
+
+// Config configures LNURL-withdraw vouchers. Vouchers are only served if a
+// macaroon that is allowed to pay invoices is configured.
+type Config struct {
+	// MacaroonPath is the path of a macaroon with offchain:read and
+	// offchain:write permissions, e.g. admin.macaroon.
+	MacaroonPath string `json:"MacaroonPath" toml:"MacaroonPath"`
+
+	// MaxVoucherMsat is the largest amount a single voucher may be
+	// created for.
+	MaxVoucherMsat int64 `json:"MaxVoucherMsat" toml:"MaxVoucherMsat"`
+
+	// MaxFeeMsat is the largest routing fee paid for a withdrawal.
+	MaxFeeMsat int64 `json:"MaxFeeMsat" toml:"MaxFeeMsat"`
+
+	// DailyLimitMsat is the largest amount, including fees, that is
+	// paid out per day (UTC) over all vouchers. Zero means unlimited.
+	DailyLimitMsat int64 `json:"DailyLimitMsat" toml:"DailyLimitMsat"`
+
+	// PaymentTimeoutSec is the time lnd may spend on paying a
+	// withdrawal. Defaults to one minute.
+	PaymentTimeoutSec int `json:"PaymentTimeoutSec" toml:"PaymentTimeoutSec"`
+}
+
+// Manager serves LUD-03 withdraw requests for the stored vouchers and pays the
+// submitted invoices.
+type Manager struct {
+	Cfg *ManagerConfig
+}
+
+type ManagerConfig struct {
+	Config
+
+	Store        *Store
+	LndClient    lnrpc.LightningClient
+	RouterClient routerrpc.RouterClient
+
+	// ExternalURL is the public base URL of this server.
+	ExternalURL string
+}
+
+// withdrawRequest is the LUD-03 response for a voucher.
+type withdrawRequest struct {
+	Tag                string `json:"tag"`
+	Callback           string `json:"callback"`
+	K1                 string `json:"k1"`
+	DefaultDescription string `json:"defaultDescription"`
+	MinWithdrawable    int64  `json:"minWithdrawable"`
+	MaxWithdrawable    int64  `json:"maxWithdrawable"`
+}
+
+func NewManager(cfg *ManagerConfig) *Manager {
+	return &Manager{
+		Cfg: cfg,
+	}
+}
+
+// voucherURL returns the URL of the withdraw request for the given k1.
+func (m *Manager) voucherURL(k1 string) (string, error) {
+	return url.JoinPath(m.Cfg.ExternalURL, "withdraw", k1)
+}
+
+// HandleWithdrawRequest serves the withdrawRequest of a voucher.
+func (m *Manager) HandleWithdrawRequest(w http.ResponseWriter,
+	r *http.Request) {
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	voucher, err := m.Cfg.Store.Voucher(r.PathValue("k1"))
+	if errors.Is(err, ErrUnknownVoucher) {
+		lnurl.WriteError(w, http.StatusNotFound, "Unknown voucher.")
+		return
+	}
+	if err != nil {
+		log.Errorf("Unable to load voucher: %v", err)
+		lnurl.WriteError(w, http.StatusInternalServerError,
+			"Unable to load voucher.")
+		return
+	}
+	if !voucher.ExpiresAt.IsZero() && time.Now().After(voucher.ExpiresAt) {
+		lnurl.WriteError(w, http.StatusGone, "Voucher expired.")
+		return
+	}
+	if voucher.Remaining() <= 0 {
+		lnurl.WriteError(w, http.StatusGone, "Voucher used up.")
+		return
+	}
+
+	callback, err := url.JoinPath(m.Cfg.ExternalURL, "withdraw", "callback")
+	if err != nil {
+		log.Errorf("Invalid external URL: %v", err)
+		lnurl.WriteError(w, http.StatusInternalServerError,
+			"Invalid callback.")
+		return
+	}
+
+	description := voucher.Description
+	if description == "" {
+		description = defaultDescription
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(withdrawRequest{
+		Tag:                "withdrawRequest",
+		Callback:           callback,
+		K1:                 voucher.K1,
+		DefaultDescription: description,
+		MinWithdrawable:    voucher.AmountMsat,
+		MaxWithdrawable:    voucher.AmountMsat,
+	})
+}
+
+// HandleCallback claims a voucher for the submitted invoice and pays it. As
+// required by LUD-03, the response is sent before the payment completes.
+func (m *Manager) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	k1 := r.URL.Query().Get("k1")
+	pr := r.URL.Query().Get("pr")
+	if k1 == "" || pr == "" {
+		lnurl.WriteError(w, http.StatusBadRequest,
+			"Mandatory URL Query parameters 'k1' and 'pr' are "+
+				"required.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	payReq, err := m.Cfg.LndClient.DecodePayReq(
+		ctx, &lnrpc.PayReqString{PayReq: pr},
+	)
+	if err != nil {
+		lnurl.WriteError(w, http.StatusBadRequest, "Invalid invoice.")
+		return
+	}
+
+	expiry := time.Unix(payReq.Timestamp, 0).Add(
+		time.Duration(payReq.Expiry) * time.Second,
+	)
+	if time.Now().After(expiry) {
+		lnurl.WriteError(w, http.StatusBadRequest, "Invoice expired.")
+		return
+	}
+
+	err = m.Cfg.Store.Claim(
+		k1, payReq.PaymentHash, payReq.NumMsat, m.Cfg.MaxFeeMsat,
+		m.Cfg.DailyLimitMsat, time.Now(),
+	)
+	switch {
+	case errors.Is(err, ErrUnknownVoucher):
+		lnurl.WriteError(w, http.StatusNotFound, "Unknown voucher.")
+		return
+
+	case errors.Is(err, ErrVoucherExpired),
+		errors.Is(err, ErrVoucherUsedUp),
+		errors.Is(err, ErrAlreadyPaid):
+
+		lnurl.WriteError(w, http.StatusGone, capitalize(err))
+		return
+
+	case errors.Is(err, ErrDailyLimit):
+		lnurl.WriteError(w, http.StatusServiceUnavailable,
+			"Withdrawals are paused, please try again tomorrow.")
+		return
+
+	case err != nil:
+		lnurl.WriteError(w, http.StatusBadRequest, capitalize(err))
+		return
+	}
+
+	log.Infof("Voucher %s claimed for invoice %s (%d msat)", k1,
+		payReq.PaymentHash, payReq.NumMsat)
+	go m.pay(payReq.PaymentHash, pr)
+
+	lnurl.WriteOK(w)
+}
+
+// pay pays a claimed invoice and records the outcome.
+func (m *Manager) pay(paymentHash, pr string) {
+	timeout := defaultPaymentTimeout
+	if m.Cfg.PaymentTimeoutSec > 0 {
+		timeout = time.Duration(m.Cfg.PaymentTimeoutSec) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), timeout+time.Minute,
+	)
+	defer cancel()
+
+	stream, err := m.Cfg.RouterClient.SendPaymentV2(
+		ctx, &routerrpc.SendPaymentRequest{
+			PaymentRequest:    pr,
+			FeeLimitMsat:      m.Cfg.MaxFeeMsat,
+			TimeoutSeconds:    int32(timeout.Seconds()),
+			NoInflightUpdates: true,
+		},
+	)
+	if err != nil {
+		// The payment was never started, so the claim can safely be
+		// given back.
+		log.Warnf("Unable to pay withdrawal %s: %v", paymentHash, err)
+		m.finish(paymentHash, nil)
+
+		return
+	}
+
+	m.track(paymentHash, stream.Recv)
+}
+
+// track waits for the final state of a payment. If the stream breaks before
+// that, the payment stays in-flight and is resolved by ResumePayments.
+func (m *Manager) track(paymentHash string,
+	recv func() (*lnrpc.Payment, error)) {
+
+	received := false
+	for {
+		payment, err := recv()
+		if err != nil && !received {
+			// lnd rejects some payments before starting them, e.g.
+			// already paid invoices, and reports that on the first
+			// Recv. So we ask lnd whether the payment exists at all
+			// before we keep it in-flight.
+			received = true
+			recv, err = m.trackPayment(paymentHash)
+			if err == nil {
+				payment, err = recv()
+			}
+			if status.Code(err) == codes.NotFound {
+				log.Warnf("Withdrawal %s wasn't started by lnd",
+					paymentHash)
+				m.finish(paymentHash, nil)
+
+				return
+			}
+		}
+		if err != nil {
+			log.Warnf("Lost track of withdrawal %s, it is resolved "+
+				"on the next start: %v", paymentHash, err)
+			return
+		}
+		received = true
+
+		switch payment.Status {
+		case lnrpc.Payment_SUCCEEDED, lnrpc.Payment_FAILED:
+			m.finish(paymentHash, payment)
+			return
+		}
+	}
+}
+
+// trackPayment subscribes to the updates of a payment. lnd fails the first
+// Recv with codes.NotFound if it never started the payment.
+func (m *Manager) trackPayment(paymentHash string) (
+	func() (*lnrpc.Payment, error), error) {
+
+	hash, err := hex.DecodeString(paymentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := m.Cfg.RouterClient.TrackPaymentV2(
+		context.Background(), &routerrpc.TrackPaymentRequest{
+			PaymentHash:       hash,
+			NoInflightUpdates: true,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.Recv, nil
+}
+
+// finish records the final state of a payment. A nil payment means the
+// payment failed before it was started.
+func (m *Manager) finish(paymentHash string, payment *lnrpc.Payment) {
+	var err error
+	if payment != nil && payment.Status == lnrpc.Payment_SUCCEEDED {
+		log.Infof("Withdrawal %s paid with a fee of %d msat",
+			paymentHash, payment.FeeMsat)
+		err = m.Cfg.Store.Succeed(paymentHash, payment.FeeMsat)
+	} else {
+		reason := "not started"
+		if payment != nil {
+			reason = payment.FailureReason.String()
+		}
+		log.Infof("Withdrawal %s failed: %s", paymentHash, reason)
+		err = m.Cfg.Store.Fail(paymentHash)
+	}
+	if err != nil {
+		log.Errorf("Unable to record outcome of withdrawal %s: %v",
+			paymentHash, err)
+	}
+}
+
+// ResumePayments resolves the withdrawals that were in-flight when the server
+// stopped.
+func (m *Manager) ResumePayments() error {
+	payments, err := m.Cfg.Store.InFlight()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range payments {
+		recv, err := m.trackPayment(p.PaymentHash)
+		if err != nil {
+			return fmt.Errorf("unable to track withdrawal %s: %w",
+				p.PaymentHash, err)
+		}
+
+		go m.track(p.PaymentHash, recv)
+	}
+
+	return nil
+}
+
+// createRequest is the body of an admin request to create a voucher.
+type createRequest struct {
+	AmountMsat   int64  `json:"amountMsat"`
+	Uses         int    `json:"uses"`
+	ExpiresInSec int64  `json:"expiresInSec"`
+	Description  string `json:"description"`
+}
+
+// voucherResponse is a voucher as returned by the admin API.
+type voucherResponse struct {
+	*Voucher
+
+	URL   string `json:"url"`
+	LNURL string `json:"lnurl"`
+}
+
+// CreateVoucher creates and stores a new voucher.
+func (m *Manager) CreateVoucher(amountMsat int64, uses int,
+	expiresIn time.Duration, description string) (*Voucher, error) {
+
+	if amountMsat <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if m.Cfg.MaxVoucherMsat > 0 && amountMsat > m.Cfg.MaxVoucherMsat {
+		return nil, fmt.Errorf("amount exceeds the maximum of %d msat",
+			m.Cfg.MaxVoucherMsat)
+	}
+	if uses < 1 {
+		return nil, errors.New("uses must be at least 1")
+	}
+
+	var k1 [32]byte
+	if _, err := rand.Read(k1[:]); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	voucher := &Voucher{
+		K1:          hex.EncodeToString(k1[:]),
+		AmountMsat:  amountMsat,
+		Uses:        uses,
+		Description: description,
+		CreatedAt:   now,
+	}
+	if expiresIn > 0 {
+		voucher.ExpiresAt = now.Add(expiresIn)
+	}
+
+	if err := m.Cfg.Store.AddVoucher(voucher); err != nil {
+		return nil, err
+	}
+
+	return voucher, nil
+}
+
+func (m *Manager) toResponse(v *Voucher) (*voucherResponse, error) {
+	u, err := m.voucherURL(v.K1)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := lnurl.Encode(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &voucherResponse{Voucher: v, URL: u, LNURL: encoded}, nil
+}
+
+// HandleAdmin serves the admin API to create, list and delete vouchers. It
+// must be protected by the caller.
+func (m *Manager) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodPost && r.PathValue("k1") == "":
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		voucher, err := m.CreateVoucher(
+			req.AmountMsat, req.Uses,
+			time.Duration(req.ExpiresInSec)*time.Second,
+			req.Description,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := m.toResponse(voucher)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Created withdraw voucher %s over %d msat with %d "+
+			"uses", voucher.K1, voucher.AmountMsat, voucher.Uses)
+
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(resp)
+
+	case r.Method == http.MethodGet && r.PathValue("k1") == "":
+		vouchers, err := m.Cfg.Store.Vouchers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]*voucherResponse, 0, len(vouchers))
+		for _, v := range vouchers {
+			vr, err := m.toResponse(v)
+			if err != nil {
+				http.Error(w, err.Error(),
+					http.StatusInternalServerError)
+				return
+			}
+			resp = append(resp, vr)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+
+	case r.Method == http.MethodDelete && r.PathValue("k1") != "":
+		err := m.Cfg.Store.DeleteVoucher(r.PathValue("k1"))
+		if errors.Is(err, ErrUnknownVoucher) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// capitalize turns an error into a sentence for LUD-06 error responses.
+func capitalize(err error) string {
+	msg := err.Error()
+	if msg == "" {
+		return msg
+	}
+	if msg[0] >= 'a' && msg[0] <= 'z' {
+		msg = string(msg[0]-'a'+'A') + msg[1:]
+	}
+
+	return msg + "."
+}
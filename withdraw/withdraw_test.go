@@ -0,0 +1,368 @@
+package withdraw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	SetLogger(btclog.Disabled)
+}
+
+// mockLightningClient decodes every payment request to a fixed invoice.
+type mockLightningClient struct {
+	lnrpc.LightningClient
+
+	payReq *lnrpc.PayReq
+}
+
+func (m *mockLightningClient) DecodePayReq(_ context.Context,
+	in *lnrpc.PayReqString, _ ...grpc.CallOption) (*lnrpc.PayReq, error) {
+
+	if in.PayReq == "invalid" {
+		return nil, errors.New("invalid payment request")
+	}
+
+	return m.payReq, nil
+}
+
+// mockRouterClient records payments and completes them with a fixed status.
+// If recvErr is set, lnd rejects the payments on the first Recv instead and
+// doesn't know them when they are tracked.
+type mockRouterClient struct {
+	routerrpc.RouterClient
+
+	mu       sync.Mutex
+	payments []*routerrpc.SendPaymentRequest
+	status   lnrpc.Payment_PaymentStatus
+	recvErr  error
+	done     chan struct{}
+}
+
+func (m *mockRouterClient) SendPaymentV2(_ context.Context,
+	in *routerrpc.SendPaymentRequest, _ ...grpc.CallOption) (
+	routerrpc.Router_SendPaymentV2Client, error) {
+
+	m.mu.Lock()
+	m.payments = append(m.payments, in)
+	m.mu.Unlock()
+
+	return &paymentStream{
+		payment: &lnrpc.Payment{Status: m.status, FeeMsat: 5},
+		err:     m.recvErr,
+		done:    m.done,
+	}, nil
+}
+
+func (m *mockRouterClient) TrackPaymentV2(_ context.Context,
+	_ *routerrpc.TrackPaymentRequest, _ ...grpc.CallOption) (
+	routerrpc.Router_TrackPaymentV2Client, error) {
+
+	if m.recvErr != nil {
+		return &trackStream{
+			err: status.Error(codes.NotFound, "payment isn't initiated"),
+		}, nil
+	}
+
+	return &trackStream{
+		payment: &lnrpc.Payment{Status: m.status, FeeMsat: 5},
+	}, nil
+}
+
+type paymentStream struct {
+	routerrpc.Router_SendPaymentV2Client
+
+	payment *lnrpc.Payment
+	err     error
+	done    chan struct{}
+}
+
+func (p *paymentStream) Recv() (*lnrpc.Payment, error) {
+	defer func() { p.done <- struct{}{} }()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return p.payment, nil
+}
+
+type trackStream struct {
+	routerrpc.Router_TrackPaymentV2Client
+
+	payment *lnrpc.Payment
+	err     error
+}
+
+func (p *trackStream) Recv() (*lnrpc.Payment, error) {
+	return p.payment, p.err
+}
+
+func newTestManager(t *testing.T, status lnrpc.Payment_PaymentStatus) (
+	*Manager, *mockRouterClient, *httptest.Server) {
+
+	t.Helper()
+
+	router := &mockRouterClient{
+		status: status,
+		done:   make(chan struct{}, 10),
+	}
+	mgr := NewManager(&ManagerConfig{
+		Config: Config{
+			MaxVoucherMsat: 100_000,
+			MaxFeeMsat:     100,
+		},
+		Store: newTestStore(t),
+		LndClient: &mockLightningClient{payReq: &lnrpc.PayReq{
+			PaymentHash: "abcd",
+			NumMsat:     21_000,
+			Timestamp:   time.Now().Unix(),
+			Expiry:      3600,
+		}},
+		RouterClient: router,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /withdraw/callback", mgr.HandleCallback)
+	mux.HandleFunc("GET /withdraw/{k1}", mgr.HandleWithdrawRequest)
+	mux.HandleFunc("/admin/withdraw", mgr.HandleAdmin)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	mgr.Cfg.ExternalURL = ts.URL
+
+	return mgr, router, ts
+}
+
+func getJSON(t *testing.T, u string, v interface{}) int {
+	t.Helper()
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("GET %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	return resp.StatusCode
+}
+
+// waitForStatus waits for the outcome of a payment to be recorded.
+func waitForStatus(t *testing.T, s *Store, hash string,
+	status PaymentStatus) *Payment {
+
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p, err := s.Payment(hash)
+		if err == nil && p.Status == status {
+			return p
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("payment %s not %s: %+v", hash, status, p)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithdraw_Flow(t *testing.T) {
+	mgr, router, ts := newTestManager(t, lnrpc.Payment_SUCCEEDED)
+
+	// Create a voucher through the admin API.
+	resp, err := http.Post(
+		ts.URL+"/admin/withdraw", "application/json",
+		strings.NewReader(`{"amountMsat":21000,"uses":1,`+
+			`"expiresInSec":600,"description":"meetup"}`),
+	)
+	if err != nil {
+		t.Fatalf("create voucher: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var created voucherResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.HasPrefix(created.LNURL, "LNURL1") {
+		t.Fatalf("unexpected LNURL %q", created.LNURL)
+	}
+
+	// The wallet fetches the withdraw request.
+	var req withdrawRequest
+	if code := getJSON(t, created.URL, &req); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if req.Tag != "withdrawRequest" || req.K1 != created.K1 ||
+		req.MaxWithdrawable != 21_000 ||
+		req.DefaultDescription != "meetup" {
+
+		t.Fatalf("unexpected withdraw request: %+v", req)
+	}
+
+	// And submits its invoice.
+	q := url.Values{"k1": {req.K1}, "pr": {"lnbc210n1test"}}
+	var status map[string]string
+	code := getJSON(t, req.Callback+"?"+q.Encode(), &status)
+	if code != http.StatusOK || status["status"] != "OK" {
+		t.Fatalf("unexpected callback response %d: %v", code, status)
+	}
+
+	<-router.done
+	router.mu.Lock()
+	if len(router.payments) != 1 ||
+		router.payments[0].FeeLimitMsat != 100 {
+
+		t.Fatalf("unexpected payments: %v", router.payments)
+	}
+	router.mu.Unlock()
+
+	// Submitting the invoice again must not pay it twice.
+	code = getJSON(t, req.Callback+"?"+q.Encode(), &status)
+	if code != http.StatusGone || status["status"] != "ERROR" {
+		t.Fatalf("expected error on double spend, got %d: %v", code,
+			status)
+	}
+
+	// The voucher is used up now.
+	code = getJSON(t, created.URL, &status)
+	if code != http.StatusGone {
+		t.Fatalf("expected used up voucher, got %d", code)
+	}
+
+	p := waitForStatus(t, mgr.Cfg.Store, "abcd", StatusSucceeded)
+	if p.FeeMsat != 5 {
+		t.Fatalf("unexpected payment: %+v", p)
+	}
+}
+
+func TestWithdraw_FailedPaymentRestoresUse(t *testing.T) {
+	mgr, router, ts := newTestManager(t, lnrpc.Payment_FAILED)
+
+	v, err := mgr.CreateVoucher(21_000, 1, 0, "")
+	if err != nil {
+		t.Fatalf("CreateVoucher: %v", err)
+	}
+
+	q := url.Values{"k1": {v.K1}, "pr": {"lnbc210n1test"}}
+	var status map[string]string
+	code := getJSON(t, ts.URL+"/withdraw/callback?"+q.Encode(), &status)
+	if code != http.StatusOK {
+		t.Fatalf("unexpected callback response %d: %v", code, status)
+	}
+	<-router.done
+	waitForStatus(t, mgr.Cfg.Store, "abcd", StatusFailed)
+
+	v, err = mgr.Cfg.Store.Voucher(v.K1)
+	if err != nil {
+		t.Fatalf("Voucher: %v", err)
+	}
+	if v.Remaining() != 1 {
+		t.Fatalf("expected the use to be restored")
+	}
+}
+
+func TestWithdraw_RejectedPaymentRestoresUse(t *testing.T) {
+	mgr, router, ts := newTestManager(t, lnrpc.Payment_SUCCEEDED)
+	router.recvErr = errors.New("invoice is already paid")
+
+	v, err := mgr.CreateVoucher(21_000, 1, 0, "")
+	if err != nil {
+		t.Fatalf("CreateVoucher: %v", err)
+	}
+
+	q := url.Values{"k1": {v.K1}, "pr": {"lnbc210n1test"}}
+	var status map[string]string
+	code := getJSON(t, ts.URL+"/withdraw/callback?"+q.Encode(), &status)
+	if code != http.StatusOK {
+		t.Fatalf("unexpected callback response %d: %v", code, status)
+	}
+	<-router.done
+
+	// lnd never started the payment, so the claim is given back instead
+	// of staying in-flight.
+	waitForStatus(t, mgr.Cfg.Store, "abcd", StatusFailed)
+
+	v, err = mgr.Cfg.Store.Voucher(v.K1)
+	if err != nil {
+		t.Fatalf("Voucher: %v", err)
+	}
+	if v.Remaining() != 1 {
+		t.Fatalf("expected the use to be restored")
+	}
+}
+
+func TestWithdraw_ResumeUnknownPayment(t *testing.T) {
+	mgr, router, _ := newTestManager(t, lnrpc.Payment_SUCCEEDED)
+	router.recvErr = errors.New("payment isn't initiated")
+
+	v, err := mgr.CreateVoucher(21_000, 1, 0, "")
+	if err != nil {
+		t.Fatalf("CreateVoucher: %v", err)
+	}
+	err = mgr.Cfg.Store.Claim(v.K1, "abcd", 21_000, 100, 0, time.Now())
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	// The server stopped before the payment was sent to lnd.
+	if err := mgr.ResumePayments(); err != nil {
+		t.Fatalf("ResumePayments: %v", err)
+	}
+	waitForStatus(t, mgr.Cfg.Store, "abcd", StatusFailed)
+}
+
+func TestWithdraw_Validation(t *testing.T) {
+	mgr, _, ts := newTestManager(t, lnrpc.Payment_SUCCEEDED)
+
+	if _, err := mgr.CreateVoucher(200_000, 1, 0, ""); err == nil {
+		t.Fatalf("expected error for voucher above maximum")
+	}
+	if _, err := mgr.CreateVoucher(1000, 0, 0, ""); err == nil {
+		t.Fatalf("expected error for zero uses")
+	}
+
+	v, err := mgr.CreateVoucher(1000, 1, 0, "")
+	if err != nil {
+		t.Fatalf("CreateVoucher: %v", err)
+	}
+
+	// The decoded invoice is for 21000 msat, not the voucher's 1000.
+	var status map[string]string
+	q := url.Values{"k1": {v.K1}, "pr": {"lnbc210n1test"}}
+	code := getJSON(t, ts.URL+"/withdraw/callback?"+q.Encode(), &status)
+	if code != http.StatusBadRequest || status["status"] != "ERROR" {
+		t.Fatalf("expected amount mismatch error, got %d: %v", code,
+			status)
+	}
+
+	q.Set("pr", "invalid")
+	code = getJSON(t, ts.URL+"/withdraw/callback?"+q.Encode(), &status)
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected invalid invoice error, got %d", code)
+	}
+
+	code = getJSON(t, ts.URL+"/withdraw/unknown", &status)
+	if code != http.StatusNotFound {
+		t.Fatalf("expected unknown voucher error, got %d", code)
+	}
+}